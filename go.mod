@@ -12,6 +12,7 @@ require (
 	github.com/linuxdeepin/go-lib v0.0.0-20230406092403-b4b4282fc513
 	github.com/linuxdeepin/go-x11-client v0.0.0-20230329071904-56c906e1ab5d
 	github.com/stretchr/testify v1.8.2
+	golang.org/x/sys v0.3.0
 	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
 )
@@ -24,6 +25,5 @@ require (
 	github.com/youpy/go-riff v0.1.0 // indirect
 	github.com/youpy/go-wav v0.3.2 // indirect
 	github.com/zaf/g711 v0.0.0-20220109202201-cf0017bf0359 // indirect
-	golang.org/x/sys v0.3.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -12,44 +12,268 @@ import (
 
 func (v *XSManager) GetExportedMethods() dbusutil.ExportedMethods {
 	return dbusutil.ExportedMethods{
+		{
+			Name:    "ApplyOemScaleRecommendation",
+			Fn:      v.ApplyOemScaleRecommendation,
+			OutArgs: []string{"applied"},
+		},
+		{
+			Name:   "ApplyScalePercent",
+			Fn:     v.ApplyScalePercent,
+			InArgs: []string{"percent"},
+		},
+		{
+			Name:   "ApplyUserScaleFromGreeter",
+			Fn:     v.ApplyUserScaleFromGreeter,
+			InArgs: []string{"username"},
+		},
+		{
+			Name:    "CancelTemporaryCursorSize",
+			Fn:      v.CancelTemporaryCursorSize,
+			OutArgs: []string{"cancelled"},
+		},
+		{
+			Name:    "CompareScaleToReference",
+			Fn:      v.CompareScaleToReference,
+			InArgs:  []string{"ref"},
+			OutArgs: []string{"diffs"},
+		},
+		{
+			Name:    "ComputeScaleDerivatives",
+			Fn:      v.ComputeScaleDerivatives,
+			InArgs:  []string{"factor"},
+			OutArgs: []string{"derivatives"},
+		},
+		{
+			Name: "EmitCurrentScaleState",
+			Fn:   v.EmitCurrentScaleState,
+		},
+		{
+			Name:   "ExportScaleConfig",
+			Fn:     v.ExportScaleConfig,
+			InArgs: []string{"path"},
+		},
+		{
+			Name:    "GetAppScaleForScreen",
+			Fn:      v.GetAppScaleForScreen,
+			InArgs:  []string{"screen"},
+			OutArgs: []string{"factor"},
+		},
+		{
+			Name:    "GetAppsNeedingRestart",
+			Fn:      v.GetAppsNeedingRestart,
+			OutArgs: []string{"apps"},
+		},
+		{
+			Name:    "GetCachedScreenFactors",
+			Fn:      v.GetCachedScreenFactors,
+			OutArgs: []string{"factors"},
+		},
 		{
 			Name:    "GetColor",
 			Fn:      v.GetColor,
 			InArgs:  []string{"prop"},
 			OutArgs: []string{"outArg0"},
 		},
+		{
+			Name:    "GetCursorPreviewInfo",
+			Fn:      v.GetCursorPreviewInfo,
+			InArgs:  []string{"scale"},
+			OutArgs: []string{"themeName", "size"},
+		},
+		{
+			Name:    "GetCursorSize",
+			Fn:      v.GetCursorSize,
+			OutArgs: []string{"size"},
+		},
+		{
+			Name:    "GetDistinctScaleFactors",
+			Fn:      v.GetDistinctScaleFactors,
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "GetEffectiveScaleByToolkit",
+			Fn:      v.GetEffectiveScaleByToolkit,
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "GetGtkCursorThemeSize",
+			Fn:      v.GetGtkCursorThemeSize,
+			OutArgs: []string{"outArg0"},
+		},
 		{
 			Name:    "GetInteger",
 			Fn:      v.GetInteger,
 			InArgs:  []string{"prop"},
 			OutArgs: []string{"outArg0"},
 		},
+		{
+			Name:    "GetLastPlymouthScaleError",
+			Fn:      v.GetLastPlymouthScaleError,
+			OutArgs: []string{"errMsg"},
+		},
+		{
+			Name:    "GetLastScaleChangeTime",
+			Fn:      v.GetLastScaleChangeTime,
+			OutArgs: []string{"timestamp"},
+		},
+		{
+			Name:    "GetManagedScaleFiles",
+			Fn:      v.GetManagedScaleFiles,
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "GetPrimaryScreenScaleFactor",
+			Fn:      v.GetPrimaryScreenScaleFactor,
+			OutArgs: []string{"factor"},
+		},
+		{
+			Name:    "GetScaleDefaults",
+			Fn:      v.GetScaleDefaults,
+			OutArgs: []string{"defaults"},
+		},
 		{
 			Name:    "GetScaleFactor",
 			Fn:      v.GetScaleFactor,
 			OutArgs: []string{"outArg0"},
 		},
+		{
+			Name:    "GetScaleFactorLabel",
+			Fn:      v.GetScaleFactorLabel,
+			InArgs:  []string{"factor"},
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "GetScaleFactorRange",
+			Fn:      v.GetScaleFactorRange,
+			OutArgs: []string{"min", "max"},
+		},
+		{
+			Name:    "GetScaleRatioString",
+			Fn:      v.GetScaleRatioString,
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "GetScaleSchedule",
+			Fn:      v.GetScaleSchedule,
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "GetScalingMode",
+			Fn:      v.GetScalingMode,
+			OutArgs: []string{"mode"},
+		},
+		{
+			Name:    "GetScreenFactorSources",
+			Fn:      v.GetScreenFactorSources,
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "GetScreenScaleFactorEntries",
+			Fn:      v.GetScreenScaleFactorEntries,
+			OutArgs: []string{"outArg0"},
+		},
 		{
 			Name:    "GetScreenScaleFactors",
 			Fn:      v.GetScreenScaleFactors,
 			OutArgs: []string{"outArg0"},
 		},
+		{
+			Name:    "GetScreenScaleFactorsWithPrimary",
+			Fn:      v.GetScreenScaleFactorsWithPrimary,
+			OutArgs: []string{"factors", "primary"},
+		},
+		{
+			Name:    "GetSpanningScaleFactor",
+			Fn:      v.GetSpanningScaleFactor,
+			InArgs:  []string{"screenA", "screenB"},
+			OutArgs: []string{"outArg0"},
+		},
 		{
 			Name:    "GetString",
 			Fn:      v.GetString,
 			InArgs:  []string{"prop"},
 			OutArgs: []string{"outArg0"},
 		},
+		{
+			Name:    "GetWindowScale",
+			Fn:      v.GetWindowScale,
+			OutArgs: []string{"windowScale"},
+		},
+		{
+			Name:   "ImportScaleConfig",
+			Fn:     v.ImportScaleConfig,
+			InArgs: []string{"path"},
+		},
+		{
+			Name:    "IsPlymouthRebootPending",
+			Fn:      v.IsPlymouthRebootPending,
+			OutArgs: []string{"outArg0"},
+		},
 		{
 			Name:    "ListProps",
 			Fn:      v.ListProps,
 			OutArgs: []string{"outArg0"},
 		},
+		{
+			Name:    "ListScaleSignals",
+			Fn:      v.ListScaleSignals,
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "PreviewScaleFactors",
+			Fn:      v.PreviewScaleFactors,
+			InArgs:  []string{"factors"},
+			OutArgs: []string{"preview"},
+		},
+		{
+			Name: "RepairWindowScale",
+			Fn:   v.RepairWindowScale,
+		},
+		{
+			Name: "ResetCursorSizeToScale",
+			Fn:   v.ResetCursorSizeToScale,
+		},
+		{
+			Name: "ResetQtScaleFromGsettings",
+			Fn:   v.ResetQtScaleFromGsettings,
+		},
+		{
+			Name: "ResetScaleFactor",
+			Fn:   v.ResetScaleFactor,
+		},
+		{
+			Name:    "ScaleFactorForTargetDpi",
+			Fn:      v.ScaleFactorForTargetDpi,
+			InArgs:  []string{"screen", "targetDpi"},
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "ScaleFactorForTextHeightMm",
+			Fn:      v.ScaleFactorForTextHeightMm,
+			InArgs:  []string{"screen", "mm"},
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "ScaleSelfCheck",
+			Fn:      v.ScaleSelfCheck,
+			OutArgs: []string{"ok", "details"},
+		},
 		{
 			Name:   "SetColor",
 			Fn:     v.SetColor,
 			InArgs: []string{"prop", "v"},
 		},
+		{
+			Name:   "SetGreeterScaleFactor",
+			Fn:     v.SetGreeterScaleFactor,
+			InArgs: []string{"factor"},
+		},
+		{
+			Name:   "SetGtkCursorThemeSize",
+			Fn:     v.SetGtkCursorThemeSize,
+			InArgs: []string{"size"},
+		},
 		{
 			Name:   "SetInteger",
 			Fn:     v.SetInteger,
@@ -60,6 +284,36 @@ func (v *XSManager) GetExportedMethods() dbusutil.ExportedMethods {
 			Fn:     v.SetScaleFactor,
 			InArgs: []string{"scale"},
 		},
+		{
+			Name:   "SetScaleFactorForOutput",
+			Fn:     v.SetScaleFactorForOutput,
+			InArgs: []string{"output", "factor"},
+		},
+		{
+			Name:   "SetScaleFactorLocked",
+			Fn:     v.SetScaleFactorLocked,
+			InArgs: []string{"locked"},
+		},
+		{
+			Name:   "SetScaleFaultInjection",
+			Fn:     v.SetScaleFaultInjection,
+			InArgs: []string{"faults"},
+		},
+		{
+			Name:   "SetScaleSchedule",
+			Fn:     v.SetScaleSchedule,
+			InArgs: []string{"entries"},
+		},
+		{
+			Name:   "SetScalingMode",
+			Fn:     v.SetScalingMode,
+			InArgs: []string{"mode"},
+		},
+		{
+			Name:   "SetScreenDpis",
+			Fn:     v.SetScreenDpis,
+			InArgs: []string{"dpis"},
+		},
 		{
 			Name:   "SetScreenScaleFactors",
 			Fn:     v.SetScreenScaleFactors,
@@ -70,5 +324,30 @@ func (v *XSManager) GetExportedMethods() dbusutil.ExportedMethods {
 			Fn:     v.SetString,
 			InArgs: []string{"prop", "v"},
 		},
+		{
+			Name:   "SetTemporaryCursorSize",
+			Fn:     v.SetTemporaryCursorSize,
+			InArgs: []string{"size", "durationSeconds"},
+		},
+		{
+			Name:    "VerifyCleanScaleEnv",
+			Fn:      v.VerifyCleanScaleEnv,
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "VerifyGreeterScale",
+			Fn:      v.VerifyGreeterScale,
+			OutArgs: []string{"outArg0"},
+		},
+		{
+			Name:    "VerifyIndividualScalingIntegrity",
+			Fn:      v.VerifyIndividualScalingIntegrity,
+			OutArgs: []string{"ok", "problems"},
+		},
+		{
+			Name:    "WouldPrimaryBlur",
+			Fn:      v.WouldPrimaryBlur,
+			OutArgs: []string{"blur", "reason"},
+		},
 	}
 }
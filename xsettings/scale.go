@@ -5,20 +5,29 @@
 package xsettings
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
 	"os"
+	"os/user"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	dbus "github.com/godbus/dbus/v5"
 	"github.com/linuxdeepin/dde-api/userenv"
 	gio "github.com/linuxdeepin/go-gir/gio-2.0"
 	"github.com/linuxdeepin/go-lib/keyfile"
+	"github.com/linuxdeepin/go-lib/log"
+	"github.com/linuxdeepin/go-lib/strv"
 	"github.com/linuxdeepin/go-lib/xdg/basedir"
 	x "github.com/linuxdeepin/go-x11-client"
 	"github.com/linuxdeepin/go-x11-client/ext/randr"
@@ -29,138 +38,1121 @@ const (
 	gsKeyScaleFactor        = "scale-factor"
 	gsKeyWindowScale        = "window-scale"
 	gsKeyGtkCursorThemeSize = "gtk-cursor-theme-size"
+	gsKeyGtkCursorThemeName = "gtk-cursor-theme-name"
 	gsKeyIndividualScaling  = "individual-scaling"
 	baseCursorSize          = 24
 
+	startddeSchema         = "com.deepin.dde.startdde"
+	gsKeyCursorBaseSize    = "cursor-base-size"
+	gsKeyQtThemeSandbox    = "qt-theme-sandbox-copy"
+	gsKeySpanningPolicy    = "spanning-scale-policy"
+	gsKeyXresourcesDpi     = "xresources-file-dpi"
+	gsKeyScaleLocked       = "scale-factor-locked"
+	gsKeyCursorMaxScale    = "cursor-size-max-factor"
+	gsKeyPlymouthReboot    = "plymouth-scale-reboot-pending"
+	gsKeyPlymouthSettle    = "plymouth-settle-delay"
+	gsKeyPlymouthTimeout   = "plymouth-scale-timeout"
+	gsKeyStructuredLog     = "scale-structured-log"
+	gsKeyMaxEffectiveDpi   = "max-effective-dpi"
+	gsKeyScalingEdids      = "individual-scaling-edids"
+	gsKeyScaleClampMin     = "scale-factor-min"
+	gsKeyScaleClampMax     = "scale-factor-max"
+	gsKeySnapStep          = "scale-factor-snap-step"
+	gsKeySnapExemptPrimary = "scale-snap-exempt-primary"
+	gsKeyApplyDelay        = "scale-apply-delay"
+	gsKeyWineScaling       = "wine-scaling-enabled"
+	gsKeyEnvKeepKeys       = "scale-env-keep-keys"
+	gsKeyScalingMode       = "scaling-mode"
+
+	// 管理员在启动时设置该环境变量可以忽略缩放锁定
+	envScaleLockOverride = "STARTDDE_SCALE_LOCK_OVERRIDE"
+	// 部分 Plymouth 主题支持 3 倍缩放，可以通过该环境变量提高上限
+	envPlymouthMaxScale = "STARTDDE_PLYMOUTH_MAX_SCALE"
+
 	qtThemeSection               = "Theme"
 	qtThemeKeyScreenScaleFactors = "ScreenScaleFactors"
 	qtThemeKeyScaleFactor        = "ScaleFactor"
 	qtThemeKeyScaleLogicalDpi    = "ScaleLogicalDpi"
+	qtThemeFileRelPath           = "deepin/qt-theme.ini"
+	greeterQtThemeFile           = "/etc/lightdm/deepin/qt-theme.ini"
+)
+
+// 设置界面中缩放比例的步长
+const scaleFactorStep = 0.25
+
+// 允许的缩放比例范围的默认值，超出范围的值会被限制到范围内
+const (
+	defaultMinScaleFactor = 0.5
+	defaultMaxScaleFactor = 3.0
 )
 
+// ScaleDefaults 缩放相关设置项的默认值，需要与 gschema 中的默认值保持一致
+type ScaleDefaults struct {
+	ScaleFactor     float64
+	MinScaleFactor  float64
+	MaxScaleFactor  float64
+	ScaleFactorStep float64
+	ScaleClampMin   float64
+	ScaleClampMax   float64
+	CursorBaseSize  int32
+	// 单位为毫秒
+	PlymouthSettleDelay int32
+	ScaleApplyDelay     int32
+	// 单位为秒
+	PlymouthScaleTimeout int32
+	MaxEffectiveDpi      int32
+	SpanningScalePolicy  string
+	ScaleSnapStep        float64
+	CursorSizeMaxFactor  bool
+	QtThemeSandboxCopy   bool
+	XresourcesFileDpi    bool
+	ScaleFactorLocked    bool
+	StructuredLog        bool
+	WineScaling          bool
+	ScalingMode          string
+}
+
+func getScaleDefaults() ScaleDefaults {
+	return ScaleDefaults{
+		ScaleFactor:          1.0,
+		MinScaleFactor:       defaultMinScaleFactor,
+		MaxScaleFactor:       defaultMaxScaleFactor,
+		ScaleFactorStep:      scaleFactorStep,
+		ScaleClampMin:        defaultMinScaleFactor,
+		ScaleClampMax:        defaultMaxScaleFactor,
+		CursorBaseSize:       baseCursorSize,
+		PlymouthSettleDelay:  1000,
+		ScaleApplyDelay:      300,
+		PlymouthScaleTimeout: int32(defaultPlymouthScaleTimeout / time.Second),
+		SpanningScalePolicy:  spanningScalePolicyMax,
+		ScalingMode:          scalingModeIndividual,
+		ScaleSnapStep:        scaleFactorStep,
+	}
+}
+
+// scaleConfig 缩放设置相关的可配置项
+type scaleConfig struct {
+	// 缩放为 1 时的光标大小
+	cursorBaseSize int32
+	// 是否额外写一份 qt-theme.ini 给沙盒应用使用
+	qtThemeSandboxCopy bool
+	// 跨屏窗口使用的缩放比例，spanningScalePolicy*
+	spanningPolicy string
+	// 是否把 Xft.dpi 合并到 ~/.Xresources
+	xresourcesFileDpi bool
+	// 光标大小按照各屏幕中最大的缩放比例计算
+	cursorMaxFactor bool
+	// 缩放比例稳定多久之后才设置 Plymouth
+	plymouthSettleDelay time.Duration
+	// 以 KEY=value 的形式记录缩放的修改
+	structuredLog bool
+	// 屏幕物理 DPI 乘以缩放比例的上限，0 表示不限制
+	maxEffectiveDpi float64
+	// 允许的缩放比例范围，所有的范围检查都使用这个范围，maxFactor 为 0 时不限制
+	minFactor, maxFactor float64
+	// 保存前缩放比例取整的步长，0 表示不取整
+	snapStep float64
+	// 主屏不取整，保留精确的值
+	snapExemptPrimary bool
+	// 合并多次设置缩放的等待时间，0 表示立即设置
+	applyDelay time.Duration
+	// 把主屏的缩放比例写入 DEEPIN_WINE_SCALE
+	wineScaling bool
+	// 设置缩放时不清理的环境变量
+	envKeepKeys []string
+	// scalingMode*
+	scalingMode string
+}
+
+func (m *XSManager) getScaleConfig() scaleConfig {
+	cfg := scaleConfig{
+		cursorBaseSize:     baseCursorSize,
+		qtThemeSandboxCopy: m.startddeGs.GetBoolean(gsKeyQtThemeSandbox),
+		spanningPolicy:     m.startddeGs.GetString(gsKeySpanningPolicy),
+		xresourcesFileDpi:  m.startddeGs.GetBoolean(gsKeyXresourcesDpi),
+		cursorMaxFactor:    m.startddeGs.GetBoolean(gsKeyCursorMaxScale),
+		structuredLog:      m.startddeGs.GetBoolean(gsKeyStructuredLog),
+		maxEffectiveDpi:    float64(m.startddeGs.GetInt(gsKeyMaxEffectiveDpi)),
+		snapStep:           m.startddeGs.GetDouble(gsKeySnapStep),
+		snapExemptPrimary:  m.startddeGs.GetBoolean(gsKeySnapExemptPrimary),
+		wineScaling:        m.startddeGs.GetBoolean(gsKeyWineScaling),
+		envKeepKeys:        m.startddeGs.GetStrv(gsKeyEnvKeepKeys),
+		scalingMode:        m.startddeGs.GetString(gsKeyScalingMode),
+	}
+	cfg.minFactor, cfg.maxFactor = getScaleClampRange(
+		m.startddeGs.GetDouble(gsKeyScaleClampMin), m.startddeGs.GetDouble(gsKeyScaleClampMax))
+	if v := m.startddeGs.GetInt(gsKeyPlymouthSettle); v > 0 {
+		cfg.plymouthSettleDelay = time.Duration(v) * time.Millisecond
+	}
+	if v := m.startddeGs.GetInt(gsKeyApplyDelay); v > 0 {
+		cfg.applyDelay = time.Duration(v) * time.Millisecond
+	}
+	// 用户单独设置过光标大小
+	if v := m.startddeGs.GetInt(gsKeyCursorBaseSize); v > 0 {
+		cfg.cursorBaseSize = v
+	}
+	return cfg
+}
+
+// 配置的范围无效时使用默认范围
+func getScaleClampRange(min, max float64) (float64, float64) {
+	if min <= 0 || max < min {
+		logger.Warningf("invalid scale factor range [%v, %v], use default", min, max)
+		return defaultMinScaleFactor, defaultMaxScaleFactor
+	}
+	return min, max
+}
+
+// clampScreenFactors 把缩放比例限制在 cfg 的范围内，发生限制时输出警告
+func clampScreenFactors(factors map[string]float64, cfg scaleConfig) map[string]float64 {
+	if cfg.maxFactor <= 0 {
+		return factors
+	}
+	result := make(map[string]float64, len(factors))
+	for name, factor := range factors {
+		clamped := math.Max(cfg.minFactor, math.Min(cfg.maxFactor, factor))
+		if clamped != factor {
+			logger.Warningf("scale factor %v of %s out of range [%v, %v], clamp to %v",
+				factor, name, cfg.minFactor, cfg.maxFactor, clamped)
+		}
+		result[name] = clamped
+	}
+	return result
+}
+
 // 设置单个缩放值的关键方法
-func (m *XSManager) setScaleFactor(scale float64, emitSignal bool) {
+func (m *XSManager) setScaleFactor(scale float64, windowScale, cursorSize int32) {
 	logger.Debug("setScaleFactor", scale)
 	m.gs.SetDouble(gsKeyScaleFactor, scale)
 
-	// if 1.7 < scale < 2, window scale = 2
+	oldWindowScale := m.gs.GetInt(gsKeyWindowScale)
+	if oldWindowScale != windowScale {
+		logger.Debug(explainWindowScale(scale))
+		m.gs.SetInt(gsKeyWindowScale, windowScale)
+	}
+
+	m.setCursorSize(cursorSize)
+}
+
+const wrapGnomeInterfaceSchema = "com.deepin.wrap.gnome.desktop.interface"
+
+// 测试时替换
+var newGioSettings = gio.NewSettings
+
+// 拖动缩放滑块时会频繁设置光标大小，只创建一次
+func (m *XSManager) getWrapGDISettings() *gio.Settings {
+	m.wrapGDIOnce.Do(func() {
+		m.wrapGDI = newGioSettings(wrapGnomeInterfaceSchema)
+	})
+	return m.wrapGDI
+}
+
+// 只写入设置，CursorSizeChanged 信号由调用者在设置成功后发送
+func (m *XSManager) setCursorSize(cursorSize int32) {
+	m.gs.SetInt(gsKeyGtkCursorThemeSize, cursorSize)
+	// set cursor size for deepin-metacity
+	m.getWrapGDISettings().SetInt("cursor-size", cursorSize)
+}
+
+func (m *XSManager) emitCursorSizeChanged(oldSize, newSize int32, emitSignal bool) {
+	if !emitSignal || oldSize == newSize {
+		return
+	}
+	err := m.service.Emit(m, signalCursorSizeChanged, newSize)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+// deriveWindowScale 相当于 scale+0.3 向下取整，最小为 1，
+// 即 [0.5, 1.7) 为 1，[1.7, 2.7) 为 2，[2.7, 3] 为 3。例如 1.7 已经使用 2 倍的窗口缩放。
+func deriveWindowScale(scale float64) int32 {
 	windowScale := int32(math.Trunc((scale+0.3)*10) / 10)
 	if windowScale < 1 {
 		windowScale = 1
 	}
-	oldWindowScale := m.gs.GetInt(gsKeyWindowScale)
-	if oldWindowScale != windowScale {
-		m.gs.SetInt(gsKeyWindowScale, windowScale)
+	return windowScale
+}
+
+// 用于日志，说明 window-scale 是怎样由 scale-factor 得到的
+func explainWindowScale(scale float64) string {
+	return fmt.Sprintf("window scale of scale factor %v: trunc((%v+0.3)*10)/10 = %v, at least 1, got %d",
+		scale, scale, math.Trunc((scale+0.3)*10)/10, deriveWindowScale(scale))
+}
+
+// 其他程序单独修改了 window-scale 或者 scale-factor 时输出警告，不自动修正，需要时调用 RepairWindowScale
+func (m *XSManager) checkWindowScaleMismatch() {
+	scale := m.gs.GetDouble(gsKeyScaleFactor)
+	if scale <= 0 {
+		return
+	}
+	windowScale := m.gs.GetInt(gsKeyWindowScale)
+	want, ok := checkWindowScale(scale, windowScale)
+	if !ok {
+		logger.Warningf("window scale %d does not match scale factor %v, want %d", windowScale, scale, want)
+		logger.Debug(explainWindowScale(scale))
+	}
+}
+
+func (m *XSManager) getWindowScale() int32 {
+	return m.gs.GetInt(gsKeyWindowScale)
+}
+
+// 检查 window-scale 与 scale-factor 是否一致，返回 scale-factor 对应的 window-scale
+func checkWindowScale(scale float64, windowScale int32) (int32, bool) {
+	want := deriveWindowScale(scale)
+	return want, want == windowScale
+}
+
+// 其他程序可能只修改了 scale-factor，根据 scale-factor 修正 window-scale
+func (m *XSManager) repairWindowScale() error {
+	err := m.checkScaleChangeAllowed()
+	if err != nil {
+		return err
+	}
+	scale := m.gs.GetDouble(gsKeyScaleFactor)
+	if scale <= 0 {
+		return fmt.Errorf("invalid scale factor %v", scale)
+	}
+	windowScale := m.gs.GetInt(gsKeyWindowScale)
+	want, ok := checkWindowScale(scale, windowScale)
+	if ok {
+		return nil
+	}
+	logger.Infof("repair window scale %d -> %d, scale factor: %v", windowScale, want, scale)
+	m.gs.SetInt(gsKeyWindowScale, want)
+	return nil
+}
+
+// scaleDerivedValues 根据 scale-factor 推导出的设置
+type scaleDerivedValues struct {
+	windowScale int32
+	cursorSize  int32
+}
+
+// X 中只有一个光标大小，多屏缩放比例不同时，可以按最大的缩放比例计算，保证在最密的屏幕上也足够大
+func getCursorScaleFactor(factors map[string]float64, scale float64, cfg scaleConfig) float64 {
+	if !cfg.cursorMaxFactor {
+		return scale
+	}
+	for _, f := range factors {
+		if f > scale {
+			scale = f
+		}
+	}
+	return scale
+}
+
+func deriveScaleValues(scale float64, factors map[string]float64, cfg scaleConfig) scaleDerivedValues {
+	return scaleDerivedValues{
+		windowScale: deriveWindowScale(scale),
+		cursorSize:  deriveCursorSize(cfg.cursorBaseSize, getCursorScaleFactor(factors, scale, cfg)),
+	}
+}
+
+// ScaleDerivatives 单个缩放比例对应的各项设置，不读取 gsettings 和文件，光标按默认的基准大小计算
+type ScaleDerivatives struct {
+	WindowScale          int32
+	CursorSize           int32
+	QtScreenScaleFactors string
+	PlymouthFactor       int32
+}
+
+func computeScaleDerivatives(factor float64) (ScaleDerivatives, error) {
+	if factor <= 0 {
+		return ScaleDerivatives{}, fmt.Errorf("invalid scale factor %v", factor)
+	}
+	factors := singleToMapSF(factor)
+	derived := deriveScaleValues(factor, factors, scaleConfig{cursorBaseSize: baseCursorSize})
+	qtValue, err := getQtScreenScaleFactorsValue(factors)
+	if err != nil {
+		return ScaleDerivatives{}, err
+	}
+	return ScaleDerivatives{
+		WindowScale:          derived.windowScale,
+		CursorSize:           derived.cursorSize,
+		QtScreenScaleFactors: qtValue,
+		// 与 setScaleFactorForPlymouth 一致，Plymouth 使用限制在上限内的窗口缩放
+		PlymouthFactor: int32(clampPlymouthFactor(int(derived.windowScale), getPlymouthMaxScale())),
+	}, nil
+}
+
+// 切换主题后可能会重置光标大小等设置，需要重新设置的主题相关的 key
+var themeGSKeys = []string{
+	"theme-name",
+	"icon-theme-name",
+	gsKeyGtkCursorThemeName,
+}
+
+func isThemeGSKey(key string) bool {
+	for _, k := range themeGSKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+const themeReassertDelay = time.Second
+
+// debouncer 在 delay 时间内没有再次触发时才调用 fn
+type debouncer struct {
+	mu    sync.Mutex
+	delay time.Duration
+	timer *time.Timer
+	fn    func()
+}
+
+func newDebouncer(delay time.Duration, fn func()) *debouncer {
+	return &debouncer{
+		delay: delay,
+		fn:    fn,
+	}
+}
+
+func (d *debouncer) trigger() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.delay, d.fn)
+	} else {
+		d.timer.Reset(d.delay)
+	}
+}
+
+// 启动时根据保存的 scale-factor 重新设置推导出的值。
+// 会话中 xsettings 服务重启后，gsettings 中的 scale-factor 仍在，但推导出的值可能已经丢失或不一致。
+func (m *XSManager) reassertScale() {
+	m.scaleMu.Lock()
+	defer m.scaleMu.Unlock()
+	scale := getScaleFactor()
+	if scale <= 0 {
+		logger.Warning("invalid scale factor:", scale)
+		return
+	}
+	want := deriveScaleValues(scale, m.getScreenScaleFactors(), m.getScaleConfig())
+	current := scaleDerivedValues{
+		windowScale: m.gs.GetInt(gsKeyWindowScale),
+		cursorSize:  m.gs.GetInt(gsKeyGtkCursorThemeSize),
+	}
+	if current != want {
+		logger.Infof("reassert scale %v, %+v -> %+v", scale, current, want)
+	}
+	if current.windowScale != want.windowScale {
+		m.gs.SetInt(gsKeyWindowScale, want.windowScale)
+	}
+	// 临时设置的光标大小到期后会自己恢复
+	if m.tempCursorSize != nil && m.tempCursorSize.isActive() {
+		logger.Debug("skip reasserting cursor size, temporary cursor size is active")
+		return
+	}
+	m.setCursorSize(want.cursorSize)
+	m.emitCursorSizeChanged(current.cursorSize, want.cursorSize, true)
+}
+
+// 四舍五入而不是截断，避免相近的缩放比例得到的光标大小跳变，例如 1.9 倍时为 46 而不是 45
+func deriveCursorSize(baseSize int32, scale float64) int32 {
+	return int32(math.Round(float64(baseSize) * scale))
+}
+
+// 预览在 scale 缩放下的光标大小，超出范围的缩放比例按边界值计算
+func getCursorPreviewSize(baseSize int32, scale, minFactor, maxFactor float64) (int32, error) {
+	if scale <= 0 {
+		return 0, fmt.Errorf("invalid scale factor %v", scale)
+	}
+	scale = math.Max(minFactor, math.Min(maxFactor, scale))
+	return deriveCursorSize(baseSize, scale), nil
+}
+
+func (m *XSManager) getCursorPreviewInfo(scale float64) (string, int32, error) {
+	cfg := m.getScaleConfig()
+	size, err := getCursorPreviewSize(cfg.cursorBaseSize, scale, cfg.minFactor, cfg.maxFactor)
+	if err != nil {
+		return "", 0, err
+	}
+	return m.gs.GetString(gsKeyGtkCursorThemeName), size, nil
+}
+
+// 根据当前缩放下用户期望的光标大小，反推缩放为 1 时的光标大小
+func deriveCursorBaseSize(cursorSize int32, scale float64) int32 {
+	if scale <= 0 {
+		return cursorSize
+	}
+	baseSize := int32(math.Round(float64(cursorSize) / scale))
+	if baseSize < 1 {
+		baseSize = 1
+	}
+	return baseSize
+}
+
+// individual-scaling 的格式为 name=factor 以 ; 分隔，例如 "ALL=1.25;HDMI-1=2.00"。
+// 屏幕名中的 %、= 和 ; 分别编码为 %25、%3D 和 %3B，其他字符原样保存，
+// 外部工具按 ; 和第一个 = 切分后再对名称做百分号解码即可。ALL 不含需要编码的字符，始终保持原样。
+var screenNameEscaper = strings.NewReplacer("%", "%25", "=", "%3D", ";", "%3B")
+
+var screenNameUnescaper = strings.NewReplacer(
+	"%25", "%", "%3D", "=", "%3d", "=", "%3B", ";", "%3b", ";")
+
+func escapeScreenName(name string) string {
+	return screenNameEscaper.Replace(name)
+}
+
+// 不认识的 % 序列原样保留，兼容编码之前写入的值
+func unescapeScreenName(name string) string {
+	return screenNameUnescaper.Replace(name)
+}
+
+// 允许手动编辑时在 = 和 ; 两边加入空白，忽略空的段
+func parseScreenFactors(str string) map[string]float64 {
+	pairs := strings.Split(str, ";")
+	result := make(map[string]float64)
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			logger.Warningf("invalid screen scale factor %q", pair)
+			continue
+		}
+		name := unescapeScreenName(strings.TrimSpace(kv[0]))
+		if name == "" {
+			logger.Warningf("invalid screen scale factor %q", pair)
+			continue
+		}
+
+		value, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			logger.Warning(err)
+			continue
+		}
+
+		result[name] = value
+	}
+
+	return result
+}
+
+// formatScaleFactor 一般保留两位小数，两位小数不能精确表示时使用能精确还原的最短形式，
+// 保证 parse 之后得到相同的值，反复读写不会漂移。
+func formatScaleFactor(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	if parsed, err := strconv.ParseFloat(s, 64); err == nil && parsed == v {
+		return s
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// 按屏幕名排序，保证相同的输入得到相同的字符串
+func joinScreenScaleFactors(v map[string]float64) string {
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = escapeScreenName(key) + "=" + formatScaleFactor(v[key])
+	}
+	return strings.Join(pairs, ";")
+}
+
+// findLossyScreenFactors 检查 individual-scaling 的值经过 parse 和 join 之后能否无损还原，
+// 返回有问题的条目及原因
+func findLossyScreenFactors(str string) []string {
+	if str == "" {
+		return nil
+	}
+	var problems []string
+	parsed := make(map[string]float64)
+	for _, pair := range strings.Split(str, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			problems = append(problems, fmt.Sprintf("%q: missing '='", pair))
+			continue
+		}
+		name := unescapeScreenName(strings.TrimSpace(kv[0]))
+		if name == "" {
+			problems = append(problems, fmt.Sprintf("%q: empty screen name", pair))
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%q: invalid value", pair))
+			continue
+		}
+		if _, ok := parsed[name]; ok {
+			problems = append(problems, fmt.Sprintf("%q: duplicate screen name", pair))
+			continue
+		}
+		parsed[name] = value
+	}
+
+	reparsed := parseScreenFactors(joinScreenScaleFactors(parsed))
+	names := make([]string, 0, len(parsed))
+	for name := range parsed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		got, ok := reparsed[name]
+		if !ok || got != parsed[name] {
+			problems = append(problems, fmt.Sprintf("%s=%v: value changed after round trip", name, parsed[name]))
+		}
+	}
+	return problems
+}
+
+func (m *XSManager) verifyIndividualScalingIntegrity() (bool, []string) {
+	problems := findLossyScreenFactors(m.gs.GetString(gsKeyIndividualScaling))
+	if len(problems) > 0 {
+		logger.Warningf("%s does not round trip: %v", gsKeyIndividualScaling, problems)
+	}
+	return len(problems) == 0, problems
+}
+
+func getQtThemeFile() string {
+	return filepath.Join(basedir.GetUserConfigDir(), qtThemeFileRelPath)
+}
+
+// Flatpak 等沙盒中的应用默认无法读取 ~/.config/deepin/qt-theme.ini，
+// 开启 qt-theme-sandbox-copy 后额外写入 $XDG_DATA_HOME/deepin/qt-theme.ini，
+// 可以通过 flatpak override --filesystem=xdg-data/deepin:ro 暴露给沙盒应用。
+func getSandboxQtThemeFile() string {
+	return filepath.Join(basedir.GetUserDataDir(), qtThemeFileRelPath)
+}
+
+// getManagedScaleFiles 返回 startdde 为缩放写入的用户文件，新增写入位置时需同步修改
+func getManagedScaleFiles(cfg scaleConfig) []string {
+	files := []string{
+		getQtThemeFile(),
+		getQtThemeFile() + ".bak",
+		getScaleScheduleFile(),
+		getScaleMarkerFile(),
+		getScaleChangeTimeFile(),
+		getDdeEnvFile(),
+	}
+	if cfg.qtThemeSandboxCopy {
+		files = append(files, getSandboxQtThemeFile())
+	}
+	if cfg.xresourcesFileDpi {
+		files = append(files, getXResourcesFile())
+	}
+	return files
+}
+
+// 其他用户的配置目录无法获取 XDG_CONFIG_HOME，使用默认的 ~/.config
+func getUserQtThemeFile(homeDir string) string {
+	return filepath.Join(homeDir, ".config", qtThemeFileRelPath)
+}
+
+// 读取用户保存的 qt-theme.ini，要求其中有缩放的设置
+func loadUserQtTheme(homeDir string) (*keyfile.KeyFile, error) {
+	filename := getUserQtThemeFile(homeDir)
+	kf := keyfile.NewKeyFile()
+	err := kf.LoadFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	_, err = kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+	if err != nil {
+		return nil, fmt.Errorf("no scale factors in %s: %v", filename, err)
+	}
+	return kf, nil
+}
+
+// 把指定用户保存的缩放设置推送给 greeter，使登录界面与该用户的设置一致
+func (m *XSManager) applyUserScaleFromGreeter(username string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	kf, err := loadUserQtTheme(u.HomeDir)
+	if err != nil {
+		return err
+	}
+	return m.updateGreeterQtTheme(kf)
+}
+
+// 缩放改为通过 xsettings 和 qt-theme.ini 传递，这些环境变量需要清理掉
+var ddeScaleEnvKeys = []string{
+	"QT_SCALE_FACTOR",
+	"QT_SCREEN_SCALE_FACTORS",
+	"QT_AUTO_SCREEN_SCALE_FACTOR",
+	"QT_FONT_DPI",
+	EnvDeepinWineScale,
+}
+
+// 从 ddeScaleEnvKeys 中去掉 keep 中的变量，兼容模式下用户可能需要保留 QT_FONT_DPI 等变量
+func getDdeScaleEnvKeys(keep []string) []string {
+	keys := make([]string, 0, len(ddeScaleEnvKeys))
+	for _, key := range ddeScaleEnvKeys {
+		if !strv.Strv(keep).Contains(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func cleanUpDdeEnv() error {
+	return updateDdeScaleEnv(ddeScaleEnvKeys, "")
+}
+
+// updateDdeScaleEnv 清理 keys 中的环境变量。
+// 开启 wine-scaling-enabled 时 wineScale 不为空，DEEPIN_WINE_SCALE 改为设置成该值而不是删除，
+// 所以设置缩放时只调用这一处，不会出现先设置再被 cleanUpDdeEnv 删除的情况。
+// 测试时替换
+var getDdeEnvFile = userenv.DefaultFile
+
+func updateDdeScaleEnv(keys []string, wineScale string) error {
+	return updateDdeScaleEnvFile(getDdeEnvFile(), keys, wineScale)
+}
+
+func updateDdeScaleEnvFile(filename string, keys []string, wineScale string) error {
+	ue, err := userenv.LoadFromFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if wineScale == "" {
+			return nil
+		}
+		ue = make(map[string]string)
+	}
+
+	if applyDdeScaleEnv(ue, keys, wineScale) {
+		err = userenv.SaveToFile(filename, ue)
+	}
+	return err
+}
+
+// 修改 ue，返回是否有改动
+func applyDdeScaleEnv(ue map[string]string, keys []string, wineScale string) bool {
+	changed := false
+	for _, key := range keys {
+		if key == EnvDeepinWineScale && wineScale != "" {
+			continue
+		}
+		if _, ok := ue[key]; ok {
+			delete(ue, key)
+			changed = true
+		}
+	}
+	if wineScale != "" && ue[EnvDeepinWineScale] != wineScale {
+		ue[EnvDeepinWineScale] = wineScale
+		changed = true
+	}
+	return changed
+}
+
+// Wine 程序只支持单值缩放，使用主屏的缩放比例
+func getWineScaleValue(factors map[string]float64, primary string) string {
+	factor := getSingleScaleFactor(factors)
+	if primary != "" {
+		factor = resolveScreenFactor(factors, primary)
+	}
+	return formatScaleFactor(factor)
+}
+
+// 返回环境中残留的应该被 cleanUpDdeEnv 清理掉的变量
+func findLeftoverScaleEnv(lookupEnv func(key string) (string, bool)) []string {
+	var result []string
+	for _, key := range ddeScaleEnvKeys {
+		if _, ok := lookupEnv(key); ok {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+func (m *XSManager) setScreenScaleFactorsForQt(factors map[string]float64) error {
+	err := checkScreenFactorsNotEmpty(factors)
+	if err != nil {
+		return err
+	}
+	qt, err := prepareQtTheme(factors, m.getScaleConfig())
+	if err != nil {
+		return err
+	}
+	// 与 gsettings 不同，文件的写入合并处理，需要立即写入时调用 qtThemeWriter.flush
+	m.qtThemeWriter.schedule(qt)
+	return nil
+}
+
+// qtThemeChange 待写入的 qt-theme.ini
+type qtThemeChange struct {
+	kf       *keyfile.KeyFile
+	filename string
+	// 内容相同的副本
+	copyFilenames []string
+	value         string
+	factors       map[string]float64
+}
+
+func prepareQtTheme(factors map[string]float64, cfg scaleConfig) (*qtThemeChange, error) {
+	filename := getQtThemeFile()
+	kf := keyfile.NewKeyFile()
+	err := kf.LoadFromFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warning("failed to load qt-theme.ini:", err)
+	}
+
+	value, err := getQtScreenScaleFactorsValue(factors)
+	if err != nil {
+		return nil, err
+	}
+	// 在原有内容上修改，只改动缩放相关的 key，保留用户手动添加的其他 key
+	kf.SetValue(qtThemeSection, qtThemeKeyScreenScaleFactors, value)
+	kf.DeleteKey(qtThemeSection, qtThemeKeyScaleFactor)
+	kf.SetValue(qtThemeSection, qtThemeKeyScaleLogicalDpi, "-1,-1")
+
+	var buf bytes.Buffer
+	err = kf.SaveToWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyQtThemeData(buf.Bytes(), value)
+	if err != nil {
+		return nil, err
+	}
+
+	qt := &qtThemeChange{
+		kf:       kf,
+		filename: filename,
+		value:    value,
+		factors:  factors,
+	}
+	if cfg.qtThemeSandboxCopy {
+		qt.copyFilenames = []string{getSandboxQtThemeFile()}
+	}
+	return qt, nil
+}
+
+func getQtScreenScaleFactorsValue(factors map[string]float64) (string, error) {
+	switch len(factors) {
+	case 0:
+		return "", ErrScaleFactorsEmpty
+	case 1:
+		return formatScaleFactor(getMapFirstValueSF(factors)), nil
+	default:
+		return strconv.Quote(joinScreenScaleFactors(factors)), nil
+	}
+}
+
+// 重新解析 qt-theme.ini 的内容，确认 ScreenScaleFactors 的值与预期一致
+func verifyQtThemeData(data []byte, wantValue string) error {
+	kf := keyfile.NewKeyFile()
+	err := kf.LoadFromData(data)
+	if err != nil {
+		return err
+	}
+	value, err := kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+	if err != nil {
+		return err
+	}
+	if value != wantValue {
+		return fmt.Errorf("qt theme has %s %q, want %q",
+			qtThemeKeyScreenScaleFactors, value, wantValue)
+	}
+	return nil
+}
+
+func (qt *qtThemeChange) save() error {
+	if logger.GetLogLevel() == log.LevelDebug {
+		qt.logDiff()
+	}
+
+	err := backupFile(qt.filename)
+	if err != nil {
+		logger.Warning("failed to backup qt-theme.ini:", err)
+	}
+	err = qt.saveTo(qt.filename)
+	if err != nil {
+		return err
+	}
+	for _, filename := range qt.copyFilenames {
+		err = qt.saveTo(filename)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 把 filename 原来的内容保存到 filename.bak，文件不存在时不处理
+func backupFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return ioutil.WriteFile(filename+".bak", data, 0644)
+}
+
+// 输出磁盘上的文件与将要写入的内容在 Theme 段的差异
+func (qt *qtThemeChange) logDiff() {
+	oldKf := keyfile.NewKeyFile()
+	err := oldKf.LoadFromFile(qt.filename)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Debug("failed to load old qt-theme.ini:", err)
+	}
+	diff := diffQtThemeSection(getQtThemeSection(oldKf), getQtThemeSection(qt.kf))
+	if len(diff) == 0 {
+		logger.Debugf("%s Theme section unchanged", qt.filename)
+		return
+	}
+	logger.Debugf("%s Theme section changes:\n%s", qt.filename, strings.Join(diff, "\n"))
+}
+
+func getQtThemeSection(kf *keyfile.KeyFile) map[string]string {
+	section, err := kf.GetSection(qtThemeSection)
+	if err != nil {
+		return nil
+	}
+	result := make(map[string]string, len(section))
+	for k, v := range section {
+		result[k] = v
+	}
+	return result
+}
+
+// 按 key 排序输出差异，-key=value 为删除，+key=value 为新增，key: old -> new 为修改
+func diffQtThemeSection(oldSection, newSection map[string]string) []string {
+	keys := make([]string, 0, len(oldSection)+len(newSection))
+	for k := range oldSection {
+		keys = append(keys, k)
+	}
+	for k := range newSection {
+		if _, ok := oldSection[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var diff []string
+	for _, k := range keys {
+		oldValue, inOld := oldSection[k]
+		newValue, inNew := newSection[k]
+		switch {
+		case !inNew:
+			diff = append(diff, fmt.Sprintf("-%s=%s", k, oldValue))
+		case !inOld:
+			diff = append(diff, fmt.Sprintf("+%s=%s", k, newValue))
+		case oldValue != newValue:
+			diff = append(diff, fmt.Sprintf("%s: %s -> %s", k, oldValue, newValue))
+		}
+	}
+	return diff
+}
+
+// 先写到同目录下的临时文件，校验后再 rename 到位，保证写入是原子的
+func (qt *qtThemeChange) saveTo(filename string) error {
+	if err := _scaleFaults.check(scaleFaultQtWrite); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err := qt.kf.SaveToWriter(&buf)
+	if err != nil {
+		return err
+	}
+	return saveFileAtomic(filename, buf.Bytes(), func(data []byte) error {
+		return verifyQtThemeData(data, qt.value)
+	})
+}
+
+// saveFileAtomic 先写到同目录下的临时文件并 sync，重新读取校验后再 rename 到位。
+// verify 为 nil 时不校验。
+func saveFileAtomic(filename string, content []byte, verify func(data []byte) error) error {
+	return saveFileAtomicMode(filename, content, 0644, verify)
+}
+
+// saveFileAtomicMode 与 saveFileAtomic 相同，写入的文件使用权限 mode
+func saveFileAtomicMode(filename string, content []byte, mode os.FileMode, verify func(data []byte) error) error {
+	dir := filepath.Dir(filename)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+"-")
+	if err != nil {
+		return err
+	}
+	tempFilename := tempFile.Name()
+
+	// TempFile 创建的文件权限为 0600，其他程序也需要读取
+	err = tempFile.Chmod(mode)
+	if err == nil {
+		_, err = tempFile.Write(content)
+	}
+	if err == nil {
+		// 断电时也不会替换成不完整的文件
+		err = tempFile.Sync()
+	}
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && verify != nil {
+		var data []byte
+		data, err = ioutil.ReadFile(tempFilename)
+		if err == nil {
+			err = verify(data)
+		}
+	}
+	if err == nil {
+		err = os.Rename(tempFilename, filename)
+	}
+	if err != nil {
+		removeErr := os.Remove(tempFilename)
+		if removeErr != nil && !os.IsNotExist(removeErr) {
+			logger.Warning(removeErr)
+		}
+	}
+	return err
+}
+
+// 重新读取写入的文件，确认其中的缩放比例与要设置的一致
+func (qt *qtThemeChange) verifyFile() error {
+	got, err := loadQtScreenScaleFactors(qt.filename)
+	if err != nil {
+		return err
+	}
+	if !qtScaleFactorsMatch(got, qt.factors) {
+		return fmt.Errorf("%s has scale factors %v, want %v", qt.filename, got, qt.factors)
 	}
-
-	cursorSize := int32(baseCursorSize * scale)
-	m.gs.SetInt(gsKeyGtkCursorThemeSize, cursorSize)
-	// set cursor size for deepin-metacity
-	gsWrapGDI := gio.NewSettings("com.deepin.wrap.gnome.desktop.interface")
-	gsWrapGDI.SetInt("cursor-size", cursorSize)
-	gsWrapGDI.Unref()
-
-	m.setScaleFactorForPlymouth(int(windowScale), emitSignal)
+	return nil
 }
 
-func parseScreenFactors(str string) map[string]float64 {
-	pairs := strings.Split(str, ";")
-	result := make(map[string]float64)
-	for _, value := range pairs {
-		kv := strings.SplitN(value, "=", 2)
-		if len(kv) != 2 {
-			continue
+// 只有一个屏幕时 qt-theme.ini 中只保存单值，读回来后名称为 ALL，只比较值
+func qtScaleFactorsMatch(got, want map[string]float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	if len(want) == 1 {
+		return scaleFactorEqual(getMapFirstValueSF(got), getMapFirstValueSF(want))
+	}
+	for name, v := range want {
+		gotV, ok := got[name]
+		if !ok || !scaleFactorEqual(gotV, v) {
+			return false
 		}
+	}
+	return true
+}
 
-		value, err := strconv.ParseFloat(kv[1], 64)
+// 写入后校验，不一致时重试一次
+func saveQtThemeVerified(save, verify func() error) error {
+	var err error
+	for i := 0; i < 2; i++ {
+		err = save()
 		if err != nil {
-			logger.Warning(err)
-			continue
+			return err
 		}
-
-		result[kv[0]] = value
+		err = verify()
+		if err == nil {
+			return nil
+		}
+		logger.Warning("failed to verify qt-theme.ini:", err)
 	}
+	return err
+}
 
-	return result
+// 写入并校验，校验失败时重试
+func saveQtTheme(qt *qtThemeChange) error {
+	return saveQtThemeVerified(qt.save, qt.verifyFile)
 }
 
-func joinScreenScaleFactors(v map[string]float64) string {
-	pairs := make([]string, len(v))
-	idx := 0
-	for key, value := range v {
-		pairs[idx] = fmt.Sprintf("%s=%.2f", key, value)
-		idx++
+// qtThemeWriter 合并短时间内多次写入 qt-theme.ini 和向 greeter 同步的操作，只处理最后一次的值，
+// 避免拖动缩放滑块时频繁写磁盘和调用系统服务。
+type qtThemeWriter struct {
+	mu      sync.Mutex
+	delay   time.Duration
+	timer   *time.Timer
+	pending *qtThemeChange
+	// pending 还没有写入文件，为 false 时只需要同步 greeter
+	pendingSave bool
+
+	// 保证按顺序写入，最后的值最后写入
+	flushMu sync.Mutex
+	save    func(qt *qtThemeChange) error
+	push    func(qt *qtThemeChange) error
+}
+
+const qtThemeWriteDelay = 500 * time.Millisecond
+
+func newQtThemeWriter(delay time.Duration, save, push func(qt *qtThemeChange) error) *qtThemeWriter {
+	return &qtThemeWriter{
+		delay: delay,
+		save:  save,
+		push:  push,
 	}
-	return strings.Join(pairs, ";")
 }
 
-func getQtThemeFile() string {
-	return filepath.Join(basedir.GetUserConfigDir(), "deepin/qt-theme.ini")
+// schedule 等待 delay 之后写入文件并同步 greeter，期间再次调用会替换等待中的值并重新计时
+func (w *qtThemeWriter) schedule(qt *qtThemeChange) {
+	w.setPending(qt, true)
 }
 
-func cleanUpDdeEnv() error {
-	ue, err := userenv.Load()
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return err
-	}
+// scheduleGreeter 文件已经写入，只需要同步 greeter
+func (w *qtThemeWriter) scheduleGreeter(qt *qtThemeChange) {
+	w.setPending(qt, false)
+}
 
-	needSave := false
-	for _, key := range []string{
-		"QT_SCALE_FACTOR",
-		"QT_SCREEN_SCALE_FACTORS",
-		"QT_AUTO_SCREEN_SCALE_FACTOR",
-		"QT_FONT_DPI",
-		EnvDeepinWineScale,
-	} {
-		if _, ok := ue[key]; ok {
-			delete(ue, key)
-			needSave = true
-		}
-	}
+func (w *qtThemeWriter) setPending(qt *qtThemeChange, save bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	if needSave {
-		err = userenv.Save(ue)
+	w.pending = qt
+	w.pendingSave = save
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.delay, func() {
+			err := w.flush()
+			if err != nil {
+				logger.Warning("failed to write qt-theme.ini:", err)
+			}
+		})
+	} else {
+		w.timer.Reset(w.delay)
 	}
-	return err
 }
 
-func (m *XSManager) setScreenScaleFactorsForQt(factors map[string]float64) error {
-	filename := getQtThemeFile()
-	kf := keyfile.NewKeyFile()
-	err := kf.LoadFromFile(filename)
-	if err != nil && !os.IsNotExist(err) {
-		logger.Warning("failed to load qt-theme.ini:", err)
-	}
+// flush 立即处理等待中的值，返回写入文件时的错误，写入失败时不同步 greeter
+func (w *qtThemeWriter) flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
 
-	var value string
-	switch len(factors) {
-	case 0:
-		return errors.New("factors is empty")
-	case 1:
-		value = strconv.FormatFloat(getMapFirstValueSF(factors), 'f', 2, 64)
-	default:
-		value = joinScreenScaleFactors(factors)
-		value = strconv.Quote(value)
+	w.mu.Lock()
+	qt := w.pending
+	save := w.pendingSave
+	w.pending = nil
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
 	}
-	kf.SetValue(qtThemeSection, qtThemeKeyScreenScaleFactors, value)
-	kf.DeleteKey(qtThemeSection, qtThemeKeyScaleFactor)
-	kf.SetValue(qtThemeSection, qtThemeKeyScaleLogicalDpi, "-1,-1")
+	w.mu.Unlock()
 
-	err = os.MkdirAll(filepath.Dir(filename), 0755)
-	if err != nil {
-		return err
+	if qt == nil {
+		return nil
 	}
-
-	err = kf.SaveToFile(filename)
+	if save {
+		err := w.save(qt)
+		if err != nil {
+			return err
+		}
+	}
+	err := w.push(qt)
 	if err != nil {
-		return err
+		logger.Warning("failed to update greeter qt theme:", err)
 	}
-
-	err = m.updateGreeterQtTheme(kf)
-	return err
+	return nil
 }
 
 func getMapFirstValueSF(m map[string]float64) float64 {
@@ -170,18 +1162,62 @@ func getMapFirstValueSF(m map[string]float64) float64 {
 	return 0
 }
 
+// 没有 randr 扩展，Display1 服务也不存在时使用的主屏名称，只有一个屏幕时缩放仍然可以正常设置
+const defaultPrimaryScreenName = "default"
+
 func getPrimaryScreenName(xConn *x.Conn) (string, error) {
+	hasRandr := true
+	if err := _scaleFaults.check(scaleFaultRandr); err == nil {
+		hasRandr = hasRandrExtension(xConn)
+	}
+	return resolvePrimaryScreenName(hasRandr, func() (string, error) {
+		return getPrimaryScreenFromRandr(xConn)
+	}, getPrimaryScreenFromBus)
+}
+
+// 依次尝试 randr 和 DBus，randr 扩展不存在且 DBus 也失败时返回 defaultPrimaryScreenName
+func resolvePrimaryScreenName(hasRandr bool, fromRandr, fromBus func() (string, error)) (string, error) {
+	if hasRandr {
+		name, err := fromRandr()
+		if err == nil {
+			return name, nil
+		}
+		logger.Debug("Failed to get primary screen from randr:", err)
+	} else {
+		logger.Debug("randr extension not present")
+	}
+
+	name, err := fromBus()
+	if err == nil {
+		return name, nil
+	}
+	logger.Debug("Failed to get primary screen from Display1:", err)
+	if !hasRandr {
+		logger.Debug("use primary screen name", defaultPrimaryScreenName)
+		return defaultPrimaryScreenName, nil
+	}
+	return "", err
+}
+
+// 扩展的查询结果由 X 连接缓存，只查询一次
+func hasRandrExtension(xConn *x.Conn) bool {
+	data := xConn.GetExtensionData(randr.Ext())
+	return data != nil && data.Present
+}
+
+func getPrimaryScreenFromRandr(xConn *x.Conn) (string, error) {
+	if err := _scaleFaults.check(scaleFaultRandr); err != nil {
+		return "", err
+	}
 	rootWin := xConn.GetDefaultScreen().Root
 	getPrimaryReply, err := randr.GetOutputPrimary(xConn, rootWin).Reply(xConn)
 	if err != nil {
-		logger.Debug("Failed to get output primary:", err)
-		return getPrimaryScreenFromBus()
+		return "", err
 	}
 	outputInfo, err := randr.GetOutputInfo(xConn, getPrimaryReply.Output,
 		x.CurrentTime).Reply(xConn)
 	if err != nil {
-		logger.Debug("Failed to get output info:", err)
-		return getPrimaryScreenFromBus()
+		return "", err
 	}
 	return outputInfo.Name, nil
 }
@@ -191,6 +1227,9 @@ var (
 )
 
 func getPrimaryScreenFromBus() (string, error) {
+	if err := _scaleFaults.check(scaleFaultDBus); err != nil {
+		return "", err
+	}
 	if _sessionConn == nil {
 		conn, err := dbus.SessionBus()
 		if err != nil {
@@ -232,59 +1271,560 @@ func getSingleScaleFactor(factors map[string]float64) float64 {
 	return 1
 }
 
+// 百分比转换为缩放比例，例如 150 转换为 1.5
+func percentToScaleFactor(percent int32, minFactor, maxFactor float64) (float64, error) {
+	scale := float64(percent) / 100
+	if scale < minFactor || scale > maxFactor {
+		return 0, fmt.Errorf("scale percent %d out of range [%v, %v]",
+			percent, math.Round(minFactor*100), math.Round(maxFactor*100))
+	}
+	return scale, nil
+}
+
+func (m *XSManager) applyScalePercent(percent int32) error {
+	cfg := m.getScaleConfig()
+	scale, err := percentToScaleFactor(percent, cfg.minFactor, cfg.maxFactor)
+	if err != nil {
+		return err
+	}
+	return m.setScreenScaleFactors(singleToMapSF(scale), true)
+}
+
+// 缩放比例以比值显示时允许的最大分母
+const maxScaleRatioDenominator = 8
+
+// 把缩放比例转换为比值，例如 1.25 转换为 "5:4"，无法用简单比值表示的返回小数
+func getScaleRatioString(scale float64) string {
+	for d := 1; d <= maxScaleRatioDenominator; d++ {
+		n := math.Round(scale * float64(d))
+		if n > 0 && math.Abs(scale*float64(d)-n) < 1e-6 {
+			return fmt.Sprintf("%d:%d", int(n), d)
+		}
+	}
+	return strconv.FormatFloat(scale, 'f', -1, 64)
+}
+
 func singleToMapSF(value float64) map[string]float64 {
 	return map[string]float64{
 		"ALL": value,
 	}
 }
 
+// scaleChange 一次缩放设置需要写入的全部数据。
+// 设置分为两个阶段：prepare 阶段计算出所有的值并校验，任何一步失败都不会改动现有状态；
+// commit 阶段再写入 qt-theme.ini 和 gsettings，排队同步 greeter，最后排队设置 Plymouth。
+type scaleChange struct {
+	factors       map[string]float64
+	factorsJoined string
+	singleFactor  float64
+	windowScale   int32
+	cursorSize    int32
+	qt            *qtThemeChange
+	// 各屏幕当前的 EDID，为空时不更新记录
+	edids map[string]string
+	// 是否成功同步给了 display 模块
+	dsfHelperApplied bool
+
+	plymouthSettleDelay time.Duration
+	// 是否同时把 Xft.dpi 合并到 ~/.Xresources
+	xresourcesFileDpi bool
+	// 为空时清理 DEEPIN_WINE_SCALE
+	wineScale string
+	// 需要清理的环境变量
+	envKeys []string
+}
+
+// ErrScaleFactorsEmpty 没有任何屏幕的缩放，包括屏幕名全为空白的情况
+var ErrScaleFactorsEmpty = errors.New("factors is empty")
+
+// 在其他校验之前调用，空的 map 和 nil 返回相同的错误
+func checkScreenFactorsNotEmpty(factors map[string]float64) error {
+	for name := range factors {
+		if strings.TrimSpace(name) != "" {
+			return nil
+		}
+	}
+	return ErrScaleFactorsEmpty
+}
+
+// primary 为主屏的名称，单值使用主屏实际的缩放比例，未知时为空
+func prepareScaleChange(factors map[string]float64, primary string, cfg scaleConfig) (*scaleChange, error) {
+	err := checkScreenFactorsNotEmpty(factors)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range factors {
+		if f <= 0 {
+			return nil, errors.New("invalid value")
+		}
+	}
+	// 先限制范围，再推导窗口缩放和光标大小
+	factors = clampScreenFactors(factors, cfg)
+
+	// 同时要设置单值的，scale-factor、窗口缩放和光标大小都跟随主屏
+	singleFactor := resolveScreenFactor(factors, primary)
+	derived := deriveScaleValues(singleFactor, factors, cfg)
+	c := &scaleChange{
+		factors:       factors,
+		factorsJoined: joinScreenScaleFactors(factors),
+		singleFactor:  singleFactor,
+		windowScale:   derived.windowScale,
+		cursorSize:    derived.cursorSize,
+
+		plymouthSettleDelay: cfg.plymouthSettleDelay,
+		xresourcesFileDpi:   cfg.xresourcesFileDpi,
+		envKeys:             getDdeScaleEnvKeys(cfg.envKeepKeys),
+	}
+
+	qt, err := prepareQtTheme(factors, cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.qt = qt
+	return c, nil
+}
+
+// applyScaleBarrier 尽量缩短 Qt 和 GTK 缩放不一致的时间。
+// 调用前 gsettings 需处于 delay 模式，qt-theme.ini rename 到位后立即 apply；
+// qt-theme.ini 写入失败时 revert，两边都保持原样。
+// 不同进程读取配置的时机无法控制，跨进程做不到真正的原子性，这里只是尽量缩短间隔。
+func applyScaleBarrier(saveQt func() error, applyGtk, revertGtk func()) error {
+	err := saveQt()
+	if err != nil {
+		revertGtk()
+		return err
+	}
+	applyGtk()
+	return nil
+}
+
+// 同步给 display 模块，失败时不影响后续的设置，通过 DsfHelperApplied 属性报告不一致
+func (m *XSManager) applyDsfHelper(factors map[string]float64) bool {
+	err := m.dsfHelper.SetScaleFactors(factors)
+	if err != nil {
+		logger.Warning("failed to set scale factors of display, out of sync:", err)
+	}
+	applied := err == nil
+	m.PropsMu.Lock()
+	m.setPropDsfHelperApplied(applied)
+	m.PropsMu.Unlock()
+	return applied
+}
+
+func (m *XSManager) commitScaleChange(c *scaleChange, emitSignal bool) error {
+	c.dsfHelperApplied = m.applyDsfHelper(c.factors)
+	m.emitScaleProgress(scaleProgressDsfHelper, emitSignal)
+
+	// deepin-metacity 读取的光标大小也在屏障内一起生效
+	wrapGDI := m.getWrapGDISettings()
+	m.gs.Delay()
+	wrapGDI.Delay()
+	m.setScaleFactor(c.singleFactor, c.windowScale, c.cursorSize)
+	// 关键保存位置
+	m.gs.SetString(gsKeyIndividualScaling, c.factorsJoined)
+	var err error
+	saveQt := func() error {
+		return saveQtTheme(c.qt)
+	}
+	applyGtk := func() {
+		m.gs.Apply()
+		wrapGDI.Apply()
+	}
+	revertGtk := func() {
+		m.gs.Revert()
+		wrapGDI.Revert()
+	}
+	err = applyScaleBarrier(saveQt, applyGtk, revertGtk)
+	if err != nil {
+		return err
+	}
+	m.emitScaleProgress(scaleProgressSettings, emitSignal)
+
+	err = updateDdeScaleEnv(c.envKeys, c.wineScale)
+	if err != nil {
+		logger.Warning("failed to clean up dde env", err)
+		return err
+	}
+	m.emitScaleProgress(scaleProgressEnv, emitSignal)
+
+	if c.xresourcesFileDpi {
+		updateXResourcesFileDpi(c.singleFactor)
+	}
+	if len(c.edids) > 0 {
+		m.saveScreenEdids(c.edids)
+	}
+
+	// 之后的步骤都在后台进行，失败时不需要回滚
+	// greeter 的同步合并处理，文件已经写入
+	m.qtThemeWriter.scheduleGreeter(c.qt)
+	m.emitScaleProgress(scaleProgressGreeter, emitSignal)
+
+	m.plymouthSettler.schedule(c.plymouthSettleDelay, int(c.windowScale), emitSignal)
+	return nil
+}
+
+// ErrScaleLocked 缩放比例被锁定时拒绝修改
+var ErrScaleLocked = errors.New("scale factor is locked")
+
+func checkScaleLocked(locked, override bool) error {
+	if locked && !override {
+		return ErrScaleLocked
+	}
+	return nil
+}
+
+// 所有修改缩放或光标大小的入口都要先检查
+func (m *XSManager) checkScaleChangeAllowed() error {
+	return checkScaleLocked(m.startddeGs.GetBoolean(gsKeyScaleLocked), m.scaleLockOverride)
+}
+
+// 任何调用者都可以锁定；解除锁定本身也是一种修改，只有管理员设置了环境变量时才允许，
+// 否则普通用户可以先解锁再修改，锁定就没有意义了。
+func (m *XSManager) setScaleFactorLocked(locked bool) error {
+	if !locked {
+		err := m.checkScaleChangeAllowed()
+		if err != nil {
+			return err
+		}
+	}
+	logger.Info("set scale factor locked:", locked)
+	m.startddeGs.SetBoolean(gsKeyScaleLocked, locked)
+	return nil
+}
+
 // 设置多屏的缩放比例的关键方法，factors 中必须含有主屏的数据。
+// 先应用通过 DBus 排队等待中的值，之后再设置 factors，保证 factors 最后写入。
 func (m *XSManager) setScreenScaleFactors(factors map[string]float64, emitSignal bool) error {
+	m.flushScaleApplier()
+	return m.setScreenScaleFactorsNoFlush(factors, emitSignal)
+}
+
+func (m *XSManager) setScreenScaleFactorsNoFlush(factors map[string]float64, emitSignal bool) error {
+	m.scaleMu.Lock()
+	defer m.scaleMu.Unlock()
+	return m.setScreenScaleFactorsLocked(factors, emitSignal)
+}
+
+// 调用者需要持有 scaleMu，用于读取当前的缩放设置、修改后再提交，期间不能有其他的设置
+func (m *XSManager) setScreenScaleFactorsLocked(factors map[string]float64, emitSignal bool) error {
 	logger.Debug("setScreenScaleFactors", factors)
-	for _, f := range factors {
-		if f <= 0 {
-			return errors.New("invalid value")
+	oldFactors := m.getScreenScaleFactors()
+	oldCursorSize := m.getGtkCursorThemeSize()
+	start := time.Now()
+	c, err := m.applyScreenScaleFactors(factors, emitSignal)
+	if m.getScaleConfig().structuredLog {
+		m.logScaleChange(factors, c, time.Since(start), err)
+	}
+	if err != nil {
+		// 可能已经发送了 SetScaleFactorStarted 和进度，需要结束这一次设置
+		m.emitScaleFailed(err, emitSignal)
+		return err
+	}
+	m.emitScaleFactorChanged(oldFactors, c.factors, emitSignal)
+	m.emitCursorSizeChanged(oldCursorSize, c.cursorSize, emitSignal)
+	_, err = updateScaleChangeTime(getScaleChangeTimeFile(), oldFactors, c.factors, time.Now())
+	if err != nil {
+		logger.Warning("failed to save scale change time:", err)
+	}
+	return nil
+}
+
+func (m *XSManager) applyScreenScaleFactors(factors map[string]float64, emitSignal bool) (*scaleChange, error) {
+	c, err := m.buildScaleChange(factors)
+	if err != nil {
+		return nil, err
+	}
+	// 只在真正开始写入时留下标记，提交成功或者回滚之后各处的值已经一致，都删除标记
+	markerFile := getScaleMarkerFile()
+	err = writeScaleMarker(markerFile)
+	if err != nil {
+		logger.Warning("failed to write scale marker:", err)
+	}
+	err = m.commitScaleChangeWithRollback(c, emitSignal)
+	removeScaleMarker(markerFile)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// buildScaleChange 完成校验并计算出要写入的值，不改动任何状态，预览和实际设置共用
+func (m *XSManager) buildScaleChange(factors map[string]float64) (*scaleChange, error) {
+	err := checkScreenFactorsNotEmpty(factors)
+	if err != nil {
+		return nil, err
+	}
+	err = m.checkScaleChangeAllowed()
+	if err != nil {
+		return nil, err
+	}
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		logger.Warning("failed to get primary screen:", err)
+	} else if err = checkFactorsHavePrimary(factors, primary); err != nil {
+		return nil, err
+	}
+	if dup := findDuplicateScreenNames(factors); len(dup) > 0 {
+		logger.Warning("duplicate screen names differing only by case:", dup)
+	}
+	cfg := m.getScaleConfig()
+	if cfg.scalingMode == scalingModeUnified {
+		factors = collapseScreenFactors(factors, primary)
+	}
+	outputs, err := m.getConnectedOutputs()
+	if err == nil {
+		factors = adjustScreenFactorsForOutputs(factors, outputs, cfg)
+	} else {
+		logger.Warning("failed to get connected outputs:", err)
+	}
+	// 取整后再计算单值，保证窗口缩放和光标大小也使用取整后的值
+	if cfg.snapStep > 0 {
+		var exempt string
+		if cfg.snapExemptPrimary {
+			exempt = primary
 		}
+		factors = snapScreenFactors(factors, cfg.snapStep, exempt)
 	}
-	if len(factors) == 0 {
-		return errors.New("factors is empty")
+
+	c, err := prepareScaleChange(factors, primary, cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.edids = getOutputEdids(outputs)
+	if cfg.wineScaling {
+		c.wineScale = getWineScaleValue(c.factors, primary)
+	}
+	return c, nil
+}
+
+// 多个屏幕的设置中必须有主屏或者 ALL，否则 prepareScaleChange 无法按主屏得到单值
+func checkFactorsHavePrimary(factors map[string]float64, primary string) error {
+	if len(factors) <= 1 || primary == "" {
+		return nil
+	}
+	if _, ok := factors["ALL"]; ok {
+		return nil
+	}
+	if _, ok := factors[primary]; !ok {
+		return fmt.Errorf("scale factors %v missing primary screen %s", factors, primary)
+	}
+	return nil
+}
+
+const (
+	// 各屏幕分别设置缩放
+	scalingModeIndividual = "individual"
+	// 所有屏幕使用相同的缩放，只保存 ALL
+	scalingModeUnified = "unified"
+)
+
+func checkScalingMode(mode string) error {
+	switch mode {
+	case scalingModeIndividual, scalingModeUnified:
+		return nil
+	default:
+		return fmt.Errorf("invalid scaling mode %q", mode)
+	}
+}
+
+// collapseScreenFactors 合并为只有 ALL 的设置，优先使用主屏的缩放，与 getSingleScaleFactor 的结果一致
+func collapseScreenFactors(factors map[string]float64, primary string) map[string]float64 {
+	if v, ok := factors[primary]; ok && primary != "" {
+		return singleToMapSF(v)
+	}
+	return singleToMapSF(getSingleScaleFactor(factors))
+}
+
+func (m *XSManager) getScalingMode() string {
+	return m.startddeGs.GetString(gsKeyScalingMode)
+}
+
+// 切换到 unified 时立即把当前各屏幕的设置合并为一个，合并后的设置提交成功才保存模式并发送信号
+func (m *XSManager) setScalingMode(mode string) error {
+	err := checkScalingMode(mode)
+	if err != nil {
+		return err
+	}
+	if m.getScalingMode() == mode {
+		return nil
+	}
+	err = m.checkScaleChangeAllowed()
+	if err != nil {
+		return err
+	}
+
+	if mode == scalingModeUnified {
+		m.flushScaleApplier()
+		factors := m.getScreenScaleFactors()
+		if len(factors) > 1 {
+			primary, err := m.getPrimaryScreenName()
+			if err != nil {
+				logger.Warning("failed to get primary screen:", err)
+			}
+			err = m.setScreenScaleFactors(collapseScreenFactors(factors, primary), true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	logger.Info("set scaling mode:", mode)
+	m.startddeGs.SetString(gsKeyScalingMode, mode)
+	err = m.service.Emit(m, signalScalingModeChanged, mode)
+	if err != nil {
+		logger.Warning(err)
+	}
+	return nil
+}
+
+func (m *XSManager) getGtkCursorThemeSize() int32 {
+	return m.gs.GetInt(gsKeyGtkCursorThemeSize)
+}
+
+// 单独设置光标大小，不改变缩放。记录下缩放为 1 时对应的大小，之后改变缩放时以此为基准。
+func (m *XSManager) setGtkCursorThemeSize(size int32) error {
+	if size <= 0 {
+		return errors.New("invalid cursor size")
+	}
+	err := m.checkScaleChangeAllowed()
+	if err != nil {
+		return err
+	}
+	m.scaleMu.Lock()
+	defer m.scaleMu.Unlock()
+	cursorScale := getCursorScaleFactor(m.getScreenScaleFactors(), getScaleFactor(), m.getScaleConfig())
+	baseSize := deriveCursorBaseSize(size, cursorScale)
+	logger.Debugf("setGtkCursorThemeSize size: %d, base size: %d", size, baseSize)
+	oldSize := m.getGtkCursorThemeSize()
+	m.startddeGs.SetInt(gsKeyCursorBaseSize, baseSize)
+	m.setCursorSize(size)
+	m.emitCursorSizeChanged(oldSize, size, true)
+	return nil
+}
+
+// 清除单独设置的光标大小，恢复为按缩放比例计算的大小
+func (m *XSManager) resetCursorSizeToScale() error {
+	err := m.checkScaleChangeAllowed()
+	if err != nil {
+		return err
+	}
+	scale := getScaleFactor()
+	if scale <= 0 {
+		return fmt.Errorf("invalid scale factor %v", scale)
+	}
+	logger.Debug("reset cursor size to scale", scale)
+	m.startddeGs.Reset(gsKeyCursorBaseSize)
+	m.reassertScale()
+	return nil
+}
+
+// 清除所有屏幕单独的缩放设置，恢复为 1 倍缩放。
+// 与普通的设置走同一个流程，individual-scaling 整个替换为 ALL=1，与其他的值在同一次提交中写入，
+// 失败时一起回滚，也会发送 ScaleFactorChanged 和 SetScaleFactorDone 信号。
+func (m *XSManager) resetScaleFactor() error {
+	logger.Debug("resetScaleFactor")
+	err := m.checkScaleChangeAllowed()
+	if err != nil {
+		return err
 	}
+	return m.setScreenScaleFactors(singleToMapSF(1), true)
+}
 
-	err := m.dsfHelper.SetScaleFactors(factors)
-	if err != nil {
-		logger.Warning(err)
+func (m *XSManager) getScreenScaleFactors() map[string]float64 {
+	factorsJoined := m.gs.GetString(gsKeyIndividualScaling)
+	factors := parseScreenFactors(factorsJoined)
+	if dup := findDuplicateScreenNames(factors); len(dup) > 0 {
+		logger.Warningf("%s has duplicate screen names differing only by case: %v",
+			gsKeyIndividualScaling, dup)
 	}
+	return factors
+}
 
-	// 同时要设置单值的
-	singleFactor := getSingleScaleFactor(factors)
-	m.setScaleFactor(singleFactor, emitSignal)
+// individual-scaling 为空时，使用 scale-factor 作为 ALL 的值
+func (m *XSManager) getScreenScaleFactorsOrSingle() map[string]float64 {
+	return screenFactorsOrSingle(m.getScreenScaleFactors(), m.gs.GetDouble(gsKeyScaleFactor))
+}
 
-	// 关键保存位置
-	factorsJoined := joinScreenScaleFactors(factors)
-	m.gs.SetString(gsKeyIndividualScaling, factorsJoined)
+func screenFactorsOrSingle(factors map[string]float64, singleFactor float64) map[string]float64 {
+	if len(factors) == 0 {
+		return singleToMapSF(singleFactor)
+	}
+	return factors
+}
 
-	err = m.setScreenScaleFactorsForQt(factors)
+// 只根据 gsettings 中的设置重新生成 qt-theme.ini 中缩放相关的值，并同步给 greeter，不改变其他设置。
+func (m *XSManager) resetQtScaleFromGsettings() error {
+	factors := m.getScreenScaleFactorsOrSingle()
+	logger.Debug("resetQtScaleFromGsettings", factors)
+	err := m.setScreenScaleFactorsForQt(factors)
 	if err != nil {
 		return err
 	}
+	return m.qtThemeWriter.flush()
+}
 
-	err = cleanUpDdeEnv()
-	if err != nil {
-		logger.Warning("failed to clean up dde env", err)
-	}
+const plymouthConfigFile = "/etc/plymouth/plymouthd.conf"
 
-	return err
+// 系统服务不存在或者没有 ScalePlymouth 方法
+func isPlymouthUnavailableErr(err error) bool {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) {
+		return false
+	}
+	switch dbusErr.Name {
+	case "org.freedesktop.DBus.Error.ServiceUnknown",
+		"org.freedesktop.DBus.Error.NameHasNoOwner",
+		"org.freedesktop.DBus.Error.UnknownObject",
+		"org.freedesktop.DBus.Error.UnknownInterface",
+		"org.freedesktop.DBus.Error.UnknownMethod":
+		return true
+	}
+	return false
 }
 
-func (m *XSManager) getScreenScaleFactors() map[string]float64 {
-	factorsJoined := m.gs.GetString(gsKeyIndividualScaling)
-	return parseScreenFactors(factorsJoined)
+// 系统服务卡住时不能一直阻塞 Plymouth 的设置队列
+const defaultPlymouthScaleTimeout = 30 * time.Second
+
+func (m *XSManager) getPlymouthScaleTimeout() time.Duration {
+	if v := m.startddeGs.GetInt(gsKeyPlymouthTimeout); v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return defaultPlymouthScaleTimeout
 }
 
-const plymouthConfigFile = "/etc/plymouth/plymouthd.conf"
+// callWithTimeout 调用 fn，timeout 时间内没有返回时不再等待，返回 context.DeadlineExceeded。
+// timeout 不大于 0 时一直等待。
+func callWithTimeout(timeout time.Duration, fn func() error) error {
+	if timeout <= 0 {
+		return fn()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
 func (m *XSManager) setScaleFactorForPlymouthReal(factor int, emitSignal bool) {
 	logger.Debug("scalePlymouth", factor)
+	if m.plymouthUnavailable.Load() {
+		logger.Debug("skip scalePlymouth, unavailable", factor)
+		m.emitScaleDone(emitSignal)
+		return
+	}
+	if m.sysDaemon == nil {
+		logger.Warning("system daemon is unavailable, skip Plymouth scaling for this session")
+		m.plymouthUnavailable.Store(true)
+		m.emitScaleDone(emitSignal)
+		return
+	}
 	currentFactor := 0
 	theme, err := getPlymouthTheme(plymouthConfigFile)
 	if err == nil {
@@ -295,27 +1835,135 @@ func (m *XSManager) setScaleFactorForPlymouthReal(factor int, emitSignal bool) {
 
 	if currentFactor == factor {
 		logger.Debug("quick end scalePlymouth", factor)
-		m.emitSignalSetScaleFactor(true, emitSignal)
+		m.emitScaleDone(emitSignal)
 		return
 	}
 
-	m.emitSignalSetScaleFactor(false, emitSignal)
-	err = m.sysDaemon.ScalePlymouth(0, uint32(factor))
-	m.emitSignalSetScaleFactor(true, emitSignal)
+	stopProgress := m.startPlymouthProgress(emitSignal)
+	err = callWithTimeout(m.plymouthScaleTimeout, func() error {
+		return m.sysDaemon.ScalePlymouth(0, uint32(factor))
+	})
+	stopProgress()
+	m.emitScaleDone(emitSignal)
 
 	logger.Debug("end scalePlymouth", factor)
+	m.setLastPlymouthScaleError(err)
 	if err != nil {
-		logger.Warning(err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			logger.Warningf("scalePlymouth %d did not return in %v, continue with queued tasks", factor, m.plymouthScaleTimeout)
+		} else if isPlymouthUnavailableErr(err) {
+			logger.Warning("Plymouth scaling is unavailable, skip it for this session:", err)
+			m.plymouthUnavailable.Store(true)
+		} else {
+			logger.Warning(err)
+		}
+		m.emitPlymouthScaleFailed(err, emitSignal)
+	} else {
+		m.setPlymouthRebootPending()
+	}
+}
+
+// 记录最近一次设置 Plymouth 的结果，成功后清除
+func (m *XSManager) setLastPlymouthScaleError(err error) {
+	m.plymouthErrMu.Lock()
+	if err != nil {
+		m.lastPlymouthScaleErr = err.Error()
+	} else {
+		m.lastPlymouthScaleErr = ""
+	}
+	m.plymouthErrMu.Unlock()
+}
+
+func (m *XSManager) getLastPlymouthScaleError() string {
+	m.plymouthErrMu.Lock()
+	defer m.plymouthErrMu.Unlock()
+	return m.lastPlymouthScaleErr
+}
+
+// Plymouth 在后台设置，失败不影响缩放本身，通过信号通知控制中心提示开机画面可能不正确
+func (m *XSManager) emitPlymouthScaleFailed(err error, emitSignal bool) {
+	if !emitSignal {
+		return
+	}
+	emitErr := m.service.Emit(m, signalPlymouthScaleFailed, err.Error())
+	if emitErr != nil {
+		logger.Warning(emitErr)
+	}
+}
+
+const bootIDFile = "/proc/sys/kernel/random/boot_id"
+
+func getBootID() (string, error) {
+	data, err := ioutil.ReadFile(bootIDFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// 记录的是 Plymouth 缩放完成时的 boot id，重启后 boot id 改变，标记失效
+func isPlymouthRebootPending(pendingBootID, bootID string) bool {
+	return pendingBootID != "" && pendingBootID == bootID
+}
+
+func (m *XSManager) setPlymouthRebootPending() {
+	bootID, err := getBootID()
+	if err != nil {
+		logger.Warning("failed to get boot id:", err)
+		return
 	}
+	m.startddeGs.SetString(gsKeyPlymouthReboot, bootID)
+}
+
+func (m *XSManager) isPlymouthRebootPending() bool {
+	bootID, err := getBootID()
+	if err != nil {
+		logger.Warning("failed to get boot id:", err)
+		return false
+	}
+	return isPlymouthRebootPending(m.startddeGs.GetString(gsKeyPlymouthReboot), bootID)
+}
+
+// 启动时清除上次启动留下的标记
+func (m *XSManager) clearPlymouthRebootPending() {
+	pendingBootID := m.startddeGs.GetString(gsKeyPlymouthReboot)
+	if pendingBootID == "" || m.isPlymouthRebootPending() {
+		return
+	}
+	m.startddeGs.SetString(gsKeyPlymouthReboot, "")
+}
+
+const (
+	signalSetScaleFactorStarted = "SetScaleFactorStarted"
+	signalSetScaleFactorDone    = "SetScaleFactorDone"
+	signalScaleFactorChanged    = "ScaleFactorChanged"
+	signalScalingModeChanged    = "ScalingModeChanged"
+	signalPlymouthScaleFailed   = "PlymouthScaleFailed"
+	signalSetScaleFactorFailed  = "SetScaleFactorFailed"
+	signalCursorSizeChanged     = "CursorSizeChanged"
+)
+
+// 从 XSManager.signals 的定义中获取所有信号的名称，新增信号时不需要另外修改
+func listScaleSignals() []string {
+	field, ok := reflect.TypeOf(XSManager{}).FieldByName("signals")
+	if !ok {
+		return nil
+	}
+	signalsType := field.Type.Elem()
+	names := make([]string, signalsType.NumField())
+	for i := range names {
+		names[i] = signalsType.Field(i).Name
+	}
+	return names
 }
 
 func (m *XSManager) emitSignalSetScaleFactor(done, emitSignal bool) {
 	if !emitSignal {
 		return
 	}
-	signalName := "SetScaleFactorStarted"
+	signalName := signalSetScaleFactorStarted
 	if done {
-		signalName = "SetScaleFactorDone"
+		signalName = signalSetScaleFactorDone
 	}
 	err := m.service.Emit(m, signalName)
 	if err != nil {
@@ -323,6 +1971,27 @@ func (m *XSManager) emitSignalSetScaleFactor(done, emitSignal bool) {
 	}
 }
 
+// 发送修改前后的缩放设置，格式与 individual-scaling 相同
+func (m *XSManager) emitScaleFactorChanged(oldFactors, newFactors map[string]float64, emitSignal bool) {
+	if !emitSignal {
+		return
+	}
+	oldJoined := joinScreenScaleFactors(oldFactors)
+	newJoined := joinScreenScaleFactors(newFactors)
+	if oldJoined == newJoined {
+		return
+	}
+	err := m.service.Emit(m, signalScaleFactorChanged, oldJoined, newJoined)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+// 重新发送当前的状态，方便之后启动的程序同步
+func (m *XSManager) emitCurrentScaleState() {
+	m.emitSignalSetScaleFactor(true, true)
+}
+
 func (m *XSManager) startScaleFactorForPlymouth(factor int, emitSignal bool) {
 	logger.Debug("startScaleFactorForPlymouth", factor)
 	go func() {
@@ -342,18 +2011,102 @@ func (m *XSManager) endScaleFactorForPlymouth() {
 		factor := m.plymouthScalingTasks[len(m.plymouthScalingTasks)-1]
 		logger.Debug("use last in tasks:", factor, m.plymouthScalingTasks)
 		m.plymouthScalingTasks = nil
+		m.updatePlymouthQueueDepth()
 		m.startScaleFactorForPlymouth(factor, true)
 	}
 }
 
-func (m *XSManager) setScaleFactorForPlymouth(factor int, emitSignal bool) {
-	if factor > 2 {
-		factor = 2
+// 调用时需要持有 plymouthScalingMu
+func (m *XSManager) updatePlymouthQueueDepth() {
+	m.PropsMu.Lock()
+	m.setPropPlymouthQueueDepth(int32(len(m.plymouthScalingTasks)))
+	m.PropsMu.Unlock()
+}
+
+// plymouthSettler 缩放比例在 delay 时间内没有再次改变才把 Plymouth 的设置加入队列，
+// 连续修改时跳过中间的值，避免多次重新生成 initramfs。
+type plymouthSettler struct {
+	mu         sync.Mutex
+	timer      *time.Timer
+	factor     int
+	emitSignal bool
+	queue      func(factor int, emitSignal bool)
+}
+
+func newPlymouthSettler(queue func(factor int, emitSignal bool)) *plymouthSettler {
+	return &plymouthSettler{
+		queue: queue,
+	}
+}
+
+func (s *plymouthSettler) schedule(delay time.Duration, factor int, emitSignal bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+		// 被跳过的修改需要发送信号的，合并后也要发送
+		emitSignal = emitSignal || s.emitSignal
+	}
+	if delay <= 0 {
+		s.queue(factor, emitSignal)
+		return
+	}
+	s.factor = factor
+	s.emitSignal = emitSignal
+	s.timer = time.AfterFunc(delay, s.fire)
+}
+
+func (s *plymouthSettler) fire() {
+	s.mu.Lock()
+	if s.timer == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.timer = nil
+	factor, emitSignal := s.factor, s.emitSignal
+	s.mu.Unlock()
+
+	s.queue(factor, emitSignal)
+}
+
+const defaultPlymouthMaxScale = 2
+
+// Plymouth 缩放的上限，环境变量的值无效时使用默认值
+func getPlymouthMaxScale() int {
+	value := os.Getenv(envPlymouthMaxScale)
+	if value == "" {
+		return defaultPlymouthMaxScale
 	}
+	max, err := strconv.Atoi(value)
+	if err != nil || max < 1 {
+		logger.Warningf("invalid %s %q, use default %d", envPlymouthMaxScale, value, defaultPlymouthMaxScale)
+		return defaultPlymouthMaxScale
+	}
+	return max
+}
+
+func clampPlymouthFactor(factor, max int) int {
+	if factor > max {
+		logger.Infof("plymouth scale factor %d exceeds max %d, clamp to %d", factor, max, max)
+		return max
+	}
+	return factor
+}
+
+func (m *XSManager) setScaleFactorForPlymouth(factor int, emitSignal bool) {
+	factor = clampPlymouthFactor(factor, getPlymouthMaxScale())
 	m.plymouthScalingMu.Lock()
 
 	if m.plymouthScaling {
-		m.plymouthScalingTasks = append(m.plymouthScalingTasks, factor)
+		// 结束后只会使用最后一个值，队列中只保留最新的
+		if len(m.plymouthScalingTasks) == 0 {
+			m.plymouthScalingTasks = []int{factor}
+			m.updatePlymouthQueueDepth()
+		} else {
+			m.plymouthScalingTasks[0] = factor
+		}
 		logger.Debug("add to tasks", factor)
 	} else {
 		m.plymouthScaling = true
@@ -363,6 +2116,70 @@ func (m *XSManager) setScaleFactorForPlymouth(factor int, emitSignal bool) {
 	m.plymouthScalingMu.Unlock()
 }
 
+// toolkitScaleState 各个组件实际使用的缩放相关的值
+type toolkitScaleState struct {
+	windowScale    int32
+	qtFactors      map[string]float64
+	primary        string
+	cursorSize     int32
+	cursorBaseSize int32
+	plymouthFactor int // 0 表示未知
+}
+
+// 各组件实际使用的缩放比例，开启了各种选项时可能互不相同
+func getEffectiveScaleByToolkit(s toolkitScaleState) map[string]float64 {
+	result := map[string]float64{
+		"gtk": float64(s.windowScale),
+		"qt":  resolveScreenFactor(s.qtFactors, s.primary),
+	}
+	if s.cursorBaseSize > 0 {
+		result["cursor"] = math.Round(float64(s.cursorSize)/float64(s.cursorBaseSize)*100) / 100
+	}
+	if s.plymouthFactor > 0 {
+		result["plymouth"] = float64(s.plymouthFactor)
+	}
+	return result
+}
+
+// 读取 qt-theme.ini 中实际写入的值
+func loadQtScreenScaleFactors(filename string) (map[string]float64, error) {
+	kf := keyfile.NewKeyFile()
+	err := kf.LoadFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	value, err := kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+	if err != nil {
+		return nil, err
+	}
+	return parseQtScreenScaleFactors(value)
+}
+
+func (m *XSManager) getEffectiveScaleByToolkit() map[string]float64 {
+	s := toolkitScaleState{
+		windowScale:    m.gs.GetInt(gsKeyWindowScale),
+		cursorSize:     m.gs.GetInt(gsKeyGtkCursorThemeSize),
+		cursorBaseSize: m.getScaleConfig().cursorBaseSize,
+	}
+	var err error
+	s.qtFactors, err = loadQtScreenScaleFactors(getQtThemeFile())
+	if err != nil {
+		logger.Debug("failed to load qt scale factors:", err)
+		s.qtFactors = m.getScreenScaleFactors()
+	}
+	s.primary, err = m.getPrimaryScreenName()
+	if err != nil {
+		logger.Warning("failed to get primary screen name:", err)
+	}
+	theme, err := getPlymouthTheme(plymouthConfigFile)
+	if err == nil {
+		s.plymouthFactor = getPlymouthThemeScaleFactor(theme)
+	} else {
+		logger.Debug(err)
+	}
+	return getEffectiveScaleByToolkit(s)
+}
+
 func getPlymouthTheme(file string) (string, error) {
 	var kf = keyfile.NewKeyFile()
 	err := kf.LoadFromFile(file)
@@ -373,18 +2190,240 @@ func getPlymouthTheme(file string) (string, error) {
 	return kf.GetString("Daemon", "Theme")
 }
 
-func getPlymouthThemeScaleFactor(theme string) int {
-	switch theme {
-	case "deepin-logo", "deepin-ssd-logo", "uos-ssd-logo":
-		return 1
-	case "deepin-hidpi-logo", "deepin-hidpi-ssd-logo", "uos-hidpi-ssd-logo":
+// 已知的 Plymouth 主题对应的缩放比例
+var builtinPlymouthThemeScaleFactors = map[string]int{
+	"deepin-logo":           1,
+	"deepin-ssd-logo":       1,
+	"uos-ssd-logo":          1,
+	"deepin-hidpi-logo":     2,
+	"deepin-hidpi-ssd-logo": 2,
+	"uos-hidpi-ssd-logo":    2,
+}
+
+// 发行版可以在该文件中补充其他主题，每行格式为 <主题名>=<缩放比例>
+const plymouthThemeScaleFile = "/usr/share/startdde/plymouth-theme-scale.conf"
+
+var plymouthThemeScaleTable struct {
+	once    sync.Once
+	factors map[string]int
+}
+
+func loadPlymouthThemeScaleFactors(filename string) (map[string]int, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			logger.Warningf("%s: invalid line %q", filename, line)
+			continue
+		}
+		theme := strings.TrimSpace(kv[0])
+		factor, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if theme == "" || err != nil || factor < 1 {
+			logger.Warningf("%s: invalid line %q", filename, line)
+			continue
+		}
+		result[theme] = factor
+	}
+	return result, nil
+}
+
+func getPlymouthThemeScaleFactors() map[string]int {
+	plymouthThemeScaleTable.once.Do(func() {
+		factors := make(map[string]int, len(builtinPlymouthThemeScaleFactors))
+		for theme, factor := range builtinPlymouthThemeScaleFactors {
+			factors[theme] = factor
+		}
+		extra, err := loadPlymouthThemeScaleFactors(plymouthThemeScaleFile)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warning(err)
+		}
+		for theme, factor := range extra {
+			factors[theme] = factor
+		}
+		plymouthThemeScaleTable.factors = factors
+	})
+	return plymouthThemeScaleTable.factors
+}
+
+// 查表找不到时，名称中含有 -hidpi- 的按 2 倍处理，其他的按 1 倍处理
+func lookupPlymouthThemeScaleFactor(factors map[string]int, theme string) int {
+	if factor, ok := factors[theme]; ok {
+		return factor
+	}
+	if strings.Contains(theme, "-hidpi-") {
 		return 2
-	default:
-		return 0
 	}
+	return 1
+}
+
+func getPlymouthThemeScaleFactor(theme string) int {
+	return lookupPlymouthThemeScaleFactor(getPlymouthThemeScaleFactors(), theme)
+}
+
+// 生成只用于 greeter 的 qt 主题配置，不读取当前用户的 qt-theme.ini
+func buildGreeterQtTheme(factor, minFactor, maxFactor float64) (*keyfile.KeyFile, error) {
+	if factor < minFactor || factor > maxFactor {
+		return nil, fmt.Errorf("scale factor %v out of range [%v, %v]",
+			factor, minFactor, maxFactor)
+	}
+	value, err := getQtScreenScaleFactorsValue(singleToMapSF(factor))
+	if err != nil {
+		return nil, err
+	}
+	kf := keyfile.NewKeyFile()
+	kf.SetValue(qtThemeSection, qtThemeKeyScreenScaleFactors, value)
+	return kf, nil
+}
+
+// 只设置登录界面的缩放，不改变当前会话的 gsettings 和 qt-theme.ini
+func (m *XSManager) setGreeterScaleFactor(factor float64) error {
+	err := m.checkScaleChangeAllowed()
+	if err != nil {
+		return err
+	}
+	cfg := m.getScaleConfig()
+	kf, err := buildGreeterQtTheme(factor, cfg.minFactor, cfg.maxFactor)
+	if err != nil {
+		return err
+	}
+	// 避免等待中的同步覆盖本次的设置
+	err = m.qtThemeWriter.flush()
+	if err != nil {
+		logger.Warning("failed to write qt-theme.ini:", err)
+	}
+	return m.updateGreeterQtTheme(kf)
+}
+
+var errGreeterScaleNotSupported = errors.New("reading the greeter scale is not supported")
+
+// 解析 qt-theme.ini 中的 ScreenScaleFactors，单值时返回 ALL
+func parseQtScreenScaleFactors(value string) (map[string]float64, error) {
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	if !strings.Contains(value, "=") {
+		factor, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return singleToMapSF(factor), nil
+	}
+	factors := parseScreenFactors(value)
+	if len(factors) == 0 {
+		return nil, fmt.Errorf("invalid %s %q", qtThemeKeyScreenScaleFactors, value)
+	}
+	return factors, nil
+}
+
+// greeter 的 DBus 接口不能读取缩放，读取 greeter 收到后写入的 qt-theme.ini
+func readGreeterScaleFactors(filename string) (map[string]float64, error) {
+	factors, err := loadQtScreenScaleFactors(filename)
+	if err != nil {
+		switch err.(type) {
+		case keyfile.SectionNotFoundError, keyfile.KeyNotFoundError:
+			return nil, errGreeterScaleNotSupported
+		}
+		if os.IsNotExist(err) {
+			return nil, errGreeterScaleNotSupported
+		}
+		return nil, err
+	}
+	return factors, nil
+}
+
+// 比较 greeter 中主屏的缩放比例与当前会话的是否一致
+func verifyGreeterScale(readGreeter func() (map[string]float64, error),
+	factors map[string]float64, primary string) (bool, error) {
+	greeterFactors, err := readGreeter()
+	if err != nil {
+		return false, err
+	}
+	want := resolveScreenFactor(factors, primary)
+	got := resolveScreenFactor(greeterFactors, primary)
+	if !scaleFactorEqual(want, got) {
+		logger.Debugf("greeter scale factor of %q is %v, want %v", primary, got, want)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *XSManager) verifyGreeterScale() (bool, error) {
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		return false, err
+	}
+	return verifyGreeterScale(func() (map[string]float64, error) {
+		return readGreeterScaleFactors(greeterQtThemeFile)
+	}, m.getScreenScaleFactors(), primary)
+}
+
+// 会话启动早期 greeter 的服务可能还没有注册，失败后按 greeterRetryDelay、2 倍、4 倍……的间隔重试
+const greeterUpdateAttempts = 3
+
+var greeterRetryDelay = time.Second
+
+func getGreeterQtThemeData(kf *keyfile.KeyFile) ([]byte, error) {
+	kf.SetValue(qtThemeSection, qtThemeKeyScaleLogicalDpi, "96,96")
+	var buf bytes.Buffer
+	err := kf.SaveToWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 func (m *XSManager) updateGreeterQtTheme(kf *keyfile.KeyFile) error {
+	data, err := getGreeterQtThemeData(kf)
+	if err != nil {
+		return err
+	}
+	return m.sendGreeterQtTheme(data)
+}
+
+// 设置缩放时在后台同步 greeter，重试不阻塞缩放的设置
+func (m *XSManager) updateGreeterQtThemeAsync(kf *keyfile.KeyFile) error {
+	data, err := getGreeterQtThemeData(kf)
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := m.sendGreeterQtTheme(data)
+		if err != nil {
+			logger.Warning("failed to update greeter qt theme:", err)
+		}
+	}()
+	return nil
+}
+
+const greeterService = "org.deepin.dde.Greeter1"
+
+// 精简的系统上没有 greeter，不需要同步
+func (m *XSManager) isGreeterAvailable() bool {
+	if m.greeterAvailable == nil {
+		return true
+	}
+	available, err := m.greeterAvailable()
+	if err != nil {
+		logger.Debug("failed to check greeter service:", err)
+		return true
+	}
+	return available
+}
+
+func (m *XSManager) sendGreeterQtTheme(data []byte) error {
+	if !m.isGreeterAvailable() {
+		logger.Debug("greeter service not found, skip updating greeter qt theme")
+		return nil
+	}
+	// 重试期间临时文件一直保留，保证传给 greeter 的文件描述符有效
 	tempFile, err := ioutil.TempFile("", "startdde-qt-theme-")
 	if err != nil {
 		return err
@@ -400,17 +2439,23 @@ func (m *XSManager) updateGreeterQtTheme(kf *keyfile.KeyFile) error {
 		}
 	}()
 
-	kf.SetValue(qtThemeSection, qtThemeKeyScaleLogicalDpi, "96,96")
-	err = kf.SaveToWriter(tempFile)
+	_, err = tempFile.Write(data)
 	if err != nil {
 		return err
 	}
 
-	_, err = tempFile.Seek(0, io.SeekStart)
-	if err != nil {
-		return err
+	delay := greeterRetryDelay
+	for attempt := 1; ; attempt++ {
+		_, err = tempFile.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		err = m.greeter.UpdateGreeterQtTheme(0, dbus.UnixFD(tempFile.Fd()))
+		if err == nil || attempt == greeterUpdateAttempts {
+			return err
+		}
+		logger.Debugf("update greeter qt theme failed, retry in %v: %v", delay, err)
+		time.Sleep(delay)
+		delay *= 2
 	}
-
-	err = m.greeter.UpdateGreeterQtTheme(0, dbus.UnixFD(tempFile.Fd()))
-	return err
 }
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// scaleApplier 合并短时间内多次设置缩放的请求，只应用最后一次的值。
+// 拖动缩放滑块时每秒会调用几十次，每次都写 gsettings 和 qt-theme.ini 并设置 Plymouth。
+type scaleApplier struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending map[string]float64
+
+	// 保证按请求的顺序应用，最后请求的值最后写入。失败时由 apply 自己发送信号报告。
+	applyMu sync.Mutex
+	apply   func(factors map[string]float64) error
+}
+
+func newScaleApplier(apply func(factors map[string]float64) error) *scaleApplier {
+	return &scaleApplier{
+		apply: apply,
+	}
+}
+
+// schedule 在 delay 之后应用 factors，期间再次调用会替换等待中的值并重新计时。delay 不大于 0 时立即应用。
+func (a *scaleApplier) schedule(delay time.Duration, factors map[string]float64) error {
+	pending := make(map[string]float64, len(factors))
+	for name, factor := range factors {
+		pending[name] = factor
+	}
+
+	a.mu.Lock()
+	a.pending = pending
+	if delay <= 0 {
+		a.mu.Unlock()
+		return a.applyPending()
+	}
+	if a.timer == nil {
+		a.timer = time.AfterFunc(delay, a.flush)
+	} else {
+		a.timer.Reset(delay)
+	}
+	a.mu.Unlock()
+	return nil
+}
+
+// flush 立即应用等待中的值。
+// 直接设置缩放之前要先调用，避免等待中的旧值之后覆盖直接设置的值。
+func (a *scaleApplier) flush() {
+	err := a.applyPending()
+	if err != nil {
+		logger.Warning("failed to apply scale factors:", err)
+	}
+}
+
+func (a *scaleApplier) applyPending() error {
+	a.applyMu.Lock()
+	defer a.applyMu.Unlock()
+
+	a.mu.Lock()
+	factors := a.pending
+	a.pending = nil
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	a.mu.Unlock()
+
+	if factors == nil {
+		return nil
+	}
+	return a.apply(factors)
+}
+
+// 排队前先做不需要读取屏幕信息的检查，这些错误可以直接返回给调用者
+func checkScreenScaleFactors(factors map[string]float64) error {
+	err := checkScreenFactorsNotEmpty(factors)
+	if err != nil {
+		return err
+	}
+	for _, f := range factors {
+		if f <= 0 {
+			return errors.New("invalid value")
+		}
+	}
+	return nil
+}
+
+// 通过 DBus 设置缩放时合并短时间内的多次调用，SetScaleFactorDone 信号在实际应用完成后发送
+func (m *XSManager) scheduleScreenScaleFactors(factors map[string]float64) error {
+	err := m.checkScaleChangeAllowed()
+	if err != nil {
+		return err
+	}
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		logger.Warning("failed to get primary screen:", err)
+	} else if err = checkFactorsHavePrimary(factors, primary); err != nil {
+		return err
+	}
+	err = checkScreenScaleFactors(factors)
+	if err != nil {
+		return err
+	}
+	return m.scaleApplier.schedule(m.getScaleConfig().applyDelay, factors)
+}
+
+// 设置缩放失败时发送 SetScaleFactorFailed 和 SetScaleFactorDone，结束这一次设置，控制中心据此结束等待。
+// 通过 DBus 排队的值应用失败时已经没有调用者可以接收错误，只能通过信号报告。
+func (m *XSManager) emitScaleFailed(err error, emitSignal bool) {
+	if !emitSignal {
+		return
+	}
+	emitErr := m.service.Emit(m, signalSetScaleFactorFailed, err.Error())
+	if emitErr != nil {
+		logger.Warning(emitErr)
+	}
+	m.emitScaleDone(true)
+}
+
+// 在读取当前的缩放设置并在此基础上修改之前调用，保证读到的是最后请求的值
+func (m *XSManager) flushScaleApplier() {
+	if m.scaleApplier != nil {
+		m.scaleApplier.flush()
+	}
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_scaleApplier(t *testing.T) {
+	var mu sync.Mutex
+	var applied []map[string]float64
+	a := newScaleApplier(func(factors map[string]float64) error {
+		mu.Lock()
+		applied = append(applied, factors)
+		mu.Unlock()
+		return nil
+	})
+	getApplied := func() []map[string]float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]map[string]float64(nil), applied...)
+	}
+
+	// 拖动滑块，只应用最后一次的值
+	for _, f := range []float64{1.25, 1.5, 1.75, 2} {
+		assert.NoError(t, a.schedule(50*time.Millisecond, singleToMapSF(f)))
+	}
+	assert.Empty(t, getApplied())
+	assert.Eventually(t, func() bool {
+		return len(getApplied()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, singleToMapSF(2), getApplied()[0])
+
+	// 调用者之后修改传入的 map 不影响等待中的值
+	factors := map[string]float64{"eDP-1": 2, "HDMI-1": 1}
+	assert.NoError(t, a.schedule(time.Hour, factors))
+	factors["HDMI-1"] = 3
+	a.flush()
+	assert.Equal(t, map[string]float64{"eDP-1": 2, "HDMI-1": 1}, getApplied()[1])
+
+	// 没有等待的值时不应用
+	a.flush()
+	assert.Len(t, getApplied(), 2)
+
+	// delay 为 0 时立即应用并返回错误
+	errApply := errors.New("apply failed")
+	a.apply = func(factors map[string]float64) error {
+		return errApply
+	}
+	assert.Equal(t, errApply, a.schedule(0, singleToMapSF(1)))
+}
+
+func Test_emitScaleFailed(t *testing.T) {
+	emitter := &fakeSignalEmitter{}
+	m := &XSManager{service: emitter}
+	m.emitScaleFailed(errors.New("disk full"), false)
+	assert.Empty(t, emitter.getSignals())
+
+	m.emitScaleFailed(errors.New("disk full"), true)
+	// 还没有开始设置时只发送结束的信号
+	assert.Equal(t, []string{signalSetScaleFactorFailed, signalSetScaleFactorDone},
+		emitter.getSignals())
+	assert.Equal(t, []interface{}{"disk full"}, emitter.values[0])
+
+	emitter = &fakeSignalEmitter{}
+	m.service = emitter
+	m.emitScaleProgress(scaleProgressDsfHelper, true)
+	m.emitScaleFailed(errors.New("disk full"), true)
+	assert.Equal(t, []string{signalSetScaleFactorStarted, signalSetScaleFactorProgress,
+		signalSetScaleFactorFailed, signalSetScaleFactorProgress, signalSetScaleFactorDone},
+		emitter.getSignals())
+
+	// 结束后下一次设置重新发送 SetScaleFactorStarted
+	m.emitScaleProgress(scaleProgressDsfHelper, true)
+	assert.Equal(t, signalSetScaleFactorStarted, emitter.signals[len(emitter.signals)-2])
+}
+
+func Test_scaleApplier_concurrent(t *testing.T) {
+	var mu sync.Mutex
+	var last map[string]float64
+	a := newScaleApplier(func(factors map[string]float64) error {
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		last = factors
+		mu.Unlock()
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, a.schedule(time.Millisecond, map[string]float64{"eDP-1": 1 + float64(i)/10}))
+		}(i)
+	}
+	wg.Wait()
+	final := map[string]float64{"eDP-1": 3}
+	assert.NoError(t, a.schedule(time.Millisecond, final))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return assert.ObjectsAreEqual(final, last)
+	}, time.Second, 10*time.Millisecond)
+	// 最后请求的值应用之后不会再被之前的值覆盖
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, final, last)
+	mu.Unlock()
+}
+
+func Test_checkScreenScaleFactors(t *testing.T) {
+	assert.NoError(t, checkScreenScaleFactors(map[string]float64{"eDP-1": 2}))
+	assert.Error(t, checkScreenScaleFactors(nil))
+	assert.Error(t, checkScreenScaleFactors(map[string]float64{"eDP-1": 0}))
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 运行中的 Qt 和 GTK 程序只在启动时读取缩放，修改缩放后需要重启才能完全生效。
+// 通过进程加载的图形库判断程序使用的工具包。
+var appToolkitLibs = []string{
+	"libQt5Gui.so",
+	"libQt6Gui.so",
+	"libgtk-3.so",
+	"libgtk-x11-2.0.so",
+}
+
+type processInfo struct {
+	pid  int
+	name string
+	libs []string
+}
+
+func isToolkitApp(p processInfo) bool {
+	for _, lib := range p.libs {
+		for _, sig := range appToolkitLibs {
+			if strings.HasPrefix(lib, sig) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findAppsNeedingRestart 返回需要重启的程序名称，去重并排序，跳过 selfPid
+func findAppsNeedingRestart(procs []processInfo, selfPid int) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, p := range procs {
+		if p.pid == selfPid || p.name == "" || seen[p.name] {
+			continue
+		}
+		if isToolkitApp(p) {
+			seen[p.name] = true
+			result = append(result, p.name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// listUserProcesses 列出 procDir 中属于 uid 的进程及其加载的动态库，读取失败的进程会被跳过
+func listUserProcesses(procDir string, uid uint32) ([]processInfo, error) {
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	var result []processInfo
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		stat, ok := entry.Sys().(*syscall.Stat_t)
+		if !ok || stat.Uid != uid {
+			continue
+		}
+		dir := filepath.Join(procDir, entry.Name())
+		comm, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil {
+			continue
+		}
+		libs, err := readProcessLibs(filepath.Join(dir, "maps"))
+		if err != nil {
+			continue
+		}
+		result = append(result, processInfo{
+			pid:  pid,
+			name: strings.TrimSpace(string(comm)),
+			libs: libs,
+		})
+	}
+	return result, nil
+}
+
+// 从 /proc/<pid>/maps 中读取映射的文件名
+func readProcessLibs(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	var libs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		lib := filepath.Base(fields[5])
+		if !seen[lib] {
+			seen[lib] = true
+			libs = append(libs, lib)
+		}
+	}
+	return libs, scanner.Err()
+}
+
+func getAppsNeedingRestart() ([]string, error) {
+	procs, err := listUserProcesses("/proc", uint32(os.Getuid()))
+	if err != nil {
+		return nil, err
+	}
+	return findAppsNeedingRestart(procs, os.Getpid()), nil
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_findAppsNeedingRestart(t *testing.T) {
+	procs := []processInfo{
+		{pid: 10, name: "startdde", libs: []string{"libQt5Gui.so.5"}},
+		{pid: 11, name: "dde-file-manager", libs: []string{"libc.so.6", "libQt5Gui.so.5"}},
+		{pid: 12, name: "gedit", libs: []string{"libgtk-3.so.0"}},
+		{pid: 13, name: "bash", libs: []string{"libc.so.6"}},
+		{pid: 14, name: "dde-file-manager", libs: []string{"libQt5Gui.so.5"}},
+		{pid: 15, name: "deepin-terminal", libs: []string{"libQt6Gui.so.6"}},
+	}
+	assert.Equal(t, []string{"dde-file-manager", "deepin-terminal", "gedit"},
+		findAppsNeedingRestart(procs, 10))
+	assert.Nil(t, findAppsNeedingRestart(nil, 10))
+}
+
+func Test_listUserProcesses(t *testing.T) {
+	procDir := t.TempDir()
+	writeProc := func(pid, comm, maps string) {
+		dir := filepath.Join(procDir, pid)
+		require.NoError(t, os.Mkdir(dir, 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "comm"), []byte(comm+"\n"), 0644))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "maps"), []byte(maps), 0644))
+	}
+	writeProc("100", "gedit",
+		"7f00-7f01 r-xp 00000000 08:01 1 /usr/lib/x86_64-linux-gnu/libgtk-3.so.0.2404.1\n"+
+			"7f01-7f02 r-xp 00000000 08:01 2 /usr/lib/x86_64-linux-gnu/libgtk-3.so.0.2404.1\n"+
+			"7ffd-7ffe rw-p 00000000 00:00 0 [stack]\n"+
+			"7ffe-7fff rw-p 00000000 00:00 0\n")
+	require.NoError(t, os.Mkdir(filepath.Join(procDir, "self"), 0755))
+
+	procs, err := listUserProcesses(procDir, uint32(os.Getuid()))
+	require.NoError(t, err)
+	assert.Equal(t, []processInfo{
+		{pid: 100, name: "gedit", libs: []string{"libgtk-3.so.0.2404.1", "[stack]"}},
+	}, procs)
+
+	procs, err = listUserProcesses(procDir, uint32(os.Getuid())+1)
+	require.NoError(t, err)
+	assert.Empty(t, procs)
+}
@@ -0,0 +1,288 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/linuxdeepin/go-lib/keyfile"
+)
+
+const (
+	scaleBackupGSettings = "gsettings.json"
+	scaleBackupQtTheme   = "qt-theme.ini"
+	scaleBackupSchedule  = "scale-schedule.json"
+)
+
+type scaleGSettingsBackup struct {
+	ScaleFactor       float64
+	IndividualScaling string
+	CursorBaseSize    int32
+}
+
+// scaleBackup 导出的缩放配置
+type scaleBackup struct {
+	gsettings scaleGSettingsBackup
+	qtTheme   []byte // 可能为空
+	schedule  []byte // 可能为空
+}
+
+func (b *scaleBackup) getFactors() map[string]float64 {
+	factors := parseScreenFactors(b.gsettings.IndividualScaling)
+	if len(factors) == 0 {
+		factors = singleToMapSF(b.gsettings.ScaleFactor)
+	}
+	return factors
+}
+
+// 在应用之前检查所有的内容
+func (b *scaleBackup) validate() error {
+	if b.gsettings.ScaleFactor <= 0 {
+		return fmt.Errorf("invalid scale factor %v", b.gsettings.ScaleFactor)
+	}
+	for name, factor := range b.getFactors() {
+		if factor <= 0 {
+			return fmt.Errorf("invalid scale factor %v for %q", factor, name)
+		}
+	}
+	if b.gsettings.CursorBaseSize < 0 {
+		return fmt.Errorf("invalid cursor base size %v", b.gsettings.CursorBaseSize)
+	}
+	if len(b.qtTheme) > 0 {
+		err := keyfile.NewKeyFile().LoadFromData(b.qtTheme)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", scaleBackupQtTheme, err)
+		}
+	}
+	if len(b.schedule) > 0 {
+		var entries []ScheduleEntry
+		err := json.Unmarshal(b.schedule, &entries)
+		if err == nil {
+			err = validateScheduleEntries(entries)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", scaleBackupSchedule, err)
+		}
+	}
+	return nil
+}
+
+func writeScaleBackup(w io.Writer, b *scaleBackup) error {
+	gsData, err := json.Marshal(b.gsettings)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{scaleBackupGSettings, gsData},
+		{scaleBackupQtTheme, b.qtTheme},
+		{scaleBackupSchedule, b.schedule},
+	}
+	now := time.Now()
+	for _, file := range files {
+		if file.data == nil {
+			continue
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:    file.name,
+			Mode:    0644,
+			Size:    int64(len(file.data)),
+			ModTime: now,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(file.data)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func readScaleBackup(r io.Reader) (*scaleBackup, error) {
+	var b scaleBackup
+	hasGSettings := false
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 只需要几个小文件
+		data, err := ioutil.ReadAll(io.LimitReader(tr, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+		switch hdr.Name {
+		case scaleBackupGSettings:
+			err = json.Unmarshal(data, &b.gsettings)
+			if err != nil {
+				return nil, err
+			}
+			hasGSettings = true
+		case scaleBackupQtTheme:
+			b.qtTheme = data
+		case scaleBackupSchedule:
+			b.schedule = data
+		default:
+			logger.Debug("ignore unknown file in scale backup:", hdr.Name)
+		}
+	}
+	if !hasGSettings {
+		return nil, errors.New("missing " + scaleBackupGSettings)
+	}
+
+	err := b.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func readFileIfExist(filename string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (m *XSManager) exportScaleConfig(filename string) error {
+	b := &scaleBackup{
+		gsettings: scaleGSettingsBackup{
+			ScaleFactor:       m.gs.GetDouble(gsKeyScaleFactor),
+			IndividualScaling: m.gs.GetString(gsKeyIndividualScaling),
+			CursorBaseSize:    m.startddeGs.GetInt(gsKeyCursorBaseSize),
+		},
+	}
+	var err error
+	b.qtTheme, err = readFileIfExist(getQtThemeFile())
+	if err != nil {
+		return err
+	}
+	b.schedule, err = readFileIfExist(getScaleScheduleFile())
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = writeScaleBackup(&buf, b)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+func (m *XSManager) importScaleConfig(filename string) error {
+	// 锁定时连同光标大小等设置都不导入
+	err := m.checkScaleChangeAllowed()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	b, err := readScaleBackup(f)
+	if err != nil {
+		return err
+	}
+	// 与直接设置时一样检查主屏并限制范围，检查不通过时不写入任何内容
+	_, err = m.buildScaleChange(b.getFactors())
+	if err != nil {
+		return err
+	}
+	var entries []ScheduleEntry
+	if len(b.schedule) > 0 {
+		err = json.Unmarshal(b.schedule, &entries)
+		if err != nil {
+			return err
+		}
+		err = validateScheduleEntries(entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 之后的步骤失败时恢复导入之前的值，缩放设置回滚之后 qt-theme.ini 还是导入的文件
+	var restores []func()
+	rollback := func() {
+		for i := len(restores) - 1; i >= 0; i-- {
+			restores[i]()
+		}
+	}
+
+	if len(b.qtTheme) > 0 {
+		// 其他的 qt 配置原样恢复，缩放相关的值之后会重新写入。
+		// 先写入等待中的值，避免之后覆盖导入的文件
+		err = m.qtThemeWriter.flush()
+		if err != nil {
+			logger.Warning("failed to write qt-theme.ini:", err)
+		}
+		qtThemeFile := getQtThemeFile()
+		var oldQtTheme []byte
+		oldQtTheme, err = ioutil.ReadFile(qtThemeFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		err = saveFileAtomic(qtThemeFile, b.qtTheme, nil)
+		if err != nil {
+			return err
+		}
+		restores = append(restores, func() {
+			restoreErr := restoreFile(qtThemeFile, oldQtTheme)
+			if restoreErr != nil {
+				logger.Warning("failed to restore qt-theme.ini:", restoreErr)
+			}
+		})
+	}
+
+	if len(entries) > 0 {
+		oldEntries := m.scaleScheduler.getEntries()
+		err = m.setScaleSchedule(entries)
+		if err != nil {
+			rollback()
+			return err
+		}
+		restores = append(restores, func() {
+			restoreErr := m.setScaleSchedule(oldEntries)
+			if restoreErr != nil {
+				logger.Warning("failed to restore scale schedule:", restoreErr)
+			}
+		})
+	}
+
+	oldCursorBaseSize := m.startddeGs.GetInt(gsKeyCursorBaseSize)
+	m.startddeGs.SetInt(gsKeyCursorBaseSize, b.gsettings.CursorBaseSize)
+	restores = append(restores, func() {
+		m.startddeGs.SetInt(gsKeyCursorBaseSize, oldCursorBaseSize)
+	})
+	err = m.setScreenScaleFactors(b.getFactors(), true)
+	if err != nil {
+		rollback()
+		return err
+	}
+	return nil
+}
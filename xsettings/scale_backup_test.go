@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gio "github.com/linuxdeepin/go-gir/gio-2.0"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_scaleBackup(t *testing.T) {
+	b := &scaleBackup{
+		gsettings: scaleGSettingsBackup{
+			ScaleFactor:       1.25,
+			IndividualScaling: "HDMI-1=1.00;eDP-1=1.25",
+			CursorBaseSize:    32,
+		},
+		qtTheme:  []byte("[Theme]\nScreenScaleFactors=\"HDMI-1=1.00;eDP-1=1.25\"\n"),
+		schedule: []byte(`[{"Hour":20,"Minute":0,"Factor":1.5}]`),
+	}
+
+	var buf bytes.Buffer
+	err := writeScaleBackup(&buf, b)
+	require.NoError(t, err)
+
+	got, err := readScaleBackup(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, b, got)
+	assert.Equal(t, map[string]float64{"HDMI-1": 1, "eDP-1": 1.25}, got.getFactors())
+
+	// 缺少 qt-theme.ini 和时间表
+	b = &scaleBackup{
+		gsettings: scaleGSettingsBackup{ScaleFactor: 2},
+	}
+	buf.Reset()
+	err = writeScaleBackup(&buf, b)
+	require.NoError(t, err)
+	got, err = readScaleBackup(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"ALL": 2}, got.getFactors())
+}
+
+func Test_readScaleBackup_invalid(t *testing.T) {
+	write := func(files map[string]string) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for name, content := range files {
+			err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+			require.NoError(t, err)
+			_, err = tw.Write([]byte(content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+		return &buf
+	}
+
+	_, err := readScaleBackup(write(map[string]string{
+		scaleBackupQtTheme: "[Theme]\n",
+	}))
+	assert.Error(t, err)
+
+	_, err = readScaleBackup(write(map[string]string{
+		scaleBackupGSettings: `{"ScaleFactor":0}`,
+	}))
+	assert.Error(t, err)
+
+	_, err = readScaleBackup(write(map[string]string{
+		scaleBackupGSettings: `{"ScaleFactor":1,"IndividualScaling":"HDMI-1=-1;eDP-1=1"}`,
+	}))
+	assert.Error(t, err)
+
+	_, err = readScaleBackup(write(map[string]string{
+		scaleBackupGSettings: `{"ScaleFactor":1}`,
+		scaleBackupSchedule:  `[{"Hour":25,"Minute":0,"Factor":1.5}]`,
+	}))
+	assert.Error(t, err)
+}
+
+func Test_importScaleConfig_validateFirst(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema, startddeSchema)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	var buf bytes.Buffer
+	require.NoError(t, writeScaleBackup(&buf, &scaleBackup{
+		gsettings: scaleGSettingsBackup{
+			ScaleFactor:       1.25,
+			IndividualScaling: "HDMI-1=1.00;eDP-1=1.25",
+			CursorBaseSize:    32,
+		},
+		qtTheme:  []byte("[Theme]\nIconThemeName=bloom\n"),
+		schedule: []byte(`[{"Hour":20,"Minute":0,"Factor":1.5}]`),
+	}))
+	filename := filepath.Join(t.TempDir(), "scale.tar")
+	require.NoError(t, ioutil.WriteFile(filename, buf.Bytes(), 0644))
+
+	m := &XSManager{
+		gs:         gio.NewSettings(xsSchema),
+		startddeGs: gio.NewSettings(startddeSchema),
+	}
+	// 备份中没有当前的主屏
+	m.primaryScreenCache = newPrimaryScreenCache(func() (string, error) {
+		return "DP-2", nil
+	})
+	err := m.importScaleConfig(filename)
+	assert.Error(t, err)
+
+	// 校验失败时什么都不写入
+	_, err = os.Stat(getQtThemeFile())
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(getScaleScheduleFile())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, int32(0), m.startddeGs.GetInt(gsKeyCursorBaseSize))
+}
+
+func Test_importScaleConfig_rollback(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema, startddeSchema, wrapGnomeInterfaceSchema)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	// 环境变量文件写入失败，缩放设置在写入 qt-theme.ini 之后失败
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	require.NoError(t, ioutil.WriteFile(blocker, nil, 0644))
+	getDdeEnvFileOld := getDdeEnvFile
+	gsOld := _gs
+	t.Cleanup(func() {
+		getDdeEnvFile = getDdeEnvFileOld
+		_gs = gsOld
+	})
+	getDdeEnvFile = func() string { return filepath.Join(blocker, "dde-env") }
+
+	var buf bytes.Buffer
+	require.NoError(t, writeScaleBackup(&buf, &scaleBackup{
+		gsettings: scaleGSettingsBackup{
+			ScaleFactor:       1.25,
+			IndividualScaling: "HDMI-1=1.00;eDP-1=1.25",
+			CursorBaseSize:    32,
+		},
+		qtTheme:  []byte("[Theme]\nIconThemeName=bloom\n"),
+		schedule: []byte(`[{"Hour":20,"Minute":0,"Factor":1.5}]`),
+	}))
+	filename := filepath.Join(t.TempDir(), "scale.tar")
+	require.NoError(t, ioutil.WriteFile(filename, buf.Bytes(), 0644))
+
+	m := &XSManager{
+		service:    &fakeSignalEmitter{},
+		gs:         gio.NewSettings(xsSchema),
+		startddeGs: gio.NewSettings(startddeSchema),
+		dsfHelper:  &fakeDsfHelper{},
+		greeterAvailable: func() (bool, error) {
+			return false, nil
+		},
+		connectedOutputs: func() ([]outputInfo, error) {
+			return nil, nil
+		},
+	}
+	_gs = m.gs
+	m.primaryScreenCache = newPrimaryScreenCache(func() (string, error) {
+		return "eDP-1", nil
+	})
+	m.qtThemeWriter = newQtThemeWriter(time.Hour, saveQtTheme, func(qt *qtThemeChange) error {
+		return nil
+	})
+	m.plymouthSettler = newPlymouthSettler(func(factor int, emitSignal bool) {})
+	m.scaleScheduler = newScaleScheduler(func(factor float64) {})
+	m.startddeGs.SetBoolean(gsKeyScaleLocked, false)
+	m.startddeGs.SetString(gsKeyScalingMode, scalingModeIndividual)
+	m.startddeGs.SetInt(gsKeyCursorBaseSize, 24)
+	oldQtTheme := []byte("[Theme]\nIconThemeName=vintage\nScreenScaleFactors=1\n")
+	require.NoError(t, os.MkdirAll(filepath.Dir(getQtThemeFile()), 0755))
+	require.NoError(t, ioutil.WriteFile(getQtThemeFile(), oldQtTheme, 0644))
+
+	err := m.importScaleConfig(filename)
+	assert.Error(t, err)
+
+	// 导入的文件和设置都恢复为原来的值
+	content, err := ioutil.ReadFile(getQtThemeFile())
+	require.NoError(t, err)
+	assert.Equal(t, oldQtTheme, content)
+	_, err = os.Stat(getScaleScheduleFile())
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, m.scaleScheduler.getEntries())
+	assert.Equal(t, int32(24), m.startddeGs.GetInt(gsKeyCursorBaseSize))
+}
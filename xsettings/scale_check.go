@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/linuxdeepin/go-lib/strv"
+	"golang.org/x/sys/unix"
+)
+
+// scaleProbe 缩放相关功能的一项只读检查
+type scaleProbe struct {
+	name  string
+	check func() error
+}
+
+// 依次运行所有检查，返回是否全部通过以及每一项的结果
+func runScaleProbes(probes []scaleProbe) (bool, []string) {
+	ok := true
+	details := make([]string, 0, len(probes))
+	for _, probe := range probes {
+		err := probe.check()
+		if err != nil {
+			ok = false
+			details = append(details, fmt.Sprintf("%s: fail: %v", probe.name, err))
+		} else {
+			details = append(details, fmt.Sprintf("%s: pass", probe.name))
+		}
+	}
+	return ok, details
+}
+
+func (m *XSManager) getScaleProbes() []scaleProbe {
+	return []scaleProbe{
+		{name: "gsettings", check: m.checkScaleGSettings},
+		{name: "qt-theme", check: checkQtThemeFileAccess},
+		{name: "primary-screen", check: func() error {
+			_, err := getPrimaryScreenName(m.conn)
+			return err
+		}},
+		{name: "plymouth-config", check: func() error {
+			_, err := getPlymouthTheme(plymouthConfigFile)
+			return err
+		}},
+		{name: "greeter", check: m.checkGreeterAvailable},
+	}
+}
+
+func (m *XSManager) checkScaleGSettings() error {
+	scale := m.gs.GetDouble(gsKeyScaleFactor)
+	if scale <= 0 {
+		return fmt.Errorf("invalid %s %v", gsKeyScaleFactor, scale)
+	}
+	return nil
+}
+
+// qt-theme.ini 存在时要可读写，不存在时所在目录要可写
+func checkQtThemeFileAccess() error {
+	filename := getQtThemeFile()
+	_, err := os.Stat(filename)
+	if err == nil {
+		return unix.Access(filename, unix.R_OK|unix.W_OK)
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	dir := filepath.Dir(filename)
+	for {
+		_, err = os.Stat(dir)
+		if err == nil {
+			return unix.Access(dir, unix.W_OK)
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+}
+
+// 与同步 greeter 时的判断一致，greeter 服务没有运行也不能被激活时失败
+func (m *XSManager) checkGreeterAvailable() error {
+	if m.greeterAvailable == nil {
+		return errors.New("greeter service check not available")
+	}
+	available, err := m.greeterAvailable()
+	if err != nil {
+		return err
+	}
+	if !available {
+		return errors.New("service not found: " + greeterService)
+	}
+	return nil
+}
+
+// 服务正在运行或者可以被激活
+func systemBusNameAvailable(systemBus *dbus.Conn, name string) (bool, error) {
+	var hasOwner bool
+	err := systemBus.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, name).Store(&hasOwner)
+	if err != nil || hasOwner {
+		return hasOwner, err
+	}
+	var activatable []string
+	err = systemBus.BusObject().Call("org.freedesktop.DBus.ListActivatableNames", 0).Store(&activatable)
+	if err != nil {
+		return false, err
+	}
+	return strv.Strv(activatable).Contains(name), nil
+}
+
+// 检查缩放相关的各个环节，不会修改任何状态
+func (m *XSManager) scaleSelfCheck() (bool, []string) {
+	return runScaleProbes(m.getScaleProbes())
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_runScaleProbes(t *testing.T) {
+	healthy := func() error { return nil }
+	unhealthy := func() error { return errors.New("not available") }
+
+	ok, details := runScaleProbes([]scaleProbe{
+		{name: "gsettings", check: healthy},
+		{name: "greeter", check: healthy},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"gsettings: pass", "greeter: pass"}, details)
+
+	ok, details = runScaleProbes([]scaleProbe{
+		{name: "gsettings", check: healthy},
+		{name: "greeter", check: unhealthy},
+		{name: "plymouth-config", check: healthy},
+	})
+	assert.False(t, ok)
+	assert.Equal(t, []string{
+		"gsettings: pass",
+		"greeter: fail: not available",
+		"plymouth-config: pass",
+	}, details)
+}
+
+func Test_checkQtThemeFileAccess(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	assert.NoError(t, checkQtThemeFileAccess())
+}
+
+func Test_checkGreeterAvailable(t *testing.T) {
+	m := &XSManager{}
+	assert.Error(t, m.checkGreeterAvailable())
+
+	m.greeterAvailable = func() (bool, error) {
+		return true, nil
+	}
+	assert.NoError(t, m.checkGreeterAvailable())
+
+	m.greeterAvailable = func() (bool, error) {
+		return false, nil
+	}
+	assert.Error(t, m.checkGreeterAvailable())
+
+	m.greeterAvailable = func() (bool, error) {
+		return false, errors.New("no system bus")
+	}
+	assert.Error(t, m.checkGreeterAvailable())
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// temporaryCursorSize 临时设置光标大小，到时间后恢复为按缩放比例计算的大小，例如在投影仪上演示时使用。
+type temporaryCursorSize struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	// 是否有临时设置，不需要持有 mu 就能读取：reassertScale 持有 scaleMu 时读取，而 start 持有 mu 时通过 set 获取 scaleMu
+	active atomic.Bool
+	set    func(size int32)
+	derive func() int32
+}
+
+func newTemporaryCursorSize(set func(size int32), derive func() int32) *temporaryCursorSize {
+	return &temporaryCursorSize{
+		set:    set,
+		derive: derive,
+	}
+}
+
+// start 设置光标大小，duration 之后恢复，再次调用会替换之前的设置并重新计时
+func (c *temporaryCursorSize) start(size int32, duration time.Duration) error {
+	if size <= 0 {
+		return errors.New("invalid cursor size")
+	}
+	if duration <= 0 {
+		return errors.New("invalid duration")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.startLocked(size, duration)
+	return nil
+}
+
+// 需要持有 mu
+func (c *temporaryCursorSize) startLocked(size int32, duration time.Duration) {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.active.Store(true)
+	c.set(size)
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// 已经被再次调用的 start 替换或者被 cancel 取消，Stop 之前已经触发的回调不能恢复新的设置
+		if c.timer != timer {
+			return
+		}
+		c.revertLocked()
+	})
+	c.timer = timer
+}
+
+// cancel 立即恢复为按缩放比例计算的大小，没有临时设置时返回 false
+func (c *temporaryCursorSize) cancel() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer == nil {
+		return false
+	}
+	c.timer.Stop()
+	c.revertLocked()
+	return true
+}
+
+// 需要持有 mu
+func (c *temporaryCursorSize) revertLocked() {
+	c.timer = nil
+	c.active.Store(false)
+	size := c.derive()
+	logger.Debug("revert temporary cursor size to", size)
+	c.set(size)
+}
+
+func (c *temporaryCursorSize) isActive() bool {
+	return c.active.Load()
+}
+
+func (m *XSManager) deriveCurrentCursorSize() int32 {
+	return deriveScaleValues(getScaleFactor(), m.getScreenScaleFactors(), m.getScaleConfig()).cursorSize
+}
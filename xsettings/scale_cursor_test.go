@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	gio "github.com/linuxdeepin/go-gir/gio-2.0"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_temporaryCursorSize(t *testing.T) {
+	var mu sync.Mutex
+	var size int32
+	getSize := func() int32 {
+		mu.Lock()
+		defer mu.Unlock()
+		return size
+	}
+	derived := deriveScaleValues(2, singleToMapSF(2), scaleConfig{cursorBaseSize: baseCursorSize}).cursorSize
+	c := newTemporaryCursorSize(func(v int32) {
+		mu.Lock()
+		size = v
+		mu.Unlock()
+	}, func() int32 {
+		return derived
+	})
+
+	assert.Error(t, c.start(0, time.Second))
+	assert.Error(t, c.start(96, 0))
+	assert.False(t, c.cancel())
+
+	// 到时间后自动恢复
+	assert.NoError(t, c.start(96, 50*time.Millisecond))
+	assert.Equal(t, int32(96), getSize())
+	assert.Eventually(t, func() bool {
+		return getSize() == derived
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(48), derived)
+
+	// 取消后立即恢复
+	assert.NoError(t, c.start(128, time.Hour))
+	assert.Equal(t, int32(128), getSize())
+	assert.True(t, c.isActive())
+	assert.True(t, c.cancel())
+	assert.Equal(t, derived, getSize())
+	assert.False(t, c.isActive())
+	assert.False(t, c.cancel())
+
+	// 旧的定时器已经触发、等待锁时被替换，不能恢复新的设置
+	assert.NoError(t, c.start(96, 10*time.Millisecond))
+	c.mu.Lock()
+	time.Sleep(50 * time.Millisecond)
+	c.startLocked(128, time.Hour)
+	c.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(128), getSize())
+	assert.True(t, c.isActive())
+	assert.True(t, c.cancel())
+}
+
+func Test_reassertScale_temporaryCursorSize(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema, startddeSchema, wrapGnomeInterfaceSchema)
+	gsOld := _gs
+	t.Cleanup(func() {
+		_gs = gsOld
+	})
+	m := &XSManager{
+		service:    &fakeSignalEmitter{},
+		gs:         gio.NewSettings(xsSchema),
+		startddeGs: gio.NewSettings(startddeSchema),
+	}
+	_gs = m.gs
+	m.gs.SetDouble(gsKeyScaleFactor, 2)
+	m.gs.SetString(gsKeyIndividualScaling, "ALL=2.00")
+	m.tempCursorSize = newTemporaryCursorSize(func(size int32) {
+		m.scaleMu.Lock()
+		defer m.scaleMu.Unlock()
+		m.setCursorSize(size)
+	}, m.deriveCurrentCursorSize)
+
+	// 临时设置期间不恢复光标大小
+	require.NoError(t, m.tempCursorSize.start(96, time.Hour))
+	m.reassertScale()
+	assert.Equal(t, int32(96), m.gs.GetInt(gsKeyGtkCursorThemeSize))
+	assert.Equal(t, int32(2), m.gs.GetInt(gsKeyWindowScale))
+
+	assert.True(t, m.tempCursorSize.cancel())
+	m.gs.SetInt(gsKeyGtkCursorThemeSize, 24)
+	m.reassertScale()
+	assert.Equal(t, int32(48), m.gs.GetInt(gsKeyGtkCursorThemeSize))
+}
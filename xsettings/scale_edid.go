@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	x "github.com/linuxdeepin/go-x11-client"
+	"github.com/linuxdeepin/go-x11-client/ext/randr"
+)
+
+// individual-scaling 以接口名称作为键，显示器换了接口或者接口名称重新排列后缩放会落到别的显示器上。
+// individual-scaling-edids 记录每个名称对应的显示器的 EDID 摘要，启动时据此把缩放挪回原来的显示器。
+
+// EDID 最长读取 512 字节，包含基本块和 3 个扩展块
+const edidMaxLongLength = 128
+
+func getOutputEdid(xConn *x.Conn, output randr.Output, edidAtom x.Atom) string {
+	reply, err := randr.GetOutputProperty(xConn, output, edidAtom, x.AtomAny,
+		0, edidMaxLongLength, false, false).Reply(xConn)
+	if err != nil {
+		logger.Warning(err)
+		return ""
+	}
+	if len(reply.Value) == 0 {
+		return ""
+	}
+	sum := md5.Sum(reply.Value)
+	return hex.EncodeToString(sum[:])
+}
+
+func getOutputEdids(outputs []outputInfo) map[string]string {
+	result := make(map[string]string)
+	for _, output := range outputs {
+		if output.edid != "" {
+			result[output.name] = output.edid
+		}
+	}
+	return result
+}
+
+func parseScreenEdids(str string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(str, ";") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			continue
+		}
+		result[unescapeScreenName(kv[0])] = kv[1]
+	}
+	return result
+}
+
+func joinScreenEdids(v map[string]string) string {
+	pairs := make([]string, 0, len(v))
+	for name, edid := range v {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", escapeScreenName(name), edid))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
+
+// 把 current 合并进 recorded，显示器换了接口时删除旧名称的记录，断开的显示器保留记录。
+func mergeScreenEdids(recorded, current map[string]string) map[string]string {
+	result := make(map[string]string, len(recorded)+len(current))
+	moved := make(map[string]bool, len(current))
+	for _, edid := range current {
+		moved[edid] = true
+	}
+	for name, edid := range recorded {
+		if moved[edid] {
+			continue
+		}
+		result[name] = edid
+	}
+	for name, edid := range current {
+		result[name] = edid
+	}
+	return result
+}
+
+// reconcileScreenFactorsByEdid 当接口名称现在对应的 EDID 与记录的不同时，
+// 取该 EDID 之前所在接口的缩放。找不到记录的新显示器保持原样。
+func reconcileScreenFactorsByEdid(factors map[string]float64, recorded, current map[string]string) (map[string]float64, bool) {
+	nameByEdid := make(map[string]string, len(recorded))
+	for name, edid := range recorded {
+		nameByEdid[edid] = name
+	}
+
+	result := make(map[string]float64, len(factors))
+	for name, factor := range factors {
+		result[name] = factor
+	}
+	changed := false
+	for name, edid := range current {
+		if recorded[name] == edid {
+			continue
+		}
+		oldName, ok := nameByEdid[edid]
+		if !ok {
+			continue
+		}
+		factor, ok := factors[oldName]
+		if !ok || result[name] == factor {
+			continue
+		}
+		logger.Infof("screen %s was on %s, move scale factor %v", name, oldName, factor)
+		result[name] = factor
+		changed = true
+	}
+	return result, changed
+}
+
+func (m *XSManager) saveScreenEdids(current map[string]string) {
+	recorded := parseScreenEdids(m.startddeGs.GetString(gsKeyScalingEdids))
+	m.startddeGs.SetString(gsKeyScalingEdids, joinScreenEdids(mergeScreenEdids(recorded, current)))
+}
+
+// 启动时调用。首次运行时只记录当前的 EDID，之后按 EDID 纠正各屏幕的缩放。
+func (m *XSManager) reconcileScaleByEdid() {
+	outputs, err := m.getConnectedOutputs()
+	if err != nil {
+		logger.Warning("failed to get connected outputs:", err)
+		return
+	}
+	current := getOutputEdids(outputs)
+	if len(current) == 0 {
+		return
+	}
+	recorded := parseScreenEdids(m.startddeGs.GetString(gsKeyScalingEdids))
+	if len(recorded) == 0 {
+		logger.Info("record screen edids for individual scaling")
+		m.saveScreenEdids(current)
+		return
+	}
+
+	factors, changed := reconcileScreenFactorsByEdid(m.getScreenScaleFactors(), recorded, current)
+	if !changed {
+		m.saveScreenEdids(current)
+		return
+	}
+	err = m.setScreenScaleFactors(factors, false)
+	if err != nil {
+		logger.Warning("failed to reconcile scale factors by edid:", err)
+	}
+}
@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseJoinScreenEdids(t *testing.T) {
+	edids := parseScreenEdids("eDP-1=aaa;HDMI-1=bbb;;bad;DP-1=")
+	assert.Equal(t, map[string]string{"eDP-1": "aaa", "HDMI-1": "bbb"}, edids)
+	assert.Equal(t, "HDMI-1=bbb;eDP-1=aaa", joinScreenEdids(edids))
+}
+
+func Test_mergeScreenEdids(t *testing.T) {
+	recorded := map[string]string{"HDMI-1": "aaa", "DP-1": "bbb"}
+	current := map[string]string{"DP-2": "aaa"}
+	assert.Equal(t, map[string]string{"DP-1": "bbb", "DP-2": "aaa"},
+		mergeScreenEdids(recorded, current))
+}
+
+func Test_reconcileScreenFactorsByEdid(t *testing.T) {
+	tests := []struct {
+		name        string
+		factors     map[string]float64
+		recorded    map[string]string
+		current     map[string]string
+		want        map[string]float64
+		wantChanged bool
+	}{
+		{
+			name:     "unchanged",
+			factors:  map[string]float64{"HDMI-1": 2, "DP-1": 1},
+			recorded: map[string]string{"HDMI-1": "aaa", "DP-1": "bbb"},
+			current:  map[string]string{"HDMI-1": "aaa", "DP-1": "bbb"},
+			want:     map[string]float64{"HDMI-1": 2, "DP-1": 1},
+		},
+		{
+			name:        "name now maps to a different edid",
+			factors:     map[string]float64{"HDMI-1": 2, "DP-1": 1},
+			recorded:    map[string]string{"HDMI-1": "aaa", "DP-1": "bbb"},
+			current:     map[string]string{"HDMI-1": "bbb"},
+			want:        map[string]float64{"HDMI-1": 1, "DP-1": 1},
+			wantChanged: true,
+		},
+		{
+			name:        "swapped",
+			factors:     map[string]float64{"HDMI-1": 2, "DP-1": 1},
+			recorded:    map[string]string{"HDMI-1": "aaa", "DP-1": "bbb"},
+			current:     map[string]string{"HDMI-1": "bbb", "DP-1": "aaa"},
+			want:        map[string]float64{"HDMI-1": 1, "DP-1": 2},
+			wantChanged: true,
+		},
+		{
+			name:        "moved to a new name",
+			factors:     map[string]float64{"HDMI-1": 2},
+			recorded:    map[string]string{"HDMI-1": "aaa"},
+			current:     map[string]string{"HDMI-2": "aaa"},
+			want:        map[string]float64{"HDMI-1": 2, "HDMI-2": 2},
+			wantChanged: true,
+		},
+		{
+			name:     "unknown monitor",
+			factors:  map[string]float64{"HDMI-1": 2},
+			recorded: map[string]string{"HDMI-1": "aaa"},
+			current:  map[string]string{"HDMI-1": "ccc"},
+			want:     map[string]float64{"HDMI-1": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := reconcileScreenFactorsByEdid(tt.factors, tt.recorded, tt.current)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantChanged, changed)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// 用于测试缩放相关的错误处理，只有设置了该环境变量才能开启
+const envScaleFaultInjection = "STARTDDE_SCALE_FAULT_INJECTION"
+
+const (
+	scaleFaultRandr   = "randr"
+	scaleFaultDBus    = "dbus"
+	scaleFaultQtWrite = "qt-write"
+)
+
+var errScaleFaultInjected = errors.New("fault injected")
+
+type scaleFaultInjector struct {
+	mu      sync.RWMutex
+	enabled bool
+	faults  map[string]bool
+}
+
+var _scaleFaults = &scaleFaultInjector{
+	enabled: os.Getenv(envScaleFaultInjection) == "1",
+}
+
+func (fi *scaleFaultInjector) set(faults map[string]bool) error {
+	if !fi.enabled {
+		return fmt.Errorf("fault injection is disabled, set %s=1 to enable", envScaleFaultInjection)
+	}
+	for name := range faults {
+		switch name {
+		case scaleFaultRandr, scaleFaultDBus, scaleFaultQtWrite:
+		default:
+			return fmt.Errorf("unknown fault %q", name)
+		}
+	}
+
+	fi.mu.Lock()
+	fi.faults = make(map[string]bool, len(faults))
+	for name, v := range faults {
+		fi.faults[name] = v
+	}
+	fi.mu.Unlock()
+	logger.Warning("scale fault injection:", faults)
+	return nil
+}
+
+// 注入了对应的故障时返回错误
+func (fi *scaleFaultInjector) check(name string) error {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	if fi.faults[name] {
+		return fmt.Errorf("%s: %w", name, errScaleFaultInjected)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withScaleFaults(t *testing.T, faults map[string]bool) {
+	old := _scaleFaults
+	_scaleFaults = &scaleFaultInjector{enabled: true}
+	t.Cleanup(func() {
+		_scaleFaults = old
+	})
+	require.NoError(t, _scaleFaults.set(faults))
+}
+
+func Test_scaleFaultInjector(t *testing.T) {
+	fi := &scaleFaultInjector{}
+	assert.Error(t, fi.set(map[string]bool{scaleFaultRandr: true}))
+	assert.NoError(t, fi.check(scaleFaultRandr))
+
+	fi.enabled = true
+	assert.Error(t, fi.set(map[string]bool{"edid": true}))
+	assert.NoError(t, fi.set(map[string]bool{scaleFaultRandr: true, scaleFaultDBus: false}))
+	assert.True(t, errors.Is(fi.check(scaleFaultRandr), errScaleFaultInjected))
+	assert.NoError(t, fi.check(scaleFaultDBus))
+}
+
+func Test_getPrimaryScreenName_faults(t *testing.T) {
+	// randr 和 DBus 都失败时返回错误，不会使用 X 连接
+	withScaleFaults(t, map[string]bool{scaleFaultRandr: true, scaleFaultDBus: true})
+	_, err := getPrimaryScreenName(nil)
+	assert.True(t, errors.Is(err, errScaleFaultInjected))
+}
+
+func Test_qtThemeChange_save_fault(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	qtThemeFile := getQtThemeFile()
+	require.NoError(t, os.MkdirAll(filepath.Dir(qtThemeFile), 0755))
+	oldContent := []byte("[Theme]\nScreenScaleFactors=1.00\n")
+	require.NoError(t, ioutil.WriteFile(qtThemeFile, oldContent, 0644))
+
+	withScaleFaults(t, map[string]bool{scaleFaultQtWrite: true})
+	qt, err := prepareQtTheme(map[string]float64{"ALL": 2}, scaleConfig{})
+	require.NoError(t, err)
+	err = qt.save()
+	assert.True(t, errors.Is(err, errScaleFaultInjected))
+
+	content, err := ioutil.ReadFile(qtThemeFile)
+	require.NoError(t, err)
+	assert.Equal(t, oldContent, content)
+
+	// 同时 gsettings 也会 revert
+	var calls []string
+	err = applyScaleBarrier(qt.save, func() { calls = append(calls, "apply") },
+		func() { calls = append(calls, "revert") })
+	assert.Error(t, err)
+	assert.Equal(t, []string{"revert"}, calls)
+}
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"sync"
+
+	"github.com/linuxdeepin/go-x11-client/ext/randr"
+)
+
+// screenFactorsCache 缓存已连接的各屏幕实际使用的缩放比例，
+// individual-scaling 改变或者屏幕插拔后失效，下次读取时重新计算。
+type screenFactorsCache struct {
+	mu      sync.Mutex
+	valid   bool
+	factors map[string]float64
+	load    func() (map[string]float64, error)
+}
+
+func newScreenFactorsCache(load func() (map[string]float64, error)) *screenFactorsCache {
+	return &screenFactorsCache{
+		load: load,
+	}
+}
+
+func (c *screenFactorsCache) get() (map[string]float64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.valid {
+		factors, err := c.load()
+		if err != nil {
+			return nil, err
+		}
+		c.factors = factors
+		c.valid = true
+	}
+	result := make(map[string]float64, len(c.factors))
+	for name, factor := range c.factors {
+		result[name] = factor
+	}
+	return result, nil
+}
+
+func (c *screenFactorsCache) invalidate() {
+	c.mu.Lock()
+	c.valid = false
+	c.factors = nil
+	c.mu.Unlock()
+}
+
+// primaryScreenCache 缓存主屏的名称，randr 屏幕或输出改变后失效，下次读取时重新获取。
+// randr 事件在单独的 goroutine 中处理，需要加锁。
+type primaryScreenCache struct {
+	mu      sync.Mutex
+	valid   bool
+	name    string
+	resolve func() (string, error)
+}
+
+func newPrimaryScreenCache(resolve func() (string, error)) *primaryScreenCache {
+	return &primaryScreenCache{
+		resolve: resolve,
+	}
+}
+
+func (c *primaryScreenCache) get() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.valid {
+		name, err := c.resolve()
+		if err != nil {
+			return "", err
+		}
+		c.name = name
+		c.valid = true
+	}
+	return c.name, nil
+}
+
+func (c *primaryScreenCache) invalidate() {
+	c.mu.Lock()
+	c.valid = false
+	c.mu.Unlock()
+}
+
+func (m *XSManager) getPrimaryScreenName() (string, error) {
+	if m.primaryScreenCache == nil {
+		return getPrimaryScreenName(m.conn)
+	}
+	return m.primaryScreenCache.get()
+}
+
+func resolveScreenFactors(factors map[string]float64, connected []string) map[string]float64 {
+	result := make(map[string]float64, len(connected))
+	for _, name := range connected {
+		result[name] = resolveScreenFactor(factors, name)
+	}
+	return result
+}
+
+func (m *XSManager) loadScreenFactors() (map[string]float64, error) {
+	connected, err := m.getConnectedOutputNames()
+	if err != nil {
+		return nil, err
+	}
+	return resolveScreenFactors(m.getScreenScaleFactors(), connected), nil
+}
+
+// 监听屏幕的插拔和主屏的改变。与 display 模块共用 X 连接，randr 的事件选择对同一个连接的同一个窗口只保留最后一次，
+// 所以这里选择与 display 模块相同的事件。
+func (m *XSManager) listenOutputChanges() {
+	eventChan := m.conn.MakeAndAddEventChan(50)
+	root := m.conn.GetDefaultScreen().Root
+	err := randr.SelectInputChecked(m.conn, root,
+		randr.NotifyMaskOutputChange|randr.NotifyMaskOutputProperty|
+			randr.NotifyMaskCrtcChange|randr.NotifyMaskScreenChange).Check(m.conn)
+	if err != nil {
+		logger.Warning("failed to select randr event:", err)
+		return
+	}
+	rrExtData := m.conn.GetExtensionData(randr.Ext())
+
+	go func() {
+		for ev := range eventChan {
+			switch ev.GetEventCode() {
+			case randr.NotifyEventCode + rrExtData.FirstEvent:
+				event, _ := randr.NewNotifyEvent(ev)
+				if event.SubCode == randr.NotifyOutputChange {
+					e, _ := event.NewOutputChangeNotifyEvent()
+					m.handleOutputChanged(e.Connection == randr.ConnectionConnected)
+				}
+			case randr.ScreenChangeNotifyEventCode + rrExtData.FirstEvent:
+				m.primaryScreenCache.invalidate()
+			}
+		}
+	}()
+}
+
+func (m *XSManager) handleOutputChanged(connected bool) {
+	m.screenFactorsCache.invalidate()
+	m.primaryScreenCache.invalidate()
+	if connected {
+		go m.fillNewScreenFactors()
+	}
+}
+
+// outputTracker 记录已经处理过的已连接屏幕，用于找出新连接的屏幕
+type outputTracker struct {
+	mu    sync.Mutex
+	known map[string]bool
+}
+
+func newOutputTracker(connected []string) *outputTracker {
+	t := &outputTracker{}
+	t.update(connected)
+	return t
+}
+
+// update 记录当前已连接的屏幕，返回之前没有记录的屏幕。断开的屏幕不再记录，重新连接时也作为新的屏幕。
+func (t *outputTracker) update(connected []string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var newOutputs []string
+	known := make(map[string]bool, len(connected))
+	for _, name := range connected {
+		if !t.known[name] {
+			newOutputs = append(newOutputs, name)
+		}
+		known[name] = true
+	}
+	t.known = known
+	return newOutputs
+}
+
+// forget 删除记录，下次 update 时作为新的屏幕返回
+func (t *outputTracker) forget(names []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, name := range names {
+		delete(t.known, name)
+	}
+}
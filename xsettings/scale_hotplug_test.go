@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_screenFactorsCache(t *testing.T) {
+	connected := []string{"eDP-1", "HDMI-1"}
+	factors := map[string]float64{"eDP-1": 2, "ALL": 1}
+	loads := 0
+	var loadErr error
+	cache := newScreenFactorsCache(func() (map[string]float64, error) {
+		loads++
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return resolveScreenFactors(factors, connected), nil
+	})
+
+	got, err := cache.get()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"eDP-1": 2, "HDMI-1": 1}, got)
+	assert.Equal(t, 1, loads)
+
+	// 使用缓存，返回的 map 可以修改
+	got["eDP-1"] = 3
+	got, err = cache.get()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"eDP-1": 2, "HDMI-1": 1}, got)
+	assert.Equal(t, 1, loads)
+
+	// 插入新屏幕
+	connected = append(connected, "DP-1")
+	cache.invalidate()
+	got, err = cache.get()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"eDP-1": 2, "HDMI-1": 1, "DP-1": 1}, got)
+	assert.Equal(t, 2, loads)
+
+	// 加载失败时不缓存
+	cache.invalidate()
+	loadErr = errors.New("randr error")
+	_, err = cache.get()
+	assert.Error(t, err)
+	loadErr = nil
+	_, err = cache.get()
+	require.NoError(t, err)
+	assert.Equal(t, 4, loads)
+}
+
+func Test_primaryScreenCache(t *testing.T) {
+	calls := 0
+	primary := "eDP-1"
+	cache := newPrimaryScreenCache(func() (string, error) {
+		calls++
+		return primary, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		name, err := cache.get()
+		require.NoError(t, err)
+		assert.Equal(t, "eDP-1", name)
+	}
+	assert.Equal(t, 1, calls)
+
+	primary = "HDMI-1"
+	cache.invalidate()
+	name, err := cache.get()
+	require.NoError(t, err)
+	assert.Equal(t, "HDMI-1", name)
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journald 原生协议的套接字，测试时替换
+var journalSocket = "/run/systemd/journal/socket"
+
+// scaleLogEntry 一次缩放修改的结构化日志
+type scaleLogEntry struct {
+	factors     string
+	windowScale int32
+	cursorSize  int32
+	primary     string
+	duration    time.Duration
+	err         error
+}
+
+type journalField struct {
+	key   string
+	value string
+}
+
+func (e *scaleLogEntry) fields() []journalField {
+	result := "ok"
+	if e.err != nil {
+		result = e.err.Error()
+	}
+	return []journalField{
+		{"FACTOR", e.factors},
+		{"WINDOW_SCALE", strconv.Itoa(int(e.windowScale))},
+		{"CURSOR_SIZE", strconv.Itoa(int(e.cursorSize))},
+		{"PRIMARY", e.primary},
+		{"DURATION", e.duration.String()},
+		{"RESULT", result},
+	}
+}
+
+// 按 journald 字段的命名习惯输出大写的 KEY=value，含有空白等字符的值加上引号
+func (e *scaleLogEntry) String() string {
+	var sb strings.Builder
+	for i, field := range e.fields() {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(field.key)
+		sb.WriteByte('=')
+		value := field.value
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		sb.WriteString(value)
+	}
+	return sb.String()
+}
+
+func (m *XSManager) logScaleChange(factors map[string]float64, c *scaleChange, duration time.Duration, err error) {
+	entry := &scaleLogEntry{
+		factors:  joinScreenScaleFactors(factors),
+		duration: duration,
+		err:      err,
+	}
+	if c != nil {
+		entry.factors = c.factorsJoined
+		entry.windowScale = c.windowScale
+		entry.cursorSize = c.cursorSize
+	}
+	primary, primaryErr := m.getPrimaryScreenName()
+	if primaryErr == nil {
+		entry.primary = primary
+	}
+	journalErr := sendScaleLogToJournal(entry)
+	if journalErr != nil {
+		logger.Debug("failed to send scale log to journal:", journalErr)
+		logger.Info("scale change:", entry)
+	}
+}
+
+// 通过 journald 原生协议发送，各字段可以用 journalctl FACTOR=... 等方式过滤
+func sendScaleLogToJournal(e *scaleLogEntry) error {
+	priority := "6" // LOG_INFO
+	if e.err != nil {
+		priority = "4" // LOG_WARNING
+	}
+	fields := append([]journalField{
+		{"MESSAGE", "scale change: " + e.String()},
+		{"PRIORITY", priority},
+		{"SYSLOG_IDENTIFIER", "startdde"},
+	}, e.fields()...)
+	return sendJournal(fields)
+}
+
+func sendJournal(fields []journalField) error {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(encodeJournalFields(fields))
+	return err
+}
+
+// 每个字段一行 KEY=value，值中有换行时使用 KEY\n<64 位小端长度><value>\n 的格式
+func encodeJournalFields(fields []journalField) []byte {
+	var buf bytes.Buffer
+	for _, field := range fields {
+		buf.WriteString(field.key)
+		if !strings.Contains(field.value, "\n") {
+			buf.WriteByte('=')
+			buf.WriteString(field.value)
+			buf.WriteByte('\n')
+			continue
+		}
+		buf.WriteByte('\n')
+		_ = binary.Write(&buf, binary.LittleEndian, uint64(len(field.value)))
+		buf.WriteString(field.value)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_scaleLogEntry(t *testing.T) {
+	entry := &scaleLogEntry{
+		factors:     "HDMI-1=1.00;eDP-1=2.00",
+		windowScale: 2,
+		cursorSize:  48,
+		primary:     "eDP-1",
+		duration:    15 * time.Millisecond,
+	}
+	assert.Equal(t, `FACTOR="HDMI-1=1.00;eDP-1=2.00" WINDOW_SCALE=2 CURSOR_SIZE=48 `+
+		`PRIMARY=eDP-1 DURATION=15ms RESULT=ok`, entry.String())
+
+	entry = &scaleLogEntry{
+		factors: "ALL=0.00",
+		err:     errors.New("invalid value"),
+	}
+	assert.Equal(t, `FACTOR="ALL=0.00" WINDOW_SCALE=0 CURSOR_SIZE=0 `+
+		`PRIMARY="" DURATION=0s RESULT="invalid value"`, entry.String())
+}
+
+func Test_encodeJournalFields(t *testing.T) {
+	data := encodeJournalFields([]journalField{
+		{"FACTOR", "ALL=1.25"},
+		{"RESULT", "a\nb"},
+	})
+	assert.Equal(t, "FACTOR=ALL=1.25\nRESULT\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n", string(data))
+}
+
+func Test_sendScaleLogToJournal(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	oldJournalSocket := journalSocket
+	journalSocket = socket
+	defer func() {
+		journalSocket = oldJournalSocket
+	}()
+
+	err = sendScaleLogToJournal(&scaleLogEntry{
+		factors:     "ALL=1.25",
+		windowScale: 1,
+		cursorSize:  30,
+		primary:     "eDP-1",
+		err:         errors.New("failed"),
+	})
+	require.NoError(t, err)
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	got := string(buf[:n])
+	assert.Contains(t, got, "PRIORITY=4\n")
+	assert.Contains(t, got, "SYSLOG_IDENTIFIER=startdde\n")
+	assert.Contains(t, got, "FACTOR=ALL=1.25\n")
+	assert.Contains(t, got, "CURSOR_SIZE=30\n")
+	assert.Contains(t, got, "RESULT=failed\n")
+
+	// 套接字不存在时返回错误，由调用者回退到 logger
+	journalSocket = filepath.Join(t.TempDir(), "none")
+	assert.Error(t, sendScaleLogToJournal(&scaleLogEntry{}))
+}
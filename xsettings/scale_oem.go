@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// OEM 镜像可以为特定的屏幕提供推荐的缩放比例
+const oemScaleRecommendationsFile = "/usr/share/deepin/scale-recommendations.conf"
+
+// loadOemScaleRecommendations 读取 OEM 的推荐缩放比例，返回 EDID 摘要到缩放比例的映射。
+// 每行格式为 <EDID 摘要>=<缩放比例>，忽略空行和 # 开头的注释。
+func loadOemScaleRecommendations(filename string) (map[string]float64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := make(map[string]float64)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			logger.Warningf("%s:%d: invalid line %q", filename, lineNum, line)
+			continue
+		}
+		edid := strings.ToLower(strings.TrimSpace(kv[0]))
+		factor, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if edid == "" || err != nil || factor <= 0 {
+			logger.Warningf("%s:%d: invalid line %q", filename, lineNum, line)
+			continue
+		}
+		result[edid] = factor
+	}
+	return result, scanner.Err()
+}
+
+// 查找主屏幕对应的推荐缩放比例
+func findOemScaleRecommendation(recommendations map[string]float64, outputs []outputInfo, primary string) (float64, bool) {
+	for _, output := range outputs {
+		if output.name != primary || output.edid == "" {
+			continue
+		}
+		factor, ok := recommendations[output.edid]
+		return factor, ok
+	}
+	return 0, false
+}
+
+// 主屏幕有 OEM 推荐的缩放比例时应用该值，返回是否应用
+func (m *XSManager) applyOemScaleRecommendation() (bool, error) {
+	recommendations, err := loadOemScaleRecommendations(oemScaleRecommendationsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	outputs, err := m.getConnectedOutputs()
+	if err != nil {
+		return false, err
+	}
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		return false, fmt.Errorf("failed to get primary screen: %v", err)
+	}
+	factor, ok := findOemScaleRecommendation(recommendations, outputs, primary)
+	if !ok {
+		logger.Debug("no oem scale recommendation for primary screen", primary)
+		return false, nil
+	}
+	logger.Infof("apply oem scale recommendation %v for %s", factor, primary)
+	err = m.setScaleFactorForOutput(primary, factor)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
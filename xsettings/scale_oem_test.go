@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadOemScaleRecommendations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "scale-recommendations.conf")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`# vendor panels
+0123456789ABCDEF0123456789abcdef = 1.25
+fedcba9876543210fedcba9876543210=2
+
+invalid
+00000000000000000000000000000000=abc
+11111111111111111111111111111111=-1
+`), 0644))
+
+	recommendations, err := loadOemScaleRecommendations(filename)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"0123456789abcdef0123456789abcdef": 1.25,
+		"fedcba9876543210fedcba9876543210": 2,
+	}, recommendations)
+
+	_, err = loadOemScaleRecommendations(filepath.Join(t.TempDir(), "none.conf"))
+	assert.Error(t, err)
+}
+
+func Test_findOemScaleRecommendation(t *testing.T) {
+	recommendations := map[string]float64{
+		"0123456789abcdef0123456789abcdef": 1.25,
+	}
+	outputs := []outputInfo{
+		{name: "eDP-1", edid: "0123456789abcdef0123456789abcdef"},
+		{name: "HDMI-1", edid: "fedcba9876543210fedcba9876543210"},
+		{name: "DP-1"},
+	}
+
+	factor, ok := findOemScaleRecommendation(recommendations, outputs, "eDP-1")
+	assert.True(t, ok)
+	assert.Equal(t, 1.25, factor)
+
+	_, ok = findOemScaleRecommendation(recommendations, outputs, "HDMI-1")
+	assert.False(t, ok)
+	_, ok = findOemScaleRecommendation(recommendations, outputs, "DP-1")
+	assert.False(t, ok)
+	_, ok = findOemScaleRecommendation(recommendations, outputs, "VGA-1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,795 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/linuxdeepin/go-lib/gettext"
+	"github.com/linuxdeepin/go-lib/strv"
+	x "github.com/linuxdeepin/go-x11-client"
+	"github.com/linuxdeepin/go-x11-client/ext/randr"
+)
+
+// outputInfo 已连接的显示输出设备的信息
+type outputInfo struct {
+	name              string
+	mmWidth, mmHeight uint32
+	// 当前模式的分辨率，已经按照旋转调整过，没有开启的为 0
+	width, height uint16
+	// crtc 的旋转方向，randr.Rotation*
+	rotation uint16
+	// EDID 的摘要，读取不到时为空
+	edid string
+}
+
+const (
+	mmPerInch = 25.4
+	// DDE 默认的字体大小，单位为点
+	defaultFontPointSize = 10.5
+	pointsPerInch        = 72
+)
+
+// 获取与当前分辨率方向一致的物理尺寸。
+// randr 报告的物理尺寸不随旋转变化，而 crtc 的分辨率在旋转 90 或 270 度时宽高互换。
+func (o *outputInfo) getPhysicalSize() (mmWidth, mmHeight uint32) {
+	if o.rotation&(randr.RotationRotate90|randr.RotationRotate270) != 0 {
+		return o.mmHeight, o.mmWidth
+	}
+	return o.mmWidth, o.mmHeight
+}
+
+// 获取横向和纵向的 DPI，无法计算时返回 0
+func (o *outputInfo) getDpi() (dpiX, dpiY float64) {
+	mmWidth, mmHeight := o.getPhysicalSize()
+	if mmWidth == 0 || mmHeight == 0 || o.width == 0 || o.height == 0 {
+		return 0, 0
+	}
+	dpiX = float64(o.width) / (float64(mmWidth) / mmPerInch)
+	dpiY = float64(o.height) / (float64(mmHeight) / mmPerInch)
+	return
+}
+
+// 根据 DPI 推荐缩放比例，按 0.25 取整，限制在 [minFactor, maxFactor] 内
+func (o *outputInfo) getRecommendedScaleFactor(minFactor, maxFactor float64) float64 {
+	mmWidth, mmHeight := o.getPhysicalSize()
+	return recommendScaleForOutput(mmWidth, mmHeight, int(o.width), int(o.height), minFactor, maxFactor)
+}
+
+// recommendScaleForOutput 根据屏幕的物理大小和分辨率推荐缩放比例，无法计算时返回 1
+func recommendScaleForOutput(widthMm, heightMm uint32, widthPx, heightPx int, minFactor, maxFactor float64) float64 {
+	if widthMm == 0 || heightMm == 0 || widthPx <= 0 || heightPx <= 0 {
+		return 1
+	}
+	dpiX := float64(widthPx) / (float64(widthMm) / mmPerInch)
+	dpiY := float64(heightPx) / (float64(heightMm) / mmPerInch)
+	scale := snapScaleFactor((dpiX+dpiY)/2/DPI_FALLBACK, scaleFactorStep)
+	return math.Max(minFactor, math.Min(maxFactor, scale))
+}
+
+// fillMissingScreenFactors 为新连接的屏幕 newOutputs 填入推荐值，推荐值按 cfg 取整并限制范围。
+// 已经单独设置的或者由 ALL 决定的屏幕不变；填入之后，之前已连接而没有单独设置的屏幕固定为原来实际使用的值，
+// 避免 factors 从一项变成多项后它们的缩放改变。
+// 还没有分配 crtc 的屏幕无法计算 DPI，在 pending 中返回，等下次改变时再处理。
+// factors 为空时所有屏幕都使用 scale-factor，不做处理。
+func fillMissingScreenFactors(factors map[string]float64, outputs []outputInfo, newOutputs []string,
+	cfg scaleConfig) (result map[string]float64, filled, pending []string) {
+	if len(factors) == 0 || len(newOutputs) == 0 {
+		return factors, nil, nil
+	}
+	recommended := make(map[string]float64)
+	for i := range outputs {
+		output := &outputs[i]
+		if !strv.Strv(newOutputs).Contains(output.name) {
+			continue
+		}
+		_, source := resolveScreenFactorSource(factors, output.name)
+		if source == screenFactorSourceExplicit || source == screenFactorSourceAll {
+			continue
+		}
+		if dpiX, _ := output.getDpi(); dpiX == 0 {
+			pending = append(pending, output.name)
+			continue
+		}
+		recommended[output.name] = output.getRecommendedScaleFactor(cfg.minFactor, cfg.maxFactor)
+	}
+	if len(recommended) == 0 {
+		return factors, nil, pending
+	}
+	// 与设置缩放时相同的取整和范围
+	if cfg.snapStep > 0 {
+		recommended = snapScreenFactors(recommended, cfg.snapStep, "")
+	}
+	recommended = clampScreenFactors(recommended, cfg)
+
+	result = make(map[string]float64, len(factors)+len(outputs))
+	for name, factor := range factors {
+		result[name] = factor
+	}
+	for i := range outputs {
+		name := outputs[i].name
+		if factor, ok := recommended[name]; ok {
+			result[name] = factor
+			filled = append(filled, name)
+			continue
+		}
+		if _, ok := factors[name]; !ok && !strv.Strv(newOutputs).Contains(name) {
+			result[name] = resolveScreenFactor(factors, name)
+		}
+	}
+	return result, filled, pending
+}
+
+func (m *XSManager) fillNewScreenFactors() {
+	outputs, err := m.getConnectedOutputs()
+	if err != nil {
+		logger.Warning("failed to get connected outputs:", err)
+		return
+	}
+	connected := make([]string, len(outputs))
+	for i := range outputs {
+		connected[i] = outputs[i].name
+	}
+	newOutputs := m.knownOutputs.update(connected)
+	if len(newOutputs) == 0 {
+		return
+	}
+	m.flushScaleApplier()
+	factors, filled, pending := fillMissingScreenFactors(m.getScreenScaleFactors(), outputs, newOutputs,
+		m.getScaleConfig())
+	// 下次改变时仍然作为新连接的屏幕处理
+	m.knownOutputs.forget(pending)
+	if len(filled) == 0 {
+		return
+	}
+	logger.Info("set recommended scale factors for new screens:", filled, factors)
+	err = m.setScreenScaleFactors(factors, true)
+	if err != nil {
+		logger.Warning("failed to set scale factors for new screens:", err)
+	}
+}
+
+// 计算使逻辑 DPI 达到 targetDpi 的缩放比例，保留两位小数
+func (o *outputInfo) getScaleFactorForDpi(targetDpi, minFactor, maxFactor float64) (float64, error) {
+	scale, err := o.getUnclampedScaleFactorForDpi(targetDpi)
+	if err != nil {
+		return 0, err
+	}
+	return math.Max(minFactor, math.Min(maxFactor, scale)), nil
+}
+
+func (o *outputInfo) getUnclampedScaleFactorForDpi(targetDpi float64) (float64, error) {
+	if targetDpi <= 0 {
+		return 0, fmt.Errorf("invalid target dpi %v", targetDpi)
+	}
+	dpiX, dpiY := o.getDpi()
+	if dpiX == 0 || dpiY == 0 {
+		return 0, fmt.Errorf("unknown dpi of output %q", o.name)
+	}
+	scale := (dpiX + dpiY) / 2 / targetDpi
+	return math.Round(scale*100) / 100, nil
+}
+
+func (m *XSManager) getConnectedOutputs() ([]outputInfo, error) {
+	if m.connectedOutputs != nil {
+		return m.connectedOutputs()
+	}
+	return getConnectedOutputs(m.conn)
+}
+
+func getConnectedOutputs(xConn *x.Conn) ([]outputInfo, error) {
+	rootWin := xConn.GetDefaultScreen().Root
+	resources, err := randr.GetScreenResourcesCurrent(xConn, rootWin).Reply(xConn)
+	if err != nil {
+		return nil, err
+	}
+	cfgTs := resources.ConfigTimestamp
+	edidAtom, err := xConn.GetAtom("EDID")
+	if err != nil {
+		logger.Warning(err)
+	}
+
+	var result []outputInfo
+	for _, output := range resources.Outputs {
+		reply, err := randr.GetOutputInfo(xConn, output, cfgTs).Reply(xConn)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Connection != randr.ConnectionConnected {
+			continue
+		}
+
+		info := outputInfo{
+			name:     reply.Name,
+			mmWidth:  reply.MmWidth,
+			mmHeight: reply.MmHeight,
+		}
+		if reply.Crtc != 0 {
+			crtcInfo, err := randr.GetCrtcInfo(xConn, reply.Crtc, cfgTs).Reply(xConn)
+			if err != nil {
+				return nil, err
+			}
+			info.width = crtcInfo.Width
+			info.height = crtcInfo.Height
+			info.rotation = crtcInfo.Rotation
+		}
+		if edidAtom != x.AtomNone {
+			info.edid = getOutputEdid(xConn, output, edidAtom)
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func (m *XSManager) getConnectedOutputNames() ([]string, error) {
+	outputs, err := m.getConnectedOutputs()
+	if err != nil {
+		return nil, err
+	}
+	return getOutputNames(outputs), nil
+}
+
+func getOutputNames(outputs []outputInfo) []string {
+	names := make([]string, len(outputs))
+	for i, output := range outputs {
+		names[i] = output.name
+	}
+	return names
+}
+
+// 界面中显示的缩放比例，例如 "125%"，与推荐值相同时加上 "(Recommended)"。
+// recommended 为 0 时表示没有推荐值。
+func getScaleFactorLabel(factor, recommended float64) string {
+	label := fmt.Sprintf("%d%%", int(math.Round(factor*100)))
+	if recommended > 0 && math.Abs(factor-recommended) < 0.001 {
+		label += " (" + gettext.Tr("Recommended") + ")"
+	}
+	return label
+}
+
+// 缩放比例不是整数时，X11 下只支持整数窗口缩放的程序会先按窗口缩放绘制再缩放到目标大小，看起来会模糊
+func getScaleBlurInfo(factor float64) (bool, string) {
+	windowScale := deriveWindowScale(factor)
+	if float64(windowScale) == factor {
+		return false, fmt.Sprintf(gettext.Tr("Scale factor %s is an integer, apps will be rendered sharply"),
+			formatScaleFactor(factor))
+	}
+	return true, fmt.Sprintf(gettext.Tr("Scale factor %s is fractional, apps that only support integer scaling are drawn at %dx and may look blurry"),
+		formatScaleFactor(factor), windowScale)
+}
+
+func (m *XSManager) wouldPrimaryBlur() (bool, string, error) {
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		return false, "", err
+	}
+	blur, reason := getScaleBlurInfo(resolveScreenFactor(m.getScreenScaleFactors(), primary))
+	return blur, reason, nil
+}
+
+// 获取主屏的推荐缩放比例，无法获取时返回 0
+func (m *XSManager) getPrimaryRecommendedScaleFactor() float64 {
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		logger.Warning("failed to get primary screen name:", err)
+		return 0
+	}
+	outputs, err := m.getConnectedOutputs()
+	if err != nil {
+		logger.Warning("failed to get connected outputs:", err)
+		return 0
+	}
+	output, err := findOutputInfo(outputs, primary)
+	if err != nil {
+		logger.Warning(err)
+		return 0
+	}
+	cfg := m.getScaleConfig()
+	return output.getRecommendedScaleFactor(cfg.minFactor, cfg.maxFactor)
+}
+
+// 计算使默认大小的文字在屏幕上的实际高度为 mm 毫米的缩放比例
+func (o *outputInfo) getScaleFactorForTextHeight(mm, minFactor, maxFactor float64) (float64, error) {
+	if mm <= 0 {
+		return 0, fmt.Errorf("invalid text height %v", mm)
+	}
+	// 缩放为 1 时文字的像素高度，换算成高度为 mm 时需要的 DPI
+	pixels := defaultFontPointSize / pointsPerInch * DPI_FALLBACK
+	return o.getScaleFactorForDpi(pixels/(mm/mmPerInch), minFactor, maxFactor)
+}
+
+func (m *XSManager) scaleFactorForTextHeightMm(screen string, mm float64) (float64, error) {
+	outputs, err := m.getConnectedOutputs()
+	if err != nil {
+		return 0, err
+	}
+	output, err := findOutputInfo(outputs, screen)
+	if err != nil {
+		return 0, err
+	}
+	cfg := m.getScaleConfig()
+	return output.getScaleFactorForTextHeight(mm, cfg.minFactor, cfg.maxFactor)
+}
+
+// screenDpisToFactors 把各屏幕的目标 DPI 转换为缩放比例，超出 [min, max] 的返回错误
+func screenDpisToFactors(dpis map[string]float64, outputs []outputInfo, min, max float64) (map[string]float64, error) {
+	if len(dpis) == 0 {
+		return nil, errors.New("dpis is empty")
+	}
+	factors := make(map[string]float64, len(dpis))
+	for name, dpi := range dpis {
+		output, err := findOutputInfo(outputs, name)
+		if err != nil {
+			return nil, err
+		}
+		factor, err := output.getUnclampedScaleFactorForDpi(dpi)
+		if err != nil {
+			return nil, err
+		}
+		if factor < min || factor > max {
+			return nil, fmt.Errorf("scale factor %v for dpi %v of %s out of range [%v, %v]",
+				factor, dpi, name, min, max)
+		}
+		factors[name] = factor
+	}
+	return factors, nil
+}
+
+func (m *XSManager) setScreenDpis(dpis map[string]float64) error {
+	outputs, err := m.getConnectedOutputs()
+	if err != nil {
+		return err
+	}
+	cfg := m.getScaleConfig()
+	factors, err := screenDpisToFactors(dpis, outputs, cfg.minFactor, cfg.maxFactor)
+	if err != nil {
+		return err
+	}
+	return m.setScreenScaleFactors(factors, true)
+}
+
+// 限制屏幕的有效 DPI（物理 DPI 乘以缩放比例）不超过 maxDpi，超过的屏幕降低缩放比例，
+// 避免在很密的屏幕上界面过大。缩放比例最低降到 minFactor。
+func capScreenFactorsByDpi(factors map[string]float64, outputs []outputInfo, maxDpi, minFactor float64) map[string]float64 {
+	result := make(map[string]float64, len(factors))
+	for name, factor := range factors {
+		result[name] = factor
+	}
+	for i := range outputs {
+		output := &outputs[i]
+		dpiX, dpiY := output.getDpi()
+		if dpiX == 0 || dpiY == 0 {
+			continue
+		}
+		dpi := (dpiX + dpiY) / 2
+		factor := resolveScreenFactor(factors, output.name)
+		if factor*dpi <= maxDpi {
+			continue
+		}
+		capped := math.Max(minFactor, math.Floor(maxDpi/dpi*100)/100)
+		if capped >= factor {
+			continue
+		}
+		logger.Infof("reduce scale factor of %s from %v to %v, max effective dpi %v",
+			output.name, factor, capped, maxDpi)
+		result[output.name] = capped
+	}
+	return result
+}
+
+func findOutputInfo(outputs []outputInfo, name string) (*outputInfo, error) {
+	for i := range outputs {
+		if outputs[i].name == name {
+			return &outputs[i], nil
+		}
+	}
+	return nil, errors.New("not found connected output " + name)
+}
+
+func (m *XSManager) scaleFactorForTargetDpi(screen string, targetDpi float64) (float64, error) {
+	outputs, err := m.getConnectedOutputs()
+	if err != nil {
+		return 0, err
+	}
+	output, err := findOutputInfo(outputs, screen)
+	if err != nil {
+		return 0, err
+	}
+	cfg := m.getScaleConfig()
+	return output.getScaleFactorForDpi(targetDpi, cfg.minFactor, cfg.maxFactor)
+}
+
+// 找出只有大小写不同的重复屏幕名，返回排序后的小写名称
+func findDuplicateScreenNames(factors map[string]float64) []string {
+	count := make(map[string]int, len(factors))
+	for name := range factors {
+		count[strings.ToLower(name)]++
+	}
+	var result []string
+	for name, n := range count {
+		if n > 1 {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// 把屏幕名改成 randr 中的写法。大小写不同的重复项中，与 randr 写法完全一致的优先，
+// 都不一致时按屏幕名排序后的第一个优先，保证结果不受 map 遍历顺序影响。
+func normalizeScreenFactors(factors map[string]float64, connected []string) map[string]float64 {
+	names := make([]string, 0, len(factors))
+	for name := range factors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make(map[string]float64, len(factors))
+	for _, name := range names {
+		normName := name
+		for _, c := range connected {
+			if strings.EqualFold(name, c) {
+				normName = c
+				break
+			}
+		}
+		if normName != name {
+			_, exact := factors[normName]
+			_, taken := result[normName]
+			if exact || taken {
+				logger.Warningf("ignore screen %q, duplicate of %q", name, normName)
+				continue
+			}
+			logger.Debugf("normalize screen name %q to %q", name, normName)
+		}
+		result[normName] = factors[name]
+	}
+	return result
+}
+
+// 按已连接的屏幕调整要保存的缩放比例。
+// 启动早期或者无头运行时没有已连接的屏幕，不按屏幕处理，只保证有 ALL 的值供只支持单值的程序使用。
+func adjustScreenFactorsForOutputs(factors map[string]float64, outputs []outputInfo, cfg scaleConfig) map[string]float64 {
+	if len(outputs) == 0 {
+		if _, ok := factors["ALL"]; ok || len(factors) == 0 {
+			return factors
+		}
+		result := make(map[string]float64, len(factors)+1)
+		for name, factor := range factors {
+			result[name] = factor
+		}
+		result["ALL"] = getSingleScaleFactor(factors)
+		return result
+	}
+
+	connected := make([]string, len(outputs))
+	for i, output := range outputs {
+		connected[i] = output.name
+	}
+	factors = normalizeScreenFactors(factors, connected)
+	if cfg.maxEffectiveDpi > 0 {
+		factors = capScreenFactorsByDpi(factors, outputs, cfg.maxEffectiveDpi, cfg.minFactor)
+	}
+	return factors
+}
+
+// setOutputScaleFactor 在 factors 的基础上只修改 output 的缩放比例，output 必须是已连接的屏幕。
+// 主屏没有单独设置时按原来实际使用的值补上，避免主屏的缩放跟着改变。
+func setOutputScaleFactor(factors map[string]float64, output string, factor float64,
+	connected []string, primary string) (map[string]float64, error) {
+	found := false
+	for _, name := range connected {
+		if name == output {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("output %q is not connected", output)
+	}
+
+	result := make(map[string]float64, len(factors)+2)
+	for name, v := range factors {
+		result[name] = v
+	}
+	if _, ok := factors[primary]; primary != "" && !ok {
+		result[primary] = resolveScreenFactor(factors, primary)
+	}
+	result[output] = factor
+	return result, nil
+}
+
+func (m *XSManager) setScaleFactorForOutput(output string, factor float64) error {
+	connected, err := m.getConnectedOutputNames()
+	if err != nil {
+		return err
+	}
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		logger.Warning("failed to get primary screen:", err)
+	}
+	// 在等待中的值应用之后的基础上修改，读取和提交之间不能插入其他的设置
+	m.flushScaleApplier()
+	m.scaleMu.Lock()
+	defer m.scaleMu.Unlock()
+	factors, err := setOutputScaleFactor(m.getScreenScaleFactors(), output, factor, connected, primary)
+	if err != nil {
+		return err
+	}
+	return m.setScreenScaleFactorsLocked(factors, true)
+}
+
+// snapScaleFactor 把缩放比例取整到最接近的 step 的整数倍，避免 1.13 这样的值经过 %.2f 格式化后
+// 在各程序中得到不一致的字体和光标大小。step 不大于 0 时不取整。
+func snapScaleFactor(v, step float64) float64 {
+	if step <= 0 {
+		return v
+	}
+	// 去掉 0.1 这类步长相乘产生的误差，例如 1.2000000000000002
+	return math.Round(math.Round(v/step)*step*1e6) / 1e6
+}
+
+// snapScreenFactors 把各屏幕的缩放比例取整，exempt 指定的屏幕保留原值
+func snapScreenFactors(factors map[string]float64, step float64, exempt string) map[string]float64 {
+	result := make(map[string]float64, len(factors))
+	for name, factor := range factors {
+		if name != exempt {
+			factor = snapScaleFactor(factor, step)
+		}
+		result[name] = factor
+	}
+	return result
+}
+
+// 屏幕缩放比例的来源。explicit、ALL 和 default 也是 GetScreenFactorSources 返回的标签；
+// single 只在内部区分，对外报告为 ALL，见 getScreenFactorSources。
+const (
+	screenFactorSourceExplicit = "explicit" // 单独设置了该屏幕
+	screenFactorSourceAll      = "ALL"      // 使用 ALL 的值
+	screenFactorSourceSingle   = "single"   // 只设置了其他一个屏幕，作为单值使用
+	screenFactorSourceDefault  = "default"  // 没有可用的设置，使用 1
+)
+
+// 获取屏幕实际使用的缩放比例，没有单独设置的使用 ALL 的值或者单值。
+func resolveScreenFactor(factors map[string]float64, screen string) float64 {
+	factor, _ := resolveScreenFactorSource(factors, screen)
+	return factor
+}
+
+// 与 getSingleScaleFactor 的规则一致，同时返回来源。
+// 目前没有按接口类型的规则，也不读取系统级的默认值。
+func resolveScreenFactorSource(factors map[string]float64, screen string) (float64, string) {
+	if v, ok := factors[screen]; ok {
+		return v, screenFactorSourceExplicit
+	}
+	if v, ok := factors["ALL"]; ok {
+		return v, screenFactorSourceAll
+	}
+	if len(factors) == 1 {
+		return getMapFirstValueSF(factors), screenFactorSourceSingle
+	}
+	return 1, screenFactorSourceDefault
+}
+
+// 固定在 screen 上显示的只支持单值的程序应该使用的缩放比例。
+// 没有设置 individual-scaling 时使用 scale-factor。
+func getAppScaleForScreen(factors map[string]float64, singleFactor float64, screen string) float64 {
+	if len(factors) == 0 {
+		return singleFactor
+	}
+	return resolveScreenFactor(factors, screen)
+}
+
+func (m *XSManager) getAppScaleForScreen(screen string) float64 {
+	return getAppScaleForScreen(m.getScreenScaleFactors(), m.gs.GetDouble(gsKeyScaleFactor), screen)
+}
+
+// 主屏的缩放比例，依次使用主屏的设置、ALL 的设置和 scale-factor
+func getPrimaryScreenScaleFactor(factors map[string]float64, primary string, singleFactor float64) float64 {
+	if v, ok := factors[primary]; ok && primary != "" {
+		return v
+	}
+	if v, ok := factors["ALL"]; ok {
+		return v
+	}
+	return singleFactor
+}
+
+func (m *XSManager) getPrimaryScreenScaleFactor() float64 {
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		logger.Warning("failed to get primary screen:", err)
+	}
+	return getPrimaryScreenScaleFactor(m.getScreenScaleFactors(), primary, m.gs.GetDouble(gsKeyScaleFactor))
+}
+
+// 已连接的屏幕实际使用的缩放比例，去重后从小到大排序。没有已连接的屏幕时返回单值。
+func getDistinctScaleFactors(factors map[string]float64, connected []string) []float64 {
+	if len(connected) == 0 {
+		return []float64{getSingleScaleFactor(factors)}
+	}
+	seen := make(map[float64]bool, len(connected))
+	result := make([]float64, 0, len(connected))
+	for _, name := range connected {
+		factor := resolveScreenFactor(factors, name)
+		if seen[factor] {
+			continue
+		}
+		seen[factor] = true
+		result = append(result, factor)
+	}
+	sort.Float64s(result)
+	return result
+}
+
+func (m *XSManager) getDistinctScaleFactors() ([]float64, error) {
+	connected, err := m.getConnectedOutputNames()
+	if err != nil {
+		return nil, err
+	}
+	return getDistinctScaleFactors(m.getScreenScaleFactors(), connected), nil
+}
+
+// getScreenFactorSources 返回已连接的屏幕缩放比例的来源标签。
+// 接口约定的标签有 explicit、connector-rule、ALL、system-default 和 default，与内部来源的对应关系：
+//   - explicit、ALL、default 原样返回；
+//   - single 报告为 ALL：individual-scaling 只有一项时，这一项与 ALL 一样作为所有屏幕的单值使用；
+//   - 没有按接口类型的规则，也不读取系统级的默认值，不会返回 connector-rule 和 system-default。
+func getScreenFactorSources(factors map[string]float64, connected []string) map[string]string {
+	result := make(map[string]string, len(connected))
+	for _, name := range connected {
+		_, source := resolveScreenFactorSource(factors, name)
+		if source == screenFactorSourceSingle {
+			source = screenFactorSourceAll
+		}
+		result[name] = source
+	}
+	return result
+}
+
+func (m *XSManager) getScreenFactorSources() (map[string]string, error) {
+	connected, err := m.getConnectedOutputNames()
+	if err != nil {
+		return nil, err
+	}
+	return getScreenFactorSources(m.getScreenScaleFactors(), connected), nil
+}
+
+// 保存的缩放比例保留两位小数，差值小于 0.01 时认为相同
+func scaleFactorEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.01
+}
+
+// compareScaleToReference 比较已连接屏幕及 ref 中列出的屏幕实际使用的缩放比例，返回不一致的屏幕的描述
+func compareScaleToReference(factors, ref map[string]float64, connected []string) ([]string, error) {
+	if len(ref) == 0 {
+		return nil, errors.New("reference is empty")
+	}
+	screens := make(map[string]bool, len(connected)+len(ref))
+	for _, name := range connected {
+		screens[name] = true
+	}
+	for name := range ref {
+		if name != "ALL" {
+			screens[name] = true
+		}
+	}
+	names := make([]string, 0, len(screens))
+	for name := range screens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var diffs []string
+	for _, name := range names {
+		got := resolveScreenFactor(factors, name)
+		want := resolveScreenFactor(ref, name)
+		if !scaleFactorEqual(got, want) {
+			diffs = append(diffs, fmt.Sprintf("%s: %.2f, reference %.2f", name, got, want))
+		}
+	}
+	return diffs, nil
+}
+
+func (m *XSManager) compareScaleToReference(ref map[string]float64) ([]string, error) {
+	connected, err := m.getConnectedOutputNames()
+	if err != nil {
+		return nil, err
+	}
+	return compareScaleToReference(m.getScreenScaleFactors(), ref, connected)
+}
+
+const (
+	spanningScalePolicyMax     = "max"
+	spanningScalePolicyMin     = "min"
+	spanningScalePolicyPrimary = "primary"
+)
+
+// 计算横跨 screenA 和 screenB 的窗口应该使用的缩放比例
+func getSpanningScaleFactor(factors map[string]float64, screenA, screenB, primary, policy string) (float64, error) {
+	a := resolveScreenFactor(factors, screenA)
+	b := resolveScreenFactor(factors, screenB)
+	switch policy {
+	case spanningScalePolicyMax:
+		return math.Max(a, b), nil
+	case spanningScalePolicyMin:
+		return math.Min(a, b), nil
+	case spanningScalePolicyPrimary:
+		if primary == "" {
+			// 获取不到主屏时按 max 处理
+			return math.Max(a, b), nil
+		}
+		return resolveScreenFactor(factors, primary), nil
+	default:
+		return 0, fmt.Errorf("invalid spanning scale policy %q", policy)
+	}
+}
+
+func (m *XSManager) getSpanningScaleFactor(screenA, screenB string) (float64, error) {
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		logger.Warning("failed to get primary screen name:", err)
+	}
+	return getSpanningScaleFactor(m.getScreenScaleFactors(), screenA, screenB, primary,
+		m.getScaleConfig().spanningPolicy)
+}
+
+// ScreenScaleFactorEntry 单个屏幕的缩放比例信息
+type ScreenScaleFactorEntry struct {
+	Name      string
+	Factor    float64
+	IsPrimary bool
+	Connected bool
+}
+
+// 合并缩放配置和已连接的屏幕，已连接的屏幕在前，按名称排序。
+func getScreenScaleFactorEntries(factors map[string]float64, connected []string, primary string) []ScreenScaleFactorEntry {
+	entries := make([]ScreenScaleFactorEntry, 0, len(connected))
+	connectedSet := make(map[string]bool, len(connected))
+	for _, name := range connected {
+		connectedSet[name] = true
+		entries = append(entries, ScreenScaleFactorEntry{
+			Name:      name,
+			Factor:    resolveScreenFactor(factors, name),
+			IsPrimary: name == primary,
+			Connected: true,
+		})
+	}
+
+	for name, factor := range factors {
+		if name == "ALL" || connectedSet[name] {
+			continue
+		}
+		entries = append(entries, ScreenScaleFactorEntry{
+			Name:      name,
+			Factor:    factor,
+			IsPrimary: name == primary,
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Connected != entries[j].Connected {
+			return entries[i].Connected
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+func (m *XSManager) getScreenScaleFactorEntries() ([]ScreenScaleFactorEntry, error) {
+	connected, err := m.getConnectedOutputNames()
+	if err != nil {
+		return nil, err
+	}
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		logger.Warning("failed to get primary screen name:", err)
+	}
+	return getScreenScaleFactorEntries(m.getScreenScaleFactors(), connected, primary), nil
+}
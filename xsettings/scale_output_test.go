@@ -0,0 +1,599 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"testing"
+
+	gio "github.com/linuxdeepin/go-gir/gio-2.0"
+	"github.com/linuxdeepin/go-x11-client/ext/randr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getScreenScaleFactorEntries(t *testing.T) {
+	factors := map[string]float64{
+		"ALL":    1.25,
+		"eDP-1":  2,
+		"HDMI-2": 1.5,
+	}
+	connected := []string{"HDMI-1", "eDP-1"}
+
+	got := getScreenScaleFactorEntries(factors, connected, "eDP-1")
+	assert.Equal(t, []ScreenScaleFactorEntry{
+		{Name: "HDMI-1", Factor: 1.25, Connected: true},
+		{Name: "eDP-1", Factor: 2, IsPrimary: true, Connected: true},
+		{Name: "HDMI-2", Factor: 1.5},
+	}, got)
+
+	got = getScreenScaleFactorEntries(map[string]float64{"ALL": 1.5}, []string{"VGA-1"}, "VGA-1")
+	assert.Equal(t, []ScreenScaleFactorEntry{
+		{Name: "VGA-1", Factor: 1.5, IsPrimary: true, Connected: true},
+	}, got)
+}
+
+func Test_outputInfo_rotation(t *testing.T) {
+	// 27 寸 4K 屏幕
+	landscape := outputInfo{
+		name:     "DP-1",
+		mmWidth:  597,
+		mmHeight: 336,
+		width:    3840,
+		height:   2160,
+		rotation: randr.RotationRotate0,
+	}
+	portrait := func(rotation uint16) outputInfo {
+		o := landscape
+		o.width, o.height = landscape.height, landscape.width
+		o.rotation = rotation
+		return o
+	}
+
+	tests := []struct {
+		name   string
+		output outputInfo
+	}{
+		{name: "0", output: landscape},
+		{name: "90", output: portrait(randr.RotationRotate90)},
+		{name: "270", output: portrait(randr.RotationRotate270)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dpiX, dpiY := tt.output.getDpi()
+			assert.InDelta(t, 163, dpiX, 1)
+			assert.InDelta(t, 163, dpiY, 1)
+			assert.Equal(t, 1.75, tt.output.getRecommendedScaleFactor(defaultMinScaleFactor, defaultMaxScaleFactor))
+		})
+	}
+
+	// 不考虑旋转时，纵向屏幕的 DPI 计算错误
+	wrong := portrait(randr.RotationRotate0)
+	dpiX, _ := wrong.getDpi()
+	assert.InDelta(t, 92, dpiX, 1)
+}
+
+func Test_outputInfo_getScaleFactorForDpi(t *testing.T) {
+	// 27 寸 4K 屏幕
+	monitor := outputInfo{name: "DP-1", mmWidth: 597, mmHeight: 336, width: 3840, height: 2160}
+	// 14 寸 1080p 笔记本屏幕
+	laptop := outputInfo{name: "eDP-1", mmWidth: 309, mmHeight: 174, width: 1920, height: 1080}
+
+	tests := []struct {
+		name      string
+		output    outputInfo
+		targetDpi float64
+		want      float64
+	}{
+		{name: "4k 110", output: monitor, targetDpi: 110, want: 1.48},
+		{name: "4k 96", output: monitor, targetDpi: 96, want: 1.7},
+		{name: "laptop 110", output: laptop, targetDpi: 110, want: 1.43},
+		{name: "laptop clamp", output: laptop, targetDpi: 200, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.output.getScaleFactorForDpi(tt.targetDpi, 1, 3)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	// 使用配置的范围
+	got, err := laptop.getScaleFactorForDpi(200, defaultMinScaleFactor, defaultMaxScaleFactor)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.79, got)
+
+	_, err = monitor.getScaleFactorForDpi(0, 1, 3)
+	assert.Error(t, err)
+
+	_, err = (&outputInfo{name: "VGA-1"}).getScaleFactorForDpi(110, 1, 3)
+	assert.Error(t, err)
+
+	_, err = findOutputInfo([]outputInfo{monitor, laptop}, "HDMI-1")
+	assert.Error(t, err)
+	found, err := findOutputInfo([]outputInfo{monitor, laptop}, "eDP-1")
+	assert.NoError(t, err)
+	assert.Equal(t, laptop, *found)
+}
+
+func Test_normalizeScreenFactors(t *testing.T) {
+	factors := parseScreenFactors("hdmi-1=1.25;HDMI-1=1.50;edp-1=2.00;ALL=1.00;VGA-1=1.00")
+	assert.Equal(t, []string{"hdmi-1"}, findDuplicateScreenNames(factors))
+	assert.Empty(t, findDuplicateScreenNames(map[string]float64{"HDMI-1": 1, "eDP-1": 2}))
+
+	got := normalizeScreenFactors(factors, []string{"HDMI-1", "eDP-1"})
+	assert.Equal(t, map[string]float64{
+		"HDMI-1": 1.5,
+		"eDP-1":  2,
+		"ALL":    1,
+		"VGA-1":  1,
+	}, got)
+	assert.Empty(t, findDuplicateScreenNames(got))
+
+	// 没有与 randr 写法一致的项时，按排序后的第一个，多次运行结果相同
+	factors = parseScreenFactors("hdmi-1=1.25;Hdmi-1=1.50;HDmi-1=1.75")
+	for i := 0; i < 20; i++ {
+		got = normalizeScreenFactors(factors, []string{"HDMI-1"})
+		assert.Equal(t, map[string]float64{"HDMI-1": 1.75}, got)
+	}
+}
+
+func Test_getSpanningScaleFactor(t *testing.T) {
+	factors := map[string]float64{"eDP-1": 2, "HDMI-1": 1.25}
+	tests := []struct {
+		policy  string
+		primary string
+		want    float64
+	}{
+		{policy: spanningScalePolicyMax, primary: "HDMI-1", want: 2},
+		{policy: spanningScalePolicyMin, primary: "eDP-1", want: 1.25},
+		{policy: spanningScalePolicyPrimary, primary: "HDMI-1", want: 1.25},
+		{policy: spanningScalePolicyPrimary, primary: "eDP-1", want: 2},
+		{policy: spanningScalePolicyPrimary, primary: "", want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+"/"+tt.primary, func(t *testing.T) {
+			got, err := getSpanningScaleFactor(factors, "eDP-1", "HDMI-1", tt.primary, tt.policy)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := getSpanningScaleFactor(factors, "eDP-1", "HDMI-1", "eDP-1", "avg")
+	assert.Error(t, err)
+}
+
+func Test_resolveScreenFactorSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		factors    map[string]float64
+		wantFactor float64
+		wantSource string
+	}{
+		{name: "explicit", factors: map[string]float64{"eDP-1": 2, "ALL": 1.25}, wantFactor: 2, wantSource: screenFactorSourceExplicit},
+		{name: "all", factors: map[string]float64{"HDMI-1": 1.5, "ALL": 1.25}, wantFactor: 1.25, wantSource: screenFactorSourceAll},
+		{name: "single", factors: map[string]float64{"HDMI-1": 1.5}, wantFactor: 1.5, wantSource: screenFactorSourceSingle},
+		{name: "default", factors: map[string]float64{"HDMI-1": 1.5, "VGA-1": 2}, wantFactor: 1, wantSource: screenFactorSourceDefault},
+		{name: "empty", factors: nil, wantFactor: 1, wantSource: screenFactorSourceDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factor, source := resolveScreenFactorSource(tt.factors, "eDP-1")
+			assert.Equal(t, tt.wantFactor, factor)
+			assert.Equal(t, tt.wantSource, source)
+			assert.Equal(t, factor, resolveScreenFactor(tt.factors, "eDP-1"))
+			// 与单值的规则一致
+			if source != screenFactorSourceExplicit {
+				assert.Equal(t, getSingleScaleFactor(tt.factors), factor)
+			}
+		})
+	}
+
+	assert.Equal(t, map[string]string{
+		"eDP-1":  screenFactorSourceExplicit,
+		"HDMI-1": screenFactorSourceAll,
+	}, getScreenFactorSources(map[string]float64{"eDP-1": 2, "ALL": 1}, []string{"eDP-1", "HDMI-1"}))
+}
+
+func Test_getScreenFactorSources(t *testing.T) {
+	connected := []string{"eDP-1", "HDMI-1"}
+	tests := []struct {
+		name    string
+		factors map[string]float64
+		want    map[string]string
+	}{
+		{name: "explicit and ALL", factors: map[string]float64{"eDP-1": 2, "ALL": 1},
+			want: map[string]string{"eDP-1": "explicit", "HDMI-1": "ALL"}},
+		// 只有一项时作为单值使用，与 ALL 相同
+		{name: "single", factors: map[string]float64{"eDP-1": 2},
+			want: map[string]string{"eDP-1": "explicit", "HDMI-1": "ALL"}},
+		{name: "default", factors: map[string]float64{"eDP-1": 2, "VGA-1": 1},
+			want: map[string]string{"eDP-1": "explicit", "HDMI-1": "default"}},
+		{name: "empty", factors: nil,
+			want: map[string]string{"eDP-1": "default", "HDMI-1": "default"}},
+	}
+	labels := []string{"explicit", "connector-rule", "ALL", "system-default", "default"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getScreenFactorSources(tt.factors, connected)
+			assert.Equal(t, tt.want, got)
+			for _, source := range got {
+				assert.Contains(t, labels, source)
+			}
+		})
+	}
+}
+
+func Test_getScaleFactorLabel(t *testing.T) {
+	assert.Equal(t, "100% (Recommended)", getScaleFactorLabel(1, 1))
+	assert.Equal(t, "175% (Recommended)", getScaleFactorLabel(1.75, 1.75))
+	assert.Equal(t, "125%", getScaleFactorLabel(1.25, 1.75))
+	// 没有推荐值
+	assert.Equal(t, "150%", getScaleFactorLabel(1.5, 0))
+}
+
+func Test_outputInfo_getScaleFactorForTextHeight(t *testing.T) {
+	// 27 寸 4K 屏幕
+	monitor := outputInfo{name: "DP-1", mmWidth: 597, mmHeight: 336, width: 3840, height: 2160}
+	// 14 寸 1080p 笔记本屏幕
+	laptop := outputInfo{name: "eDP-1", mmWidth: 309, mmHeight: 174, width: 1920, height: 1080}
+
+	tests := []struct {
+		name   string
+		output outputInfo
+		mm     float64
+		want   float64
+	}{
+		{name: "4k 3mm", output: monitor, mm: 3, want: 1.38},
+		{name: "4k 4mm", output: monitor, mm: 4, want: 1.84},
+		{name: "laptop 3mm", output: laptop, mm: 3, want: 1.33},
+		{name: "laptop clamp", output: laptop, mm: 1, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.output.getScaleFactorForTextHeight(tt.mm, 1, 3)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := monitor.getScaleFactorForTextHeight(0, 1, 3)
+	assert.Error(t, err)
+}
+
+func Test_getDistinctScaleFactors(t *testing.T) {
+	connected := []string{"eDP-1", "HDMI-1", "DP-1"}
+	assert.Equal(t, []float64{1.25},
+		getDistinctScaleFactors(singleToMapSF(1.25), connected))
+	assert.Equal(t, []float64{1, 2},
+		getDistinctScaleFactors(map[string]float64{"eDP-1": 2, "ALL": 1}, connected))
+	assert.Equal(t, []float64{1, 1.5, 2},
+		getDistinctScaleFactors(map[string]float64{"eDP-1": 2, "HDMI-1": 1.5, "DP-1": 1}, connected))
+	// 没有已连接的屏幕
+	assert.Equal(t, []float64{1.5}, getDistinctScaleFactors(singleToMapSF(1.5), nil))
+}
+
+func Test_adjustScreenFactorsForOutputs_noOutputs(t *testing.T) {
+	cfg := scaleConfig{maxEffectiveDpi: 200}
+	tests := []struct {
+		factors map[string]float64
+		want    map[string]float64
+	}{
+		{singleToMapSF(1.5), singleToMapSF(1.5)},
+		{map[string]float64{"eDP-1": 2}, map[string]float64{"eDP-1": 2, "ALL": 2}},
+		{map[string]float64{"eDP-1": 2, "HDMI-1": 1}, map[string]float64{"eDP-1": 2, "HDMI-1": 1, "ALL": 1}},
+		{map[string]float64{"eDP-1": 2, "ALL": 1.25}, map[string]float64{"eDP-1": 2, "ALL": 1.25}},
+		{map[string]float64{}, map[string]float64{}},
+	}
+	for _, tt := range tests {
+		got := adjustScreenFactorsForOutputs(tt.factors, nil, cfg)
+		assert.Equal(t, tt.want, got)
+	}
+
+	factors := adjustScreenFactorsForOutputs(singleToMapSF(2), nil, cfg)
+	assert.Equal(t, 2.0, getSingleScaleFactor(factors))
+	value, err := getQtScreenScaleFactorsValue(factors)
+	require.NoError(t, err)
+	assert.Equal(t, "2.00", value)
+	assert.Empty(t, getScreenFactorSources(factors, nil))
+}
+
+func Test_capScreenFactorsByDpi(t *testing.T) {
+	// 13 寸 3K 屏幕，约 275 DPI
+	dense := outputInfo{name: "eDP-1", mmWidth: 286, mmHeight: 179, width: 3000, height: 2000}
+	// 24 寸 1080p 屏幕，约 92 DPI
+	normal := outputInfo{name: "HDMI-1", mmWidth: 527, mmHeight: 296, width: 1920, height: 1080}
+	outputs := []outputInfo{dense, normal}
+
+	factors := map[string]float64{"ALL": 2}
+	got := capScreenFactorsByDpi(factors, outputs, 400, 1)
+	assert.Equal(t, map[string]float64{"ALL": 2, "eDP-1": 1.45}, got)
+	// 不修改参数
+	assert.Equal(t, map[string]float64{"ALL": 2}, factors)
+
+	// 不超过上限时不变
+	got = capScreenFactorsByDpi(map[string]float64{"eDP-1": 1.5, "HDMI-1": 1}, outputs, 420, 1)
+	assert.Equal(t, map[string]float64{"eDP-1": 1.5, "HDMI-1": 1}, got)
+
+	// 最低降到 minFactor
+	got = capScreenFactorsByDpi(map[string]float64{"eDP-1": 2, "HDMI-1": 1}, outputs, 200, 1)
+	assert.Equal(t, map[string]float64{"eDP-1": 1, "HDMI-1": 1}, got)
+	got = capScreenFactorsByDpi(map[string]float64{"eDP-1": 2, "HDMI-1": 1}, outputs, 100, 0.5)
+	assert.Equal(t, map[string]float64{"eDP-1": 0.5, "HDMI-1": 1}, got)
+}
+
+func Test_compareScaleToReference(t *testing.T) {
+	connected := []string{"eDP-1", "HDMI-1"}
+	tests := []struct {
+		name    string
+		factors map[string]float64
+		ref     map[string]float64
+		want    []string
+	}{
+		{
+			name:    "match",
+			factors: map[string]float64{"eDP-1": 2, "HDMI-1": 1},
+			ref:     map[string]float64{"eDP-1": 2, "HDMI-1": 1},
+		},
+		{
+			name:    "match by ALL",
+			factors: map[string]float64{"eDP-1": 1.25, "HDMI-1": 1.25},
+			ref:     map[string]float64{"ALL": 1.25},
+		},
+		{
+			name:    "rounding",
+			factors: map[string]float64{"ALL": 1.251},
+			ref:     map[string]float64{"ALL": 1.25},
+		},
+		{
+			name:    "diverge",
+			factors: map[string]float64{"eDP-1": 2, "HDMI-1": 1},
+			ref:     map[string]float64{"eDP-1": 1.5, "HDMI-1": 1},
+			want:    []string{"eDP-1: 2.00, reference 1.50"},
+		},
+		{
+			name:    "reference screen not connected",
+			factors: map[string]float64{"ALL": 1},
+			ref:     map[string]float64{"ALL": 1, "DP-1": 2},
+			want:    []string{"DP-1: 1.00, reference 2.00"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compareScaleToReference(tt.factors, tt.ref, connected)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := compareScaleToReference(map[string]float64{"ALL": 1}, nil, connected)
+	assert.Error(t, err)
+}
+
+func Test_setOutputScaleFactor(t *testing.T) {
+	connected := []string{"eDP-1", "HDMI-1"}
+
+	factors, err := setOutputScaleFactor(map[string]float64{"eDP-1": 2, "HDMI-1": 1},
+		"HDMI-1", 1.5, connected, "eDP-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"eDP-1": 2, "HDMI-1": 1.5}, factors)
+
+	// 主屏按原来的值补上
+	factors, err = setOutputScaleFactor(singleToMapSF(1.25), "HDMI-1", 2, connected, "eDP-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"ALL": 1.25, "eDP-1": 1.25, "HDMI-1": 2}, factors)
+
+	factors, err = setOutputScaleFactor(map[string]float64{}, "eDP-1", 2, connected, "eDP-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"eDP-1": 2}, factors)
+
+	_, err = setOutputScaleFactor(singleToMapSF(1), "DP-1", 2, connected, "eDP-1")
+	assert.Error(t, err)
+}
+
+func Test_snapScreenFactors(t *testing.T) {
+	factors := map[string]float64{"eDP-1": 1.37, "HDMI-1": 1.3}
+	assert.Equal(t, map[string]float64{"eDP-1": 1.37, "HDMI-1": 1.25},
+		snapScreenFactors(factors, 0.25, "eDP-1"))
+	assert.Equal(t, map[string]float64{"eDP-1": 1.25, "HDMI-1": 1.25},
+		snapScreenFactors(factors, 0.25, ""))
+	assert.Equal(t, map[string]float64{"ALL": 1.5, "eDP-1": 1.37},
+		snapScreenFactors(map[string]float64{"ALL": 1.6, "eDP-1": 1.37}, 0.5, "eDP-1"))
+}
+
+func Test_snapScaleFactor(t *testing.T) {
+	tests := []struct {
+		v, step, want float64
+	}{
+		{1.13, 0.25, 1.25},
+		{1.12, 0.25, 1},
+		{1.37, 0.25, 1.25},
+		{1.38, 0.25, 1.5},
+		{2, 0.25, 2},
+		{1.6, 0.5, 1.5},
+		{1.13, 0, 1.13},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, snapScaleFactor(tt.v, tt.step), tt)
+	}
+
+	factors := snapScreenFactors(map[string]float64{"eDP-1": 1.13, "HDMI-1": 1.12}, 0.25, "")
+	assert.Equal(t, 1.0, getSingleScaleFactor(snapScreenFactors(singleToMapSF(1.12), 0.25, "")))
+	assert.Equal(t, map[string]float64{"eDP-1": 1.25, "HDMI-1": 1}, factors)
+}
+
+func Test_screenDpisToFactors(t *testing.T) {
+	// 27 寸 4K 屏幕，约 163 DPI
+	monitor := outputInfo{name: "DP-1", mmWidth: 597, mmHeight: 336, width: 3840, height: 2160}
+	// 14 寸 1080p 笔记本屏幕，约 158 DPI
+	laptop := outputInfo{name: "eDP-1", mmWidth: 309, mmHeight: 174, width: 1920, height: 1080}
+	outputs := []outputInfo{monitor, laptop}
+
+	factors, err := screenDpisToFactors(map[string]float64{"DP-1": 96, "eDP-1": 110}, outputs, 0.5, 3)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"DP-1": 1.7, "eDP-1": 1.43}, factors)
+
+	// 超出范围
+	_, err = screenDpisToFactors(map[string]float64{"eDP-1": 400}, outputs, 0.5, 3)
+	assert.Error(t, err)
+	_, err = screenDpisToFactors(map[string]float64{"eDP-1": 30}, outputs, 0.5, 3)
+	assert.Error(t, err)
+	// 未连接的屏幕
+	_, err = screenDpisToFactors(map[string]float64{"HDMI-1": 96}, outputs, 0.5, 3)
+	assert.Error(t, err)
+	_, err = screenDpisToFactors(map[string]float64{"eDP-1": 0}, outputs, 0.5, 3)
+	assert.Error(t, err)
+	_, err = screenDpisToFactors(nil, outputs, 0.5, 3)
+	assert.Error(t, err)
+}
+
+func Test_getScaleBlurInfo(t *testing.T) {
+	tests := []struct {
+		factor     float64
+		want       bool
+		wantReason string
+	}{
+		{1, false, "Scale factor 1.00 is an integer, apps will be rendered sharply"},
+		{1.5, true, "Scale factor 1.50 is fractional, apps that only support integer scaling are drawn at 1x and may look blurry"},
+		{1.75, true, "Scale factor 1.75 is fractional, apps that only support integer scaling are drawn at 2x and may look blurry"},
+		{2, false, "Scale factor 2.00 is an integer, apps will be rendered sharply"},
+	}
+	for _, tt := range tests {
+		got, reason := getScaleBlurInfo(tt.factor)
+		assert.Equal(t, tt.want, got, tt.factor)
+		assert.Equal(t, tt.wantReason, reason)
+	}
+}
+
+func Test_recommendScaleForOutput(t *testing.T) {
+	tests := []struct {
+		name              string
+		widthMm, heightMm uint32
+		widthPx, heightPx int
+		want              float64
+	}{
+		{"24 inch 1080p", 527, 296, 1920, 1080, 1},
+		{"27 inch 4K", 597, 336, 3840, 2160, 1.75},
+		{"13 inch 3K", 286, 179, 3000, 2000, 2.75},
+		{"15 inch 4K", 344, 194, 3840, 2160, 3},
+		{"unknown size", 0, 0, 3840, 2160, 1},
+		{"no mode", 597, 336, 0, 0, 1},
+	}
+	// 推荐值也限制在配置的范围内
+	assert.Equal(t, 2.5, recommendScaleForOutput(344, 194, 3840, 2160, 1, 2.5))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, recommendScaleForOutput(tt.widthMm, tt.heightMm, tt.widthPx, tt.heightPx, 1, 3))
+		})
+	}
+}
+
+func Test_fillMissingScreenFactors(t *testing.T) {
+	laptop := outputInfo{name: "eDP-1", mmWidth: 309, mmHeight: 174, width: 1920, height: 1080}
+	monitor := outputInfo{name: "DP-1", mmWidth: 597, mmHeight: 336, width: 3840, height: 2160}
+	// 刚插入，还没有分配 crtc
+	pending := outputInfo{name: "HDMI-1", mmWidth: 597, mmHeight: 336}
+	outputs := []outputInfo{laptop, monitor, pending}
+	newOutputs := []string{"DP-1", "HDMI-1"}
+	cfg := scaleConfig{minFactor: defaultMinScaleFactor, maxFactor: defaultMaxScaleFactor}
+
+	factors, filled, pendingNames := fillMissingScreenFactors(map[string]float64{"eDP-1": 1.25, "VGA-1": 1},
+		outputs, newOutputs, cfg)
+	assert.Equal(t, map[string]float64{"eDP-1": 1.25, "VGA-1": 1, "DP-1": 1.75}, factors)
+	assert.Equal(t, []string{"DP-1"}, filled)
+	assert.Equal(t, []string{"HDMI-1"}, pendingNames)
+
+	// 之前只有一项作为单值使用，之前已连接的屏幕固定为原来的值
+	normal := outputInfo{name: "HDMI-2", mmWidth: 527, mmHeight: 296, width: 1920, height: 1080}
+	factors, filled, _ = fillMissingScreenFactors(map[string]float64{"eDP-1": 1.25},
+		[]outputInfo{laptop, normal, monitor}, []string{"DP-1"}, cfg)
+	assert.Equal(t, map[string]float64{"eDP-1": 1.25, "HDMI-2": 1.25, "DP-1": 1.75}, factors)
+	assert.Equal(t, []string{"DP-1"}, filled)
+
+	// 不是新连接的屏幕不处理
+	_, filled, _ = fillMissingScreenFactors(map[string]float64{"eDP-1": 1.25}, outputs, nil, cfg)
+	assert.Empty(t, filled)
+	// 已经设置过的不变
+	factors, filled, _ = fillMissingScreenFactors(map[string]float64{"eDP-1": 1.25, "DP-1": 1}, outputs, newOutputs, cfg)
+	assert.Empty(t, filled)
+	assert.Equal(t, map[string]float64{"eDP-1": 1.25, "DP-1": 1}, factors)
+	// 由 ALL 决定的不变
+	_, filled, _ = fillMissingScreenFactors(singleToMapSF(1.5), outputs, newOutputs, cfg)
+	assert.Empty(t, filled)
+	// 没有单独设置，都使用 scale-factor
+	_, filled, _ = fillMissingScreenFactors(map[string]float64{}, outputs, newOutputs, cfg)
+	assert.Empty(t, filled)
+
+	// 推荐值按配置取整并限制范围
+	cfg.snapStep = 0.5
+	cfg.maxFactor = 1.5
+	factors, _, _ = fillMissingScreenFactors(map[string]float64{"eDP-1": 1.25, "VGA-1": 1}, outputs, newOutputs, cfg)
+	assert.Equal(t, 1.5, factors["DP-1"])
+}
+
+func Test_outputTracker(t *testing.T) {
+	tracker := newOutputTracker([]string{"eDP-1", "HDMI-1"})
+	assert.Equal(t, []string{"DP-1"}, tracker.update([]string{"eDP-1", "HDMI-1", "DP-1"}))
+	assert.Empty(t, tracker.update([]string{"eDP-1", "DP-1"}))
+	// 重新连接
+	assert.Equal(t, []string{"HDMI-1"}, tracker.update([]string{"eDP-1", "HDMI-1", "DP-1"}))
+	tracker.forget([]string{"HDMI-1"})
+	assert.Equal(t, []string{"HDMI-1"}, tracker.update([]string{"eDP-1", "HDMI-1", "DP-1"}))
+}
+
+func Test_getAppScaleForScreen(t *testing.T) {
+	factors := map[string]float64{"eDP-1": 2, "ALL": 1.25}
+	assert.Equal(t, 2.0, getAppScaleForScreen(factors, 1.5, "eDP-1"))
+	assert.Equal(t, 1.25, getAppScaleForScreen(factors, 1.5, "HDMI-1"))
+	assert.Equal(t, 1.0, getAppScaleForScreen(map[string]float64{"eDP-1": 2, "DP-1": 1.5}, 1.5, "HDMI-1"))
+	// 没有设置 individual-scaling
+	assert.Equal(t, 1.5, getAppScaleForScreen(nil, 1.5, "HDMI-1"))
+}
+
+func Test_getPrimaryScreenScaleFactor(t *testing.T) {
+	factors := map[string]float64{"eDP-1": 2, "HDMI-1": 1}
+	assert.Equal(t, 2.0, getPrimaryScreenScaleFactor(factors, "eDP-1", 1.5))
+	// 主屏不在设置中，使用 ALL
+	assert.Equal(t, 1.25, getPrimaryScreenScaleFactor(map[string]float64{"HDMI-1": 1, "ALL": 1.25}, "eDP-1", 1.5))
+	assert.Equal(t, 1.5, getPrimaryScreenScaleFactor(factors, "DP-1", 1.5))
+	assert.Equal(t, 1.5, getPrimaryScreenScaleFactor(nil, "eDP-1", 1.5))
+	assert.Equal(t, 1.5, getPrimaryScreenScaleFactor(factors, "", 1.5))
+}
+
+func Test_connectedOutputQueries(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema)
+	m := &XSManager{
+		gs: gio.NewSettings(xsSchema),
+		connectedOutputs: func() ([]outputInfo, error) {
+			return []outputInfo{{name: "eDP-1"}, {name: "HDMI-1"}}, nil
+		},
+	}
+	m.primaryScreenCache = newPrimaryScreenCache(func() (string, error) {
+		return "eDP-1", nil
+	})
+	m.gs.SetString(gsKeyIndividualScaling, "ALL=1.25;eDP-1=2.00;DP-1=1.50")
+
+	// 只使用替换后的已连接屏幕，没有连接的 DP-1 不参与
+	factors, err := m.loadScreenFactors()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"eDP-1": 2, "HDMI-1": 1.25}, factors)
+	distinct, err := m.getDistinctScaleFactors()
+	require.NoError(t, err)
+	assert.Equal(t, []float64{1.25, 2}, distinct)
+	sources, err := m.getScreenFactorSources()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"eDP-1": "explicit", "HDMI-1": "ALL"}, sources)
+	entries, err := m.getScreenScaleFactorEntries()
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.Equal(t, entry.Name != "DP-1", entry.Connected, entry.Name)
+	}
+
+	m.connectedOutputs = func() ([]outputInfo, error) {
+		return nil, errors.New("no randr")
+	}
+	_, err = m.loadScreenFactors()
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"encoding/json"
+)
+
+// ScalePreview 一次缩放设置将会写入的值，由 PreviewScaleFactors 以 JSON 返回
+type ScalePreview struct {
+	// 限制范围和取整之后各屏幕的缩放
+	Factors map[string]float64 `json:"factors"`
+	// 写入 individual-scaling 的值
+	IndividualScaling string  `json:"individualScaling"`
+	ScaleFactor       float64 `json:"scaleFactor"`
+	WindowScale       int32   `json:"windowScale"`
+	CursorSize        int32   `json:"cursorSize"`
+	// 写入 qt-theme.ini 的 ScreenScaleFactors 的值
+	QtScreenScaleFactors string `json:"qtScreenScaleFactors"`
+	PlymouthFactor       int    `json:"plymouthFactor"`
+	// 为空表示不设置 DEEPIN_WINE_SCALE
+	WineScale string `json:"wineScale"`
+}
+
+func newScalePreview(c *scaleChange) *ScalePreview {
+	return &ScalePreview{
+		Factors:              c.factors,
+		IndividualScaling:    c.factorsJoined,
+		ScaleFactor:          c.singleFactor,
+		WindowScale:          c.windowScale,
+		CursorSize:           c.cursorSize,
+		QtScreenScaleFactors: c.qt.value,
+		PlymouthFactor:       clampPlymouthFactor(int(c.windowScale), getPlymouthMaxScale()),
+		WineScale:            c.wineScale,
+	}
+}
+
+// 与实际设置走相同的校验和计算，但不写入 gsettings 和文件，也不通知其他服务
+func (m *XSManager) previewScaleFactors(factors map[string]float64) (string, error) {
+	c, err := m.buildScaleChange(factors)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(newScalePreview(c))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxdeepin/go-lib/keyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newScalePreview(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv(envPlymouthMaxScale, "")
+	qtThemeFile := getQtThemeFile()
+	err := os.MkdirAll(filepath.Dir(qtThemeFile), 0755)
+	require.NoError(t, err)
+	oldContent := []byte("[Theme]\nScreenScaleFactors=1.00\n")
+	err = ioutil.WriteFile(qtThemeFile, oldContent, 0644)
+	require.NoError(t, err)
+
+	cfg := scaleConfig{cursorBaseSize: baseCursorSize}
+	factors := map[string]float64{"eDP-1": 2.5, "HDMI-1": 1.25}
+
+	c, err := prepareScaleChange(factors, "eDP-1", cfg)
+	require.NoError(t, err)
+	c.wineScale = getWineScaleValue(c.factors, "eDP-1")
+	data, err := json.Marshal(newScalePreview(c))
+	require.NoError(t, err)
+
+	// 预览不写入文件
+	content, err := ioutil.ReadFile(qtThemeFile)
+	require.NoError(t, err)
+	assert.Equal(t, oldContent, content)
+
+	var preview ScalePreview
+	err = json.Unmarshal(data, &preview)
+	require.NoError(t, err)
+
+	// 实际写入之后与预览的值比较
+	err = c.qt.save()
+	require.NoError(t, err)
+	kf := keyfile.NewKeyFile()
+	err = kf.LoadFromFile(qtThemeFile)
+	require.NoError(t, err)
+	qtValue, err := kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+	require.NoError(t, err)
+	assert.Equal(t, qtValue, preview.QtScreenScaleFactors)
+
+	derived := deriveScaleValues(c.singleFactor, c.factors, cfg)
+	assert.Equal(t, factors, preview.Factors)
+	assert.Equal(t, factors, parseScreenFactors(preview.IndividualScaling))
+	assert.Equal(t, factors["eDP-1"], preview.ScaleFactor)
+	assert.Equal(t, derived.windowScale, preview.WindowScale)
+	assert.Equal(t, derived.cursorSize, preview.CursorSize)
+	assert.Equal(t, clampPlymouthFactor(int(derived.windowScale), defaultPlymouthMaxScale), preview.PlymouthFactor)
+	assert.Equal(t, c.wineScale, preview.WineScale)
+}
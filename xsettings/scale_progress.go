@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"sync"
+	"time"
+)
+
+// 设置缩放的各个阶段完成后的进度，设置 Plymouth 最慢，占用剩下的部分
+const (
+	scaleProgressDsfHelper     = 10
+	scaleProgressSettings      = 40
+	scaleProgressEnv           = 50
+	scaleProgressGreeter       = 60
+	scaleProgressPlymouthStart = 70
+	scaleProgressPlymouthMax   = 95
+	scaleProgressDone          = 100
+
+	signalSetScaleFactorProgress = "SetScaleFactorProgress"
+)
+
+// 等待 Plymouth 设置完成期间每隔这么长时间增加一次进度
+var plymouthProgressInterval = 2 * time.Second
+
+const plymouthProgressStep = 5
+
+// scaleProgressState 一次设置缩放的进度。保证先发送 SetScaleFactorStarted 再发送进度，且进度只增不减；
+// 上一次设置还没有发送 SetScaleFactorDone 时开始的修改合并到同一次中。
+type scaleProgressState struct {
+	mu       sync.Mutex
+	started  bool
+	progress int32
+}
+
+func (m *XSManager) emitScaleProgress(progress int32, emitSignal bool) {
+	if !emitSignal {
+		return
+	}
+	s := &m.scaleProgress
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.emitScaleProgressLocked(progress)
+}
+
+// 需要持有 scaleProgress.mu
+func (m *XSManager) emitScaleProgressLocked(progress int32) {
+	s := &m.scaleProgress
+	if !s.started {
+		s.started = true
+		s.progress = 0
+		m.emitSignalSetScaleFactor(false, true)
+	}
+	if progress <= s.progress {
+		return
+	}
+	s.progress = progress
+	err := m.service.Emit(m, signalSetScaleFactorProgress, progress)
+	if err != nil {
+		logger.Warning(err)
+	}
+}
+
+// 进度到 100 后发送 SetScaleFactorDone，结束这一次设置。还没有开始时只发送 SetScaleFactorDone。
+func (m *XSManager) emitScaleDone(emitSignal bool) {
+	if !emitSignal {
+		return
+	}
+	s := &m.scaleProgress
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		m.emitScaleProgressLocked(scaleProgressDone)
+	}
+	m.emitSignalSetScaleFactor(true, true)
+	s.started = false
+}
+
+// startPlymouthProgress 在等待 Plymouth 设置完成期间逐步增加进度，不超过 scaleProgressPlymouthMax，
+// 返回的函数用于停止
+func (m *XSManager) startPlymouthProgress(emitSignal bool) (stop func()) {
+	m.emitScaleProgress(scaleProgressPlymouthStart, emitSignal)
+	if !emitSignal {
+		return func() {}
+	}
+	ticker := time.NewTicker(plymouthProgressInterval)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		progress := int32(scaleProgressPlymouthStart)
+		for {
+			select {
+			case <-ticker.C:
+				if progress < scaleProgressPlymouthMax {
+					progress += plymouthProgressStep
+					m.emitScaleProgress(progress, true)
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(quit)
+		// 保证停止之后不会再发送进度
+		wg.Wait()
+	}
+}
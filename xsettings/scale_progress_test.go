@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	daemon "github.com/linuxdeepin/go-dbus-factory/system/org.deepin.dde.daemon1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func getEmittedProgress(e *fakeSignalEmitter) []int32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var result []int32
+	for i, name := range e.signals {
+		if name == signalSetScaleFactorProgress {
+			result = append(result, e.values[i][0].(int32))
+		}
+	}
+	return result
+}
+
+func Test_emitScaleProgress(t *testing.T) {
+	emitter := &fakeSignalEmitter{}
+	m := &XSManager{service: emitter}
+
+	// 第一个进度之前发送 SetScaleFactorStarted
+	m.emitScaleProgress(scaleProgressDsfHelper, true)
+	m.emitScaleProgress(scaleProgressSettings, true)
+	assert.Equal(t, []string{signalSetScaleFactorStarted, signalSetScaleFactorProgress,
+		signalSetScaleFactorProgress}, emitter.getSignals())
+
+	// 没有结束时开始的修改合并到同一次中，进度不会后退
+	m.emitScaleProgress(scaleProgressDsfHelper, true)
+	m.emitScaleProgress(scaleProgressEnv, true)
+	m.emitScaleDone(true)
+	assert.Equal(t, []int32{scaleProgressDsfHelper, scaleProgressSettings, scaleProgressEnv,
+		scaleProgressDone}, getEmittedProgress(emitter))
+	assert.Equal(t, signalSetScaleFactorDone, emitter.signals[len(emitter.signals)-1])
+
+	// 结束后的下一次重新开始
+	m.emitScaleProgress(scaleProgressDsfHelper, true)
+	assert.Equal(t, []string{signalSetScaleFactorStarted, signalSetScaleFactorProgress},
+		emitter.getSignals()[len(emitter.signals)-2:])
+
+	emitter = &fakeSignalEmitter{}
+	m.service = emitter
+	m.emitScaleProgress(scaleProgressSettings, false)
+	assert.Empty(t, emitter.signals)
+}
+
+func Test_setScaleFactorForPlymouthReal_progress(t *testing.T) {
+	plymouthProgressIntervalOld := plymouthProgressInterval
+	plymouthProgressInterval = 5 * time.Millisecond
+	defer func() {
+		plymouthProgressInterval = plymouthProgressIntervalOld
+	}()
+
+	mockDaemon := &daemon.MockDaemon{}
+	// 成功时会修改 gsettings，这里让调用失败
+	mockDaemon.MockInterfaceDaemon.On("ScalePlymouth", dbus.Flags(0), mock.Anything).
+		Return(errors.New("failed")).After(50 * time.Millisecond)
+	emitter := &fakeSignalEmitter{}
+	m := &XSManager{sysDaemon: mockDaemon, service: emitter}
+	m.emitScaleProgress(scaleProgressGreeter, true)
+	m.setScaleFactorForPlymouthReal(3, true)
+
+	progress := getEmittedProgress(emitter)
+	// 等待 Plymouth 期间有中间的进度
+	assert.Greater(t, len(progress), 3)
+	assert.Equal(t, int32(scaleProgressDone), progress[len(progress)-1])
+	for i := 1; i < len(progress); i++ {
+		assert.GreaterOrEqual(t, progress[i], progress[i-1], progress)
+	}
+	for _, p := range progress[:len(progress)-1] {
+		assert.LessOrEqual(t, p, int32(scaleProgressPlymouthMax))
+	}
+	assert.Equal(t, []string{signalSetScaleFactorDone, signalPlymouthScaleFailed},
+		emitter.signals[len(emitter.signals)-2:])
+
+	// 不需要发送信号时不发送进度
+	emitter = &fakeSignalEmitter{}
+	m.service = emitter
+	m.setScaleFactorForPlymouthReal(3, false)
+	assert.Empty(t, emitter.signals)
+}
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/linuxdeepin/go-lib/xdg/basedir"
+)
+
+// 开始写入缩放设置时写入标记文件，完成后删除。启动时标记文件还在，说明上次修改时异常退出，
+// qt-theme.ini、推导出的 gsettings 值、环境变量和 display 模块可能与 gsettings 中的缩放不一致，需要全部重新同步。
+func getScaleMarkerFile() string {
+	return filepath.Join(basedir.GetUserConfigDir(), "deepin/startdde/scale-in-progress")
+}
+
+func writeScaleMarker(filename string) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf("%d %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	return ioutil.WriteFile(filename, []byte(content), 0644)
+}
+
+func removeScaleMarker(filename string) {
+	err := os.Remove(filename)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warning(err)
+	}
+}
+
+// recoverInterruptedScale 存在标记文件时调用 recover，成功后删除标记文件，返回是否进行了恢复
+func recoverInterruptedScale(filename string, recover func() error) (bool, error) {
+	_, err := os.Stat(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	logger.Warning("found stale scale marker, last scale change was interrupted")
+	err = recover()
+	if err != nil {
+		return true, err
+	}
+	removeScaleMarker(filename)
+	return true, nil
+}
+
+// reapplyScaleFromGsettings 按 gsettings 中保存的缩放比例重新写入所有推导出的设置。
+// 只是让各处与已经保存的值一致，不检查锁定，也不改变缩放比例本身。
+func (m *XSManager) reapplyScaleFromGsettings() error {
+	factors := m.getScreenScaleFactorsOrSingle()
+	logger.Debug("reapplyScaleFromGsettings", factors)
+	err := m.setScreenScaleFactorsForQt(factors)
+	if err == nil {
+		err = m.qtThemeWriter.flush()
+	}
+	if err != nil {
+		return err
+	}
+	// 窗口缩放和光标大小
+	m.reassertScale()
+
+	cfg := m.getScaleConfig()
+	var wineScale string
+	if cfg.wineScaling {
+		primary, err := m.getPrimaryScreenName()
+		if err != nil {
+			logger.Warning("failed to get primary screen:", err)
+		}
+		wineScale = getWineScaleValue(factors, primary)
+	}
+	err = updateDdeScaleEnv(getDdeScaleEnvKeys(cfg.envKeepKeys), wineScale)
+	if err != nil {
+		return err
+	}
+	m.applyDsfHelper(factors)
+	return nil
+}
+
+func (m *XSManager) recoverScaleChange() {
+	_, err := recoverInterruptedScale(getScaleMarkerFile(), m.reapplyScaleFromGsettings)
+	if err != nil {
+		logger.Warning("failed to recover interrupted scale change:", err)
+	}
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/linuxdeepin/dde-api/userenv"
+	gio "github.com/linuxdeepin/go-gir/gio-2.0"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_recoverInterruptedScale(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "startdde/scale-in-progress")
+	recovered := 0
+	recover := func() error {
+		recovered++
+		return nil
+	}
+
+	// 没有标记文件
+	ok, err := recoverInterruptedScale(marker, recover)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 0, recovered)
+
+	// 上次修改中途退出，留下了标记文件
+	require.NoError(t, writeScaleMarker(marker))
+	ok, err = recoverInterruptedScale(marker, recover)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, recovered)
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err))
+
+	// 恢复失败时保留标记文件，下次启动再试
+	require.NoError(t, writeScaleMarker(marker))
+	ok, err = recoverInterruptedScale(marker, func() error {
+		return errors.New("failed")
+	})
+	assert.Error(t, err)
+	assert.True(t, ok)
+	assert.FileExists(t, marker)
+}
+
+func Test_reapplyScaleFromGsettings(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema, startddeSchema, wrapGnomeInterfaceSchema)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	envFile := filepath.Join(t.TempDir(), "dde-env")
+	getDdeEnvFileOld := getDdeEnvFile
+	gsOld := _gs
+	t.Cleanup(func() {
+		getDdeEnvFile = getDdeEnvFileOld
+		_gs = gsOld
+	})
+	getDdeEnvFile = func() string { return envFile }
+	require.NoError(t, userenv.SaveToFile(envFile, map[string]string{"QT_SCALE_FACTOR": "2"}))
+
+	helper := &fakeDsfHelper{}
+	m := &XSManager{
+		service:    &fakeSignalEmitter{},
+		gs:         gio.NewSettings(xsSchema),
+		startddeGs: gio.NewSettings(startddeSchema),
+		dsfHelper:  helper,
+		greeterAvailable: func() (bool, error) {
+			return false, nil
+		},
+	}
+	_gs = m.gs
+	m.qtThemeWriter = newQtThemeWriter(time.Hour, saveQtTheme, func(qt *qtThemeChange) error {
+		return nil
+	})
+	// 上次只写入了 gsettings 中的缩放比例就退出了
+	m.gs.SetDouble(gsKeyScaleFactor, 2)
+	m.gs.SetString(gsKeyIndividualScaling, "ALL=2.00")
+	m.gs.SetInt(gsKeyWindowScale, 1)
+	m.gs.SetInt(gsKeyGtkCursorThemeSize, 24)
+
+	require.NoError(t, m.reapplyScaleFromGsettings())
+	assert.Equal(t, int32(2), m.gs.GetInt(gsKeyWindowScale))
+	assert.Equal(t, int32(48), m.gs.GetInt(gsKeyGtkCursorThemeSize))
+	assert.Equal(t, int32(48), m.getWrapGDISettings().GetInt("cursor-size"))
+	factors, err := loadQtScreenScaleFactors(getQtThemeFile())
+	require.NoError(t, err)
+	assert.Equal(t, singleToMapSF(2), factors)
+	assert.Equal(t, singleToMapSF(2), helper.factors)
+	ue, err := userenv.LoadFromFile(envFile)
+	require.NoError(t, err)
+	assert.NotContains(t, ue, "QT_SCALE_FACTOR")
+	// 只同步，不改变缩放比例
+	assert.Equal(t, "ALL=2.00", m.gs.GetString(gsKeyIndividualScaling))
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// scaleSnapshot 设置缩放之前各处保存的值，设置失败时恢复，避免 gsettings、qt-theme.ini 和 display 模块互相不一致。
+// greeter 和 Plymouth 在所有可能失败的步骤之后才排队设置，不需要恢复。
+type scaleSnapshot struct {
+	scaleFactor       float64
+	windowScale       int32
+	cursorSize        int32
+	individualScaling string
+	// 文件名到原来的内容，值为 nil 表示文件原来不存在
+	qtFiles map[string][]byte
+	// 获取失败时为 nil，不恢复 display 模块
+	dsfFactors map[string]float64
+}
+
+func (m *XSManager) captureScaleSnapshot(c *scaleChange) *scaleSnapshot {
+	s := &scaleSnapshot{
+		scaleFactor:       m.gs.GetDouble(gsKeyScaleFactor),
+		windowScale:       m.gs.GetInt(gsKeyWindowScale),
+		cursorSize:        m.gs.GetInt(gsKeyGtkCursorThemeSize),
+		individualScaling: m.gs.GetString(gsKeyIndividualScaling),
+		qtFiles:           make(map[string][]byte),
+	}
+	for _, filename := range append([]string{c.qt.filename}, c.qt.copyFilenames...) {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			// 读取失败的文件恢复时不处理
+			logger.Warning("failed to read qt-theme.ini for rollback:", err)
+			continue
+		}
+		s.qtFiles[filename] = data
+	}
+
+	factors, err := m.dsfHelper.GetScaleFactors()
+	if err != nil {
+		logger.Warning("failed to get scale factors of display for rollback:", err)
+	} else {
+		s.dsfFactors = factors
+	}
+	return s
+}
+
+// 与写入时一样原子地恢复，恢复过程中断电也不会留下不完整的文件
+func restoreFile(filename string, data []byte) error {
+	if data == nil {
+		err := os.Remove(filename)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return saveFileAtomic(filename, data, nil)
+}
+
+// 尽量恢复所有的值，某一处恢复失败时只输出警告
+func (m *XSManager) restoreScaleSnapshot(s *scaleSnapshot, c *scaleChange) {
+	logger.Warning("roll back scale change to", s.individualScaling)
+	wrapGDI := m.getWrapGDISettings()
+	m.gs.Delay()
+	wrapGDI.Delay()
+	m.setScaleFactor(s.scaleFactor, s.windowScale, s.cursorSize)
+	m.gs.SetString(gsKeyIndividualScaling, s.individualScaling)
+	m.gs.Apply()
+	wrapGDI.Apply()
+
+	for filename, data := range s.qtFiles {
+		err := restoreFile(filename, data)
+		if err != nil {
+			logger.Warning("failed to restore qt-theme.ini:", err)
+		}
+	}
+
+	if c.dsfHelperApplied && s.dsfFactors != nil {
+		m.applyDsfHelper(s.dsfFactors)
+	}
+}
+
+// 某一步失败时恢复已经写入的值，保证各处的设置一致
+func (m *XSManager) commitScaleChangeWithRollback(c *scaleChange, emitSignal bool) error {
+	// 等待中的 qt-theme.ini 先写入，快照中是最新的内容，之后也不会覆盖本次写入的文件
+	err := m.qtThemeWriter.flush()
+	if err != nil {
+		logger.Warning("failed to write qt-theme.ini:", err)
+	}
+	snapshot := m.captureScaleSnapshot(c)
+	err = m.commitScaleChange(c, emitSignal)
+	if err != nil {
+		m.restoreScaleSnapshot(snapshot, c)
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gio "github.com/linuxdeepin/go-gir/gio-2.0"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_restoreFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "qt-theme.ini")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("new"), 0600))
+
+	require.NoError(t, restoreFile(filename, []byte("old")))
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+	// 通过临时文件 rename 到位，不留下临时文件
+	fileInfo, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), fileInfo.Mode().Perm())
+	fileInfos, err := ioutil.ReadDir(filepath.Dir(filename))
+	require.NoError(t, err)
+	assert.Len(t, fileInfos, 1)
+
+	// 原来不存在的文件删除
+	require.NoError(t, restoreFile(filename, nil))
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, restoreFile(filename, nil))
+}
+
+func Test_commitScaleChangeWithRollback(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema, wrapGnomeInterfaceSchema)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	qtThemeFile := getQtThemeFile()
+	require.NoError(t, os.MkdirAll(filepath.Dir(qtThemeFile), 0755))
+	oldContent := []byte("[Theme]\nScreenScaleFactors=1.00\n")
+	require.NoError(t, ioutil.WriteFile(qtThemeFile, oldContent, 0644))
+	// 副本所在的目录是一个普通文件，写入副本时失败
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	require.NoError(t, ioutil.WriteFile(blocker, nil, 0644))
+
+	store := &fakeDsfHelper{factors: singleToMapSF(1)}
+	m := &XSManager{
+		service:   &fakeSignalEmitter{},
+		gs:        gio.NewSettings(xsSchema),
+		dsfHelper: store,
+	}
+	m.qtThemeWriter = newQtThemeWriter(time.Hour, saveQtTheme, func(qt *qtThemeChange) error {
+		t.Error("unexpected greeter update")
+		return nil
+	})
+	m.plymouthSettler = newPlymouthSettler(func(factor int, emitSignal bool) {
+		t.Error("unexpected Plymouth scaling")
+	})
+	m.setScaleFactor(1, 1, 24)
+	m.gs.SetString(gsKeyIndividualScaling, "ALL=1.00")
+
+	c, err := prepareScaleChange(singleToMapSF(2), "", scaleConfig{cursorBaseSize: baseCursorSize})
+	require.NoError(t, err)
+	c.qt.copyFilenames = []string{filepath.Join(blocker, "qt-theme.ini")}
+	c.envKeys = nil
+	err = m.commitScaleChangeWithRollback(c, false)
+	assert.Error(t, err)
+
+	assert.Equal(t, 1.0, m.gs.GetDouble(gsKeyScaleFactor))
+	assert.Equal(t, int32(1), m.gs.GetInt(gsKeyWindowScale))
+	assert.Equal(t, int32(24), m.gs.GetInt(gsKeyGtkCursorThemeSize))
+	assert.Equal(t, int32(24), m.getWrapGDISettings().GetInt("cursor-size"))
+	assert.Equal(t, "ALL=1.00", m.gs.GetString(gsKeyIndividualScaling))
+	data, err := ioutil.ReadFile(qtThemeFile)
+	require.NoError(t, err)
+	assert.Equal(t, oldContent, data)
+	// display 模块先设置成了新的值，之后恢复
+	assert.Equal(t, []map[string]float64{singleToMapSF(2), singleToMapSF(1)}, store.history)
+}
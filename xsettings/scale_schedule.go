@@ -0,0 +1,334 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	dbus "github.com/godbus/dbus/v5"
+	login1 "github.com/linuxdeepin/go-dbus-factory/system/org.freedesktop.login1"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+	"github.com/linuxdeepin/go-lib/xdg/basedir"
+)
+
+// ScheduleEntry 从每天的 Hour:Minute 开始使用缩放比例 Factor
+type ScheduleEntry struct {
+	Hour   int32
+	Minute int32
+	Factor float64
+}
+
+func (e ScheduleEntry) minutes() int {
+	return int(e.Hour)*60 + int(e.Minute)
+}
+
+func validateScheduleEntries(entries []ScheduleEntry) error {
+	for _, e := range entries {
+		if e.Hour < 0 || e.Hour > 23 || e.Minute < 0 || e.Minute > 59 {
+			return errors.New("invalid schedule time")
+		}
+		if e.Factor <= 0 {
+			return errors.New("invalid schedule factor")
+		}
+	}
+	return nil
+}
+
+func sortScheduleEntries(entries []ScheduleEntry) []ScheduleEntry {
+	sorted := make([]ScheduleEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].minutes() < sorted[j].minutes()
+	})
+	return sorted
+}
+
+// 条目在 day 这一天生效的时间。不能用当天零点加上分钟数，夏令时切换的那天会差一个小时。
+func scheduleEntryTime(e ScheduleEntry, day time.Time, offsetDays int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day()+offsetDays,
+		int(e.Hour), int(e.Minute), 0, 0, day.Location())
+}
+
+// 计算 now 之后下一个要生效的条目和它生效的时间
+func getNextScheduleEntry(entries []ScheduleEntry, now time.Time) (ScheduleEntry, time.Time, bool) {
+	if len(entries) == 0 {
+		return ScheduleEntry{}, time.Time{}, false
+	}
+	sorted := sortScheduleEntries(entries)
+	for _, e := range sorted {
+		t := scheduleEntryTime(e, now, 0)
+		if t.After(now) {
+			return e, t, true
+		}
+	}
+	// 今天的都已经过了，使用明天的第一个
+	e := sorted[0]
+	return e, scheduleEntryTime(e, now, 1), true
+}
+
+// 计算 now 时应该生效的条目，即 now 之前最后一个生效的条目。今天还没有生效过的条目时使用前一天的最后一个。
+func getActiveScheduleEntry(entries []ScheduleEntry, now time.Time) (ScheduleEntry, bool) {
+	if len(entries) == 0 {
+		return ScheduleEntry{}, false
+	}
+	sorted := sortScheduleEntries(entries)
+	active := sorted[len(sorted)-1]
+	for _, e := range sorted {
+		if scheduleEntryTime(e, now, 0).After(now) {
+			break
+		}
+		active = e
+	}
+	return active, true
+}
+
+// 按主屏从 primaryFactor 变为 factor 的比例调整各屏幕的缩放比例，保持屏幕之间的相对关系。
+func scaleScreenFactorsTo(factors map[string]float64, primaryFactor, factor float64) map[string]float64 {
+	if len(factors) == 0 || primaryFactor <= 0 {
+		return singleToMapSF(factor)
+	}
+	ratio := factor / primaryFactor
+	result := make(map[string]float64, len(factors))
+	for name, v := range factors {
+		result[name] = math.Round(v*ratio*100) / 100
+	}
+	return result
+}
+
+func getScaleScheduleFile() string {
+	return filepath.Join(basedir.GetUserConfigDir(), "deepin/startdde/scale-schedule.json")
+}
+
+func loadScaleSchedule(filename string) ([]ScheduleEntry, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ScheduleEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return nil, err
+	}
+	err = validateScheduleEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func saveScaleSchedule(filename string, entries []ScheduleEntry) error {
+	if len(entries) == 0 {
+		err := os.Remove(filename)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// scaleScheduler 按照时间表定时设置缩放比例
+type scaleScheduler struct {
+	mu      sync.Mutex
+	entries []ScheduleEntry
+	timer   *time.Timer
+	// 最后一次应用的条目，唤醒时它仍然生效就不再应用，避免覆盖用户之后手动做的修改
+	lastApplied *ScheduleEntry
+	now         func() time.Time
+	apply       func(factor float64)
+}
+
+func newScaleScheduler(apply func(factor float64)) *scaleScheduler {
+	return &scaleScheduler{
+		now:   time.Now,
+		apply: apply,
+	}
+}
+
+func (s *scaleScheduler) setEntries(entries []ScheduleEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = entries
+	s.resetTimer()
+}
+
+func (s *scaleScheduler) getEntries() []ScheduleEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries
+}
+
+// 需要持有锁
+func (s *scaleScheduler) resetTimer() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	entry, t, ok := getNextScheduleEntry(s.entries, s.now())
+	if !ok {
+		return
+	}
+	logger.Debugf("next scale schedule at %v, factor: %v", t, entry.Factor)
+	var timer *time.Timer
+	timer = time.AfterFunc(t.Sub(s.now()), func() {
+		s.mu.Lock()
+		if s.timer != timer {
+			// 已经被 setEntries 或 reevaluate 替换
+			s.mu.Unlock()
+			return
+		}
+		s.lastApplied = &entry
+		s.resetTimer()
+		s.mu.Unlock()
+		s.apply(entry.Factor)
+	})
+	s.timer = timer
+}
+
+// skipActive 把当前生效的条目记为已经应用但不应用。
+// 启动时调用，不覆盖用户上次保存的缩放，之后唤醒时只应用睡眠期间新生效的条目。
+func (s *scaleScheduler) skipActive() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := getActiveScheduleEntry(s.entries, s.now())
+	if ok {
+		s.lastApplied = &entry
+	}
+}
+
+// reevaluate 按当前时间应用应该生效的条目并重新计时。
+// 从睡眠中唤醒时调用，睡眠期间错过的条目在这里生效。
+func (s *scaleScheduler) reevaluate() {
+	s.mu.Lock()
+	entry, ok := getActiveScheduleEntry(s.entries, s.now())
+	if ok && s.lastApplied != nil && *s.lastApplied == entry {
+		ok = false
+	}
+	if ok {
+		s.lastApplied = &entry
+	}
+	s.resetTimer()
+	s.mu.Unlock()
+	if ok {
+		s.apply(entry.Factor)
+	}
+}
+
+func (m *XSManager) initScaleSchedule() {
+	m.scaleScheduler = newScaleScheduler(func(factor float64) {
+		primaryFactor := m.getPrimaryScreenScaleFactor()
+		if primaryFactor == factor {
+			return
+		}
+		logger.Debug("apply scheduled scale factor:", factor)
+		factors := scaleScreenFactorsTo(m.getScreenScaleFactors(), primaryFactor, factor)
+		err := m.setScreenScaleFactors(factors, true)
+		if err != nil {
+			logger.Warning("failed to apply scheduled scale factor:", err)
+		}
+	})
+	entries, err := loadScaleSchedule(getScaleScheduleFile())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warning("failed to load scale schedule:", err)
+		}
+		return
+	}
+	m.scaleScheduler.setEntries(entries)
+	m.scaleScheduler.skipActive()
+	m.updateScheduleResumeMonitor()
+}
+
+// scheduleResumeMonitor 从睡眠中唤醒后重新按当前时间应用时间表，睡眠时定时器不会触发。
+// 只在有时间表时监听 login1 的信号。
+type scheduleResumeMonitor struct {
+	mu       sync.Mutex
+	conn     *dbus.Conn
+	resume   func()
+	sigLoop  *dbusutil.SignalLoop
+	loginObj login1.Manager
+}
+
+func newScheduleResumeMonitor(conn *dbus.Conn, resume func()) *scheduleResumeMonitor {
+	return &scheduleResumeMonitor{
+		conn:   conn,
+		resume: resume,
+	}
+}
+
+func (r *scheduleResumeMonitor) start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sigLoop != nil {
+		return
+	}
+	r.loginObj = login1.NewManager(r.conn)
+	r.sigLoop = dbusutil.NewSignalLoop(r.conn, 10)
+	r.sigLoop.Start()
+	r.loginObj.InitSignalExt(r.sigLoop, true)
+	_, err := r.loginObj.ConnectPrepareForSleep(func(isSleep bool) {
+		if isSleep {
+			return
+		}
+		logger.Debug("wakeup from sleep, reevaluate scale schedule")
+		r.resume()
+	})
+	if err != nil {
+		logger.Warning("failed to connect signal PrepareForSleep:", err)
+	}
+}
+
+func (r *scheduleResumeMonitor) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sigLoop == nil {
+		return
+	}
+	r.loginObj.RemoveAllHandlers()
+	r.sigLoop.Stop()
+	r.loginObj = nil
+	r.sigLoop = nil
+}
+
+func (m *XSManager) updateScheduleResumeMonitor() {
+	if m.scheduleResume == nil {
+		return
+	}
+	if len(m.scaleScheduler.getEntries()) > 0 {
+		m.scheduleResume.start()
+	} else {
+		m.scheduleResume.stop()
+	}
+}
+
+func (m *XSManager) setScaleSchedule(entries []ScheduleEntry) error {
+	err := validateScheduleEntries(entries)
+	if err != nil {
+		return err
+	}
+	err = saveScaleSchedule(getScaleScheduleFile(), entries)
+	if err != nil {
+		return err
+	}
+	m.scaleScheduler.setEntries(entries)
+	m.updateScheduleResumeMonitor()
+	return nil
+}
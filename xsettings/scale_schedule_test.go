@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getNextScheduleEntry(t *testing.T) {
+	entries := []ScheduleEntry{
+		{Hour: 20, Minute: 0, Factor: 1.5},
+		{Hour: 8, Minute: 30, Factor: 1},
+	}
+	day := func(d, h, m int) time.Time {
+		return time.Date(2023, 5, d, h, m, 0, 0, time.Local)
+	}
+
+	e, at, ok := getNextScheduleEntry(entries, day(1, 12, 0))
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, e.Factor)
+	assert.Equal(t, day(1, 20, 0), at)
+
+	e, at, ok = getNextScheduleEntry(entries, day(1, 21, 0))
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, e.Factor)
+	assert.Equal(t, day(2, 8, 30), at)
+
+	_, _, ok = getNextScheduleEntry(nil, day(1, 21, 0))
+	assert.False(t, ok)
+}
+
+func Test_getNextScheduleEntry_dst(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	// 2023-03-12 02:00 开始夏令时
+	entries := []ScheduleEntry{{Hour: 20, Minute: 0, Factor: 1.5}}
+	_, at, ok := getNextScheduleEntry(entries, time.Date(2023, 3, 12, 1, 0, 0, 0, loc))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2023, 3, 12, 20, 0, 0, 0, loc), at)
+	assert.Equal(t, 20, at.Hour())
+}
+
+func Test_getActiveScheduleEntry(t *testing.T) {
+	entries := []ScheduleEntry{
+		{Hour: 20, Minute: 0, Factor: 1.5},
+		{Hour: 8, Minute: 30, Factor: 1},
+	}
+	day := func(h, m int) time.Time {
+		return time.Date(2023, 5, 1, h, m, 0, 0, time.Local)
+	}
+
+	e, ok := getActiveScheduleEntry(entries, day(12, 0))
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, e.Factor)
+
+	e, _ = getActiveScheduleEntry(entries, day(20, 0))
+	assert.Equal(t, 1.5, e.Factor)
+
+	// 今天还没有条目生效，使用前一天的最后一个
+	e, _ = getActiveScheduleEntry(entries, day(7, 0))
+	assert.Equal(t, 1.5, e.Factor)
+
+	_, ok = getActiveScheduleEntry(nil, day(7, 0))
+	assert.False(t, ok)
+}
+
+func Test_scaleScreenFactorsTo(t *testing.T) {
+	assert.Equal(t, map[string]float64{"HDMI-1": 1.5, "eDP-1": 1.88},
+		scaleScreenFactorsTo(map[string]float64{"HDMI-1": 1, "eDP-1": 1.25}, 1, 1.5))
+	assert.Equal(t, map[string]float64{"HDMI-1": 1, "eDP-1": 1.25},
+		scaleScreenFactorsTo(map[string]float64{"HDMI-1": 2, "eDP-1": 2.5}, 2, 1))
+	assert.Equal(t, map[string]float64{"ALL": 1.5}, scaleScreenFactorsTo(nil, 1, 1.5))
+}
+
+func Test_scaleScheduler_reevaluate(t *testing.T) {
+	var applied []float64
+	s := newScaleScheduler(func(factor float64) {
+		applied = append(applied, factor)
+	})
+	now := time.Date(2023, 5, 1, 7, 0, 0, 0, time.Local)
+	s.now = func() time.Time {
+		return now
+	}
+	s.setEntries([]ScheduleEntry{
+		{Hour: 20, Minute: 0, Factor: 1.5},
+		{Hour: 8, Minute: 30, Factor: 1},
+	})
+	defer s.setEntries(nil)
+
+	s.reevaluate()
+	assert.Equal(t, []float64{1.5}, applied)
+
+	// 仍然是同一个条目时不再应用
+	s.reevaluate()
+	assert.Equal(t, []float64{1.5}, applied)
+
+	// 睡眠期间错过了 8:30 的条目
+	now = time.Date(2023, 5, 1, 12, 0, 0, 0, time.Local)
+	s.reevaluate()
+	assert.Equal(t, []float64{1.5, 1}, applied)
+}
+
+func Test_scaleScheduler_skipActive(t *testing.T) {
+	var applied []float64
+	s := newScaleScheduler(func(factor float64) {
+		applied = append(applied, factor)
+	})
+	now := time.Date(2023, 5, 1, 21, 0, 0, 0, time.Local)
+	s.now = func() time.Time {
+		return now
+	}
+	s.setEntries([]ScheduleEntry{
+		{Hour: 20, Minute: 0, Factor: 1.5},
+		{Hour: 8, Minute: 30, Factor: 1},
+	})
+	defer s.setEntries(nil)
+
+	// 启动时不应用，唤醒时仍然是同一个条目也不应用
+	s.skipActive()
+	s.reevaluate()
+	assert.Empty(t, applied)
+
+	// 睡眠期间 8:30 的条目开始生效
+	now = time.Date(2023, 5, 2, 9, 0, 0, 0, time.Local)
+	s.reevaluate()
+	assert.Equal(t, []float64{1}, applied)
+}
+
+func Test_scaleScheduler(t *testing.T) {
+	applied := make(chan float64, 1)
+	s := newScaleScheduler(func(factor float64) {
+		applied <- factor
+	})
+	s.now = func() time.Time {
+		return time.Date(2023, 5, 1, 19, 59, 59, int(950*time.Millisecond), time.Local)
+	}
+	s.setEntries([]ScheduleEntry{{Hour: 20, Minute: 0, Factor: 1.5}})
+
+	select {
+	case factor := <-applied:
+		assert.Equal(t, 1.5, factor)
+	case <-time.After(time.Second):
+		t.Fatal("schedule not fired")
+	}
+	s.setEntries(nil)
+}
+
+func Test_saveScaleSchedule(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "scale-schedule.json")
+	entries := []ScheduleEntry{{Hour: 20, Minute: 0, Factor: 1.5}}
+	err := saveScaleSchedule(filename, entries)
+	require.NoError(t, err)
+
+	got, err := loadScaleSchedule(filename)
+	require.NoError(t, err)
+	assert.Equal(t, entries, got)
+
+	err = saveScaleSchedule(filename, nil)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filename)
+
+	assert.Error(t, validateScheduleEntries([]ScheduleEntry{{Hour: 24, Factor: 1}}))
+	assert.Error(t, validateScheduleEntries([]ScheduleEntry{{Hour: 1, Factor: 0}}))
+}
@@ -5,9 +5,31 @@
 package xsettings
 
 import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/linuxdeepin/dde-api/userenv"
+	daemon "github.com/linuxdeepin/go-dbus-factory/system/org.deepin.dde.daemon1"
+	greeter "github.com/linuxdeepin/go-dbus-factory/system/org.deepin.dde.greeter1"
+	gio "github.com/linuxdeepin/go-gir/gio-2.0"
+	"github.com/linuxdeepin/go-lib/dbusutil"
+	"github.com/linuxdeepin/go-lib/keyfile"
+	"github.com/linuxdeepin/go-lib/strv"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_getPlymouthTheme(t *testing.T) {
@@ -74,11 +96,18 @@ func Test_getPlymouthThemeScaleFactor(t *testing.T) {
 			want: 2,
 		},
 		{
-			name: "getPlymouthThemeScaleFactor not found",
+			name: "getPlymouthThemeScaleFactor hidpi heuristic",
 			args: args{
 				theme: "depin-hidpi-logo",
 			},
-			want: 0,
+			want: 2,
+		},
+		{
+			name: "getPlymouthThemeScaleFactor not found",
+			args: args{
+				theme: "spinner",
+			},
+			want: 1,
 		},
 	}
 	for _, tt := range tests {
@@ -88,3 +117,2047 @@ func Test_getPlymouthThemeScaleFactor(t *testing.T) {
 		})
 	}
 }
+
+func Test_prepareScaleChange(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	qtThemeFile := getQtThemeFile()
+	err := os.MkdirAll(filepath.Dir(qtThemeFile), 0755)
+	require.NoError(t, err)
+	oldContent := []byte("[Theme]\nScreenScaleFactors=1.00\n")
+	err = ioutil.WriteFile(qtThemeFile, oldContent, 0644)
+	require.NoError(t, err)
+
+	cfg := scaleConfig{cursorBaseSize: baseCursorSize}
+
+	assertUntouched := func(t *testing.T) {
+		content, err := ioutil.ReadFile(qtThemeFile)
+		require.NoError(t, err)
+		assert.Equal(t, oldContent, content)
+		fileInfos, err := ioutil.ReadDir(filepath.Dir(qtThemeFile))
+		require.NoError(t, err)
+		assert.Len(t, fileInfos, 1)
+	}
+
+	t.Run("single factor follows primary", func(t *testing.T) {
+		// 多项且没有 ALL 时按主屏得到单值
+		c, err := prepareScaleChange(map[string]float64{"HDMI-1": 1, "eDP-1": 2}, "eDP-1", cfg)
+		require.NoError(t, err)
+		assert.Equal(t, 2.0, c.singleFactor)
+		assert.Equal(t, int32(2), c.windowScale)
+		assert.Equal(t, int32(48), c.cursorSize)
+
+		c, err = prepareScaleChange(map[string]float64{"HDMI-1": 1, "eDP-1": 2}, "", cfg)
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, c.singleFactor)
+		assertUntouched(t)
+	})
+
+	t.Run("invalid factors", func(t *testing.T) {
+		_, err := prepareScaleChange(map[string]float64{"HDMI-1": 1.5, "eDP-1": -1}, "", cfg)
+		assert.Error(t, err)
+		assertUntouched(t)
+	})
+
+	t.Run("empty factors", func(t *testing.T) {
+		_, err := prepareScaleChange(map[string]float64{}, "", cfg)
+		assert.Error(t, err)
+		assertUntouched(t)
+	})
+
+	t.Run("prepare only", func(t *testing.T) {
+		c, err := prepareScaleChange(map[string]float64{"ALL": 1.75}, "", cfg)
+		require.NoError(t, err)
+		assert.Equal(t, 1.75, c.singleFactor)
+		assert.Equal(t, int32(2), c.windowScale)
+		assert.Equal(t, int32(42), c.cursorSize)
+		assertUntouched(t)
+	})
+
+	t.Run("clamp", func(t *testing.T) {
+		clampCfg := cfg
+		clampCfg.minFactor, clampCfg.maxFactor = defaultMinScaleFactor, defaultMaxScaleFactor
+		c, err := prepareScaleChange(map[string]float64{"ALL": 8}, "", clampCfg)
+		require.NoError(t, err)
+		assert.Equal(t, 3.0, c.singleFactor)
+		assert.Equal(t, int32(3), c.windowScale)
+		assert.Equal(t, int32(72), c.cursorSize)
+		assert.Equal(t, "3.00", c.qt.value)
+		assertUntouched(t)
+	})
+
+	t.Run("save", func(t *testing.T) {
+		c, err := prepareScaleChange(map[string]float64{"ALL": 1.25}, "", cfg)
+		require.NoError(t, err)
+		err = c.qt.save()
+		require.NoError(t, err)
+
+		kf := keyfile.NewKeyFile()
+		err = kf.LoadFromFile(qtThemeFile)
+		require.NoError(t, err)
+		value, err := kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+		require.NoError(t, err)
+		assert.Equal(t, "1.25", value)
+	})
+
+	t.Run("save sandbox copy", func(t *testing.T) {
+		dataDir := t.TempDir()
+		t.Setenv("XDG_DATA_HOME", dataDir)
+		sandboxCfg := cfg
+		sandboxCfg.qtThemeSandboxCopy = true
+
+		c, err := prepareScaleChange(map[string]float64{"ALL": 1.5}, "", sandboxCfg)
+		require.NoError(t, err)
+		err = c.qt.save()
+		require.NoError(t, err)
+
+		for _, filename := range []string{qtThemeFile, filepath.Join(dataDir, "deepin/qt-theme.ini")} {
+			kf := keyfile.NewKeyFile()
+			err = kf.LoadFromFile(filename)
+			require.NoError(t, err)
+			value, err := kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+			require.NoError(t, err)
+			assert.Equal(t, "1.50", value)
+		}
+	})
+}
+
+func Test_checkScreenFactorsNotEmpty(t *testing.T) {
+	tests := []struct {
+		name    string
+		factors map[string]float64
+		wantErr error
+	}{
+		{"nil", nil, ErrScaleFactorsEmpty},
+		{"empty", map[string]float64{}, ErrScaleFactorsEmpty},
+		{"blank key", map[string]float64{"": 2}, ErrScaleFactorsEmpty},
+		{"space keys", map[string]float64{" ": 2, "\t": 1}, ErrScaleFactorsEmpty},
+		{"one valid key", map[string]float64{"": 2, "eDP-1": 1}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantErr, checkScreenFactorsNotEmpty(tt.factors))
+		})
+	}
+
+	cfg := scaleConfig{cursorBaseSize: baseCursorSize}
+	for _, factors := range []map[string]float64{nil, {}, {"": 2}} {
+		_, err := prepareScaleChange(factors, "", cfg)
+		assert.Equal(t, ErrScaleFactorsEmpty, err)
+		assert.Equal(t, ErrScaleFactorsEmpty, checkScreenScaleFactors(factors))
+	}
+	// 空的检查先于数值检查
+	_, err := prepareScaleChange(map[string]float64{"": -1}, "", cfg)
+	assert.Equal(t, ErrScaleFactorsEmpty, err)
+}
+
+func Test_deriveCursorBaseSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		cursorSize int32
+		setScale   float64
+		newScale   float64
+		want       int32
+	}{
+		{
+			name:       "override at scale 1",
+			cursorSize: 32,
+			setScale:   1,
+			newScale:   2,
+			want:       64,
+		},
+		{
+			name:       "override at scale 1.5",
+			cursorSize: 48,
+			setScale:   1.5,
+			newScale:   1,
+			want:       32,
+		},
+		{
+			name:       "override survives same scale",
+			cursorSize: 40,
+			setScale:   1.25,
+			newScale:   1.25,
+			want:       40,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseSize := deriveCursorBaseSize(tt.cursorSize, tt.setScale)
+			got := deriveCursorSize(baseSize, tt.newScale)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_deriveCursorSize(t *testing.T) {
+	tests := []struct {
+		baseSize int32
+		scale    float64
+		want     int32
+	}{
+		{24, 1, 24},
+		{24, 1.25, 30},
+		{24, 1.5, 36},
+		{24, 1.75, 42},
+		{24, 1.9, 46},
+		{24, 2, 48},
+		{24, 2.75, 66},
+		{32, 1.25, 40},
+		{32, 1.3, 42},
+		{20, 1.15, 23},
+		{48, 0.5, 24},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, deriveCursorSize(tt.baseSize, tt.scale), "%d * %v", tt.baseSize, tt.scale)
+	}
+}
+
+func Test_loadUserQtTheme(t *testing.T) {
+	writeQtTheme := func(homeDir, content string) {
+		filename := getUserQtThemeFile(homeDir)
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		require.NoError(t, err)
+		err = ioutil.WriteFile(filename, []byte(content), 0644)
+		require.NoError(t, err)
+	}
+
+	homeA := t.TempDir()
+	homeB := t.TempDir()
+	writeQtTheme(homeA, "[Theme]\nScreenScaleFactors=1.25\n")
+	writeQtTheme(homeB, "[Theme]\nScreenScaleFactors=\"eDP-1=2.00;HDMI-1=1.00\"\n")
+
+	kf, err := loadUserQtTheme(homeA)
+	require.NoError(t, err)
+	value, _ := kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+	assert.Equal(t, "1.25", value)
+
+	kf, err = loadUserQtTheme(homeB)
+	require.NoError(t, err)
+	value, _ = kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+	assert.Equal(t, `"eDP-1=2.00;HDMI-1=1.00"`, value)
+
+	homeC := t.TempDir()
+	_, err = loadUserQtTheme(homeC)
+	assert.Error(t, err)
+	writeQtTheme(homeC, "[Theme]\nIconThemeName=bloom\n")
+	_, err = loadUserQtTheme(homeC)
+	assert.Error(t, err)
+}
+
+func Test_qtThemeWriter(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	qtThemeFile := getQtThemeFile()
+
+	var mu sync.Mutex
+	var saved, pushed []*qtThemeChange
+	save := func(qt *qtThemeChange) error {
+		mu.Lock()
+		saved = append(saved, qt)
+		mu.Unlock()
+		return saveQtTheme(qt)
+	}
+	w := newQtThemeWriter(50*time.Millisecond, save, func(qt *qtThemeChange) error {
+		mu.Lock()
+		pushed = append(pushed, qt)
+		mu.Unlock()
+		return nil
+	})
+
+	// 拖动滑块，文件只写入一次，内容为最后的值
+	var last *qtThemeChange
+	for i := 0; i < 20; i++ {
+		qt, err := prepareQtTheme(singleToMapSF(1+float64(i)/10), scaleConfig{})
+		require.NoError(t, err)
+		last = qt
+		w.schedule(qt)
+	}
+	_, err := os.Stat(qtThemeFile)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(pushed) > 0
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []*qtThemeChange{last}, saved)
+	assert.Equal(t, []*qtThemeChange{last}, pushed)
+	saved, pushed = nil, nil
+	mu.Unlock()
+	factors, err := loadQtScreenScaleFactors(qtThemeFile)
+	require.NoError(t, err)
+	assert.Equal(t, singleToMapSF(2.9), factors)
+
+	// flush 立即写入
+	last = &qtThemeChange{value: "flush"}
+	w.scheduleGreeter(last)
+	assert.NoError(t, w.flush())
+	mu.Lock()
+	// 文件已经写入过，只同步 greeter
+	assert.Empty(t, saved)
+	assert.Equal(t, []*qtThemeChange{last}, pushed)
+	pushed = nil
+	mu.Unlock()
+
+	// 写入失败时返回错误，不同步 greeter
+	w.save = func(qt *qtThemeChange) error {
+		return errors.New("disk full")
+	}
+	w.schedule(last)
+	assert.Error(t, w.flush())
+	assert.Empty(t, pushed)
+}
+
+func Test_deriveWindowScale(t *testing.T) {
+	// 0.5 到 3.0 之间每隔 0.01 检查一次，边界为 1.7 和 2.7
+	for i := 50; i <= 300; i++ {
+		scale := float64(i) / 100
+		want := int32(1)
+		if i >= 270 {
+			want = 3
+		} else if i >= 170 {
+			want = 2
+		}
+		assert.Equal(t, want, deriveWindowScale(scale), "scale %v", scale)
+	}
+	tests := []struct {
+		scale float64
+		want  int32
+	}{
+		{0.1, 1},
+		{1.69, 1},
+		{1.7, 2},
+		{2.69, 2},
+		{2.7, 3},
+		{3.5, 3},
+		{3.7, 4},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, deriveWindowScale(tt.scale), "scale %v", tt.scale)
+	}
+	assert.Equal(t, "window scale of scale factor 1.7: trunc((1.7+0.3)*10)/10 = 2, at least 1, got 2",
+		explainWindowScale(1.7))
+}
+
+func Test_checkWindowScale(t *testing.T) {
+	tests := []struct {
+		scale       float64
+		windowScale int32
+		want        int32
+		wantOk      bool
+	}{
+		{scale: 1, windowScale: 1, want: 1, wantOk: true},
+		{scale: 2, windowScale: 1, want: 2, wantOk: false},
+		{scale: 1.75, windowScale: 1, want: 2, wantOk: false},
+		{scale: 1.25, windowScale: 2, want: 1, wantOk: false},
+		{scale: 0.5, windowScale: 1, want: 1, wantOk: true},
+	}
+	for _, tt := range tests {
+		got, ok := checkWindowScale(tt.scale, tt.windowScale)
+		assert.Equal(t, tt.want, got, "scale %v", tt.scale)
+		assert.Equal(t, tt.wantOk, ok, "scale %v", tt.scale)
+	}
+}
+
+func Test_getManagedScaleFiles(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+
+	files := getManagedScaleFiles(scaleConfig{})
+	assert.Contains(t, files, filepath.Join(configDir, "deepin/qt-theme.ini"))
+	assert.Contains(t, files, getScaleScheduleFile())
+	assert.NotContains(t, files, getSandboxQtThemeFile())
+
+	files = getManagedScaleFiles(scaleConfig{qtThemeSandboxCopy: true, xresourcesFileDpi: true})
+	assert.Contains(t, files, getSandboxQtThemeFile())
+	assert.Contains(t, files, getXResourcesFile())
+}
+
+func Test_getManagedScaleFiles_complete(t *testing.T) {
+	configDir := t.TempDir()
+	dataDir := t.TempDir()
+	homeDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("XDG_DATA_HOME", dataDir)
+	t.Setenv("HOME", homeDir)
+	oldGetDdeEnvFile := getDdeEnvFile
+	getDdeEnvFile = func() string {
+		return filepath.Join(homeDir, ".dde_env")
+	}
+	defer func() {
+		getDdeEnvFile = oldGetDdeEnvFile
+	}()
+
+	// 按缩放流程中的写入方式写入所有文件
+	qtTheme := []byte("[Theme]\nScreenScaleFactors=1.25\n")
+	require.NoError(t, saveFileAtomic(getQtThemeFile(), qtTheme, nil))
+	require.NoError(t, backupFile(getQtThemeFile()))
+	require.NoError(t, saveFileAtomic(getSandboxQtThemeFile(), qtTheme, nil))
+	require.NoError(t, saveScaleSchedule(getScaleScheduleFile(),
+		[]ScheduleEntry{{Hour: 20, Minute: 0, Factor: 1.5}}))
+	require.NoError(t, writeScaleMarker(getScaleMarkerFile()))
+	_, err := updateScaleChangeTime(getScaleChangeTimeFile(), nil,
+		map[string]float64{"ALL": 1.25}, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, updateDdeScaleEnv(ddeScaleEnvKeys, "1.25"))
+	require.NoError(t, mergeXResourcesFile(getXResourcesFile(), "Xft.dpi", "120"))
+
+	files := getManagedScaleFiles(scaleConfig{qtThemeSandboxCopy: true, xresourcesFileDpi: true})
+	for _, dir := range []string{configDir, dataDir, homeDir} {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			assert.Contains(t, files, path)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+}
+
+func Test_prepareQtTheme_corrupted(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	qtThemeFile := getQtThemeFile()
+	err := os.MkdirAll(filepath.Dir(qtThemeFile), 0755)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(qtThemeFile, []byte(`[Theme]
+IconThemeName=bloom
+ScreenScaleFactors=eDP-1=;;=abc
+ScaleFactor=3
+ScaleLogicalDpi=96,96
+`), 0644)
+	require.NoError(t, err)
+
+	qt, err := prepareQtTheme(map[string]float64{"eDP-1": 1.25, "HDMI-1": 1}, scaleConfig{})
+	require.NoError(t, err)
+	err = qt.save()
+	require.NoError(t, err)
+
+	kf := keyfile.NewKeyFile()
+	err = kf.LoadFromFile(qtThemeFile)
+	require.NoError(t, err)
+	value, err := kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+	require.NoError(t, err)
+	value, err = strconv.Unquote(value)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"eDP-1": 1.25, "HDMI-1": 1}, parseScreenFactors(value))
+	_, err = kf.GetValue(qtThemeSection, qtThemeKeyScaleFactor)
+	assert.Error(t, err)
+	value, err = kf.GetValue(qtThemeSection, qtThemeKeyScaleLogicalDpi)
+	require.NoError(t, err)
+	assert.Equal(t, "-1,-1", value)
+	// 其他的设置保持不变
+	value, err = kf.GetValue(qtThemeSection, "IconThemeName")
+	require.NoError(t, err)
+	assert.Equal(t, "bloom", value)
+}
+
+func Test_percentToScaleFactor(t *testing.T) {
+	tests := []struct {
+		percent int32
+		want    float64
+		wantErr bool
+	}{
+		{percent: 100, want: 1},
+		{percent: 150, want: 1.5},
+		{percent: 300, want: 3},
+		{percent: 50, want: 0.5},
+		{percent: 25, wantErr: true},
+		{percent: 400, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(int(tt.percent)), func(t *testing.T) {
+			got, err := percentToScaleFactor(tt.percent, defaultMinScaleFactor, defaultMaxScaleFactor)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_diffQtThemeSection(t *testing.T) {
+	oldKf := keyfile.NewKeyFile()
+	err := oldKf.LoadFromData([]byte(`[Theme]
+IconThemeName=bloom
+ScaleFactor=2
+ScreenScaleFactors=1.00
+`))
+	require.NoError(t, err)
+	newKf := keyfile.NewKeyFile()
+	err = newKf.LoadFromData([]byte(`[Theme]
+IconThemeName=bloom
+ScaleLogicalDpi=-1,-1
+ScreenScaleFactors=1.25
+`))
+	require.NoError(t, err)
+
+	diff := diffQtThemeSection(getQtThemeSection(oldKf), getQtThemeSection(newKf))
+	assert.Equal(t, []string{
+		"-ScaleFactor=2",
+		"+ScaleLogicalDpi=-1,-1",
+		"ScreenScaleFactors: 1.00 -> 1.25",
+	}, diff)
+
+	assert.Empty(t, diffQtThemeSection(getQtThemeSection(newKf), getQtThemeSection(newKf)))
+	assert.Equal(t, []string{"+ScreenScaleFactors=1.25"},
+		diffQtThemeSection(nil, map[string]string{"ScreenScaleFactors": "1.25"}))
+}
+
+func Test_findLeftoverScaleEnv(t *testing.T) {
+	env := map[string]string{
+		"QT_SCALE_FACTOR":  "2",
+		EnvDeepinWineScale: "2.00",
+		"GDK_SCALE":        "2",
+		"QT_QPA_PLATFORM":  "xcb",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	assert.Equal(t, []string{"QT_SCALE_FACTOR", EnvDeepinWineScale}, findLeftoverScaleEnv(lookupEnv))
+
+	t.Setenv("QT_FONT_DPI", "192")
+	assert.Contains(t, findLeftoverScaleEnv(os.LookupEnv), "QT_FONT_DPI")
+}
+
+func Test_applyScaleBarrier(t *testing.T) {
+	var calls []string
+	saveQt := func(err error) func() error {
+		return func() error {
+			calls = append(calls, "qt")
+			return err
+		}
+	}
+	applyGtk := func() { calls = append(calls, "apply") }
+	revertGtk := func() { calls = append(calls, "revert") }
+
+	err := applyScaleBarrier(saveQt(nil), applyGtk, revertGtk)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"qt", "apply"}, calls)
+
+	calls = nil
+	err = applyScaleBarrier(saveQt(errors.New("disk full")), applyGtk, revertGtk)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"qt", "revert"}, calls)
+}
+
+func Test_getScaleRatioString(t *testing.T) {
+	tests := []struct {
+		scale float64
+		want  string
+	}{
+		{scale: 1, want: "1:1"},
+		{scale: 1.25, want: "5:4"},
+		{scale: 1.5, want: "3:2"},
+		{scale: 1.75, want: "7:4"},
+		{scale: 2, want: "2:1"},
+		{scale: 1.13, want: "1.13"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, getScaleRatioString(tt.scale), tt.scale)
+	}
+}
+
+func Test_deriveScaleValues(t *testing.T) {
+	// 模拟服务重启，gsettings 中已有的值与 scale-factor 不一致
+	cfg := scaleConfig{cursorBaseSize: baseCursorSize}
+	stale := scaleDerivedValues{windowScale: 1, cursorSize: 24}
+	want := deriveScaleValues(2, nil, cfg)
+	assert.Equal(t, scaleDerivedValues{windowScale: 2, cursorSize: 48}, want)
+	assert.NotEqual(t, stale, want)
+
+	// 值一致时重新设置结果不变
+	assert.Equal(t, want, deriveScaleValues(2, nil, cfg))
+	assert.Equal(t, scaleDerivedValues{windowScale: 1, cursorSize: 30},
+		deriveScaleValues(1.25, nil, cfg))
+	assert.Equal(t, scaleDerivedValues{windowScale: 1, cursorSize: 40},
+		deriveScaleValues(1.25, nil, scaleConfig{cursorBaseSize: 32}))
+}
+
+func Test_getCursorScaleFactor(t *testing.T) {
+	factors := map[string]float64{"eDP-1": 2, "HDMI-1": 1}
+	cfg := scaleConfig{cursorBaseSize: baseCursorSize}
+	// 主屏是 HDMI-1，单值为 1
+	assert.Equal(t, 1.0, getCursorScaleFactor(factors, 1, cfg))
+	assert.Equal(t, int32(24), deriveScaleValues(1, factors, cfg).cursorSize)
+
+	cfg.cursorMaxFactor = true
+	assert.Equal(t, 2.0, getCursorScaleFactor(factors, 1, cfg))
+	derived := deriveScaleValues(1, factors, cfg)
+	assert.Equal(t, scaleDerivedValues{windowScale: 1, cursorSize: 48}, derived)
+}
+
+func Test_checkScaleLocked(t *testing.T) {
+	assert.NoError(t, checkScaleLocked(false, false))
+	assert.True(t, errors.Is(checkScaleLocked(true, false), ErrScaleLocked))
+	// 管理员设置了环境变量
+	assert.NoError(t, checkScaleLocked(true, true))
+}
+
+func Test_setScaleFactorLocked(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema, startddeSchema)
+
+	m := &XSManager{
+		gs:         gio.NewSettings(xsSchema),
+		startddeGs: gio.NewSettings(startddeSchema),
+	}
+	require.NoError(t, m.setScaleFactorLocked(true))
+	// 锁定后普通用户不能解锁，也不能通过其他入口修改
+	assert.True(t, errors.Is(m.setScaleFactorLocked(false), ErrScaleLocked))
+	assert.True(t, m.startddeGs.GetBoolean(gsKeyScaleLocked))
+	assert.True(t, errors.Is(m.resetScaleFactor(), ErrScaleLocked))
+	assert.True(t, errors.Is(m.setGreeterScaleFactor(1.5), ErrScaleLocked))
+	assert.True(t, errors.Is(m.setGtkCursorThemeSize(48), ErrScaleLocked))
+	assert.True(t, errors.Is(m.importScaleConfig("/nonexistent"), ErrScaleLocked))
+	assert.True(t, errors.Is(m.resetCursorSizeToScale(), ErrScaleLocked))
+	assert.True(t, errors.Is(m.repairWindowScale(), ErrScaleLocked))
+
+	// 管理员设置了环境变量时可以解锁
+	m.scaleLockOverride = true
+	require.NoError(t, m.setScaleFactorLocked(false))
+	assert.False(t, m.startddeGs.GetBoolean(gsKeyScaleLocked))
+}
+
+func Test_setGreeterScaleFactor(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, startddeSchema)
+	mockGreeter := &greeter.MockGreeter{}
+	var greeterData []byte
+	mockGreeter.MockInterfaceGreeter.On("UpdateGreeterQtTheme", dbus.Flags(0), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		fd := args.Get(1).(dbus.UnixFD)
+		buf := make([]byte, 4096)
+		n, err := syscall.Pread(int(fd), buf, 0)
+		require.NoError(t, err)
+		greeterData = buf[:n]
+	})
+	// gs 为 nil，修改会话设置会导致 panic
+	m := &XSManager{greeter: mockGreeter, startddeGs: gio.NewSettings(startddeSchema)}
+	m.qtThemeWriter = newQtThemeWriter(time.Hour, func(qt *qtThemeChange) error {
+		t.Error("unexpected qt theme write")
+		return nil
+	}, func(qt *qtThemeChange) error {
+		t.Error("unexpected greeter update")
+		return nil
+	})
+
+	err := m.setGreeterScaleFactor(1.5)
+	require.NoError(t, err)
+	mockGreeter.MockInterfaceGreeter.AssertNumberOfCalls(t, "UpdateGreeterQtTheme", 1)
+
+	kf := keyfile.NewKeyFile()
+	require.NoError(t, kf.LoadFromData(greeterData))
+	value, err := kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+	require.NoError(t, err)
+	assert.Equal(t, "1.50", value)
+
+	err = m.setGreeterScaleFactor(0.25)
+	assert.Error(t, err)
+	mockGreeter.MockInterfaceGreeter.AssertNumberOfCalls(t, "UpdateGreeterQtTheme", 1)
+}
+
+func Test_listScaleSignals(t *testing.T) {
+	signals := listScaleSignals()
+	assert.Contains(t, signals, signalSetScaleFactorStarted)
+	assert.Contains(t, signals, signalSetScaleFactorDone)
+	assert.Contains(t, signals, signalScaleFactorChanged)
+}
+
+func Test_emitScaleFactorChanged(t *testing.T) {
+	emitter := &fakeSignalEmitter{}
+	m := &XSManager{service: emitter}
+	m.emitScaleFactorChanged(map[string]float64{"eDP-1": 1, "HDMI-1": 1},
+		map[string]float64{"eDP-1": 1.5, "HDMI-1": 1}, true)
+	assert.Equal(t, []string{signalScaleFactorChanged}, emitter.signals)
+	assert.Equal(t, []interface{}{"HDMI-1=1.00;eDP-1=1.00", "HDMI-1=1.00;eDP-1=1.50"}, emitter.values[0])
+
+	// 没有变化或者不需要发送信号
+	m.emitScaleFactorChanged(singleToMapSF(2), singleToMapSF(2), true)
+	m.emitScaleFactorChanged(singleToMapSF(1), singleToMapSF(2), false)
+	assert.Len(t, emitter.signals, 1)
+}
+
+func Test_isPlymouthRebootPending(t *testing.T) {
+	const bootID = "8f2a4d3e-0b1c-4c57-9d2e-6b0f1a2c3d4e"
+	// 没有设置
+	assert.False(t, isPlymouthRebootPending("", bootID))
+	// 本次启动中缩放了 Plymouth
+	assert.True(t, isPlymouthRebootPending(bootID, bootID))
+	// 重启之后
+	assert.False(t, isPlymouthRebootPending(bootID, "1c0e7a9b-5f3d-4e21-8a6c-2d9b7e4f0a13"))
+}
+
+func Test_plymouthSettler(t *testing.T) {
+	type task struct {
+		factor     int
+		emitSignal bool
+	}
+	var mu sync.Mutex
+	var tasks []task
+	s := newPlymouthSettler(func(factor int, emitSignal bool) {
+		mu.Lock()
+		tasks = append(tasks, task{factor, emitSignal})
+		mu.Unlock()
+	})
+	getTasks := func() []task {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]task(nil), tasks...)
+	}
+
+	// 延迟内的两次修改只设置一次
+	s.schedule(50*time.Millisecond, 2, true)
+	s.schedule(50*time.Millisecond, 1, false)
+	assert.Empty(t, getTasks())
+	assert.Eventually(t, func() bool {
+		return len(getTasks()) == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []task{{1, true}}, getTasks())
+
+	// 没有延迟时立即设置
+	s.schedule(0, 2, false)
+	assert.Equal(t, []task{{1, true}, {2, false}}, getTasks())
+}
+
+func Test_verifyGreeterScale(t *testing.T) {
+	factors := map[string]float64{"eDP-1": 2, "HDMI-1": 1}
+	greeterReports := func(greeterFactors map[string]float64) func() (map[string]float64, error) {
+		return func() (map[string]float64, error) {
+			return greeterFactors, nil
+		}
+	}
+
+	ok, err := verifyGreeterScale(greeterReports(map[string]float64{"eDP-1": 2}), factors, "eDP-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = verifyGreeterScale(greeterReports(singleToMapSF(1)), factors, "eDP-1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = verifyGreeterScale(func() (map[string]float64, error) {
+		return nil, errGreeterScaleNotSupported
+	}, factors, "eDP-1")
+	assert.Equal(t, errGreeterScaleNotSupported, err)
+}
+
+func Test_readGreeterScaleFactors(t *testing.T) {
+	dir := t.TempDir()
+	_, err := readGreeterScaleFactors(filepath.Join(dir, "qt-theme.ini"))
+	assert.Equal(t, errGreeterScaleNotSupported, err)
+	noScale := filepath.Join(dir, "no-scale.ini")
+	require.NoError(t, ioutil.WriteFile(noScale, []byte("[Theme]\nIconThemeName=bloom\n"), 0644))
+	_, err = readGreeterScaleFactors(noScale)
+	assert.Equal(t, errGreeterScaleNotSupported, err)
+
+	tests := []struct {
+		content string
+		want    map[string]float64
+	}{
+		{content: "[Theme]\nScreenScaleFactors=1.50\n", want: map[string]float64{"ALL": 1.5}},
+		{content: "[Theme]\nScreenScaleFactors=\"HDMI-1=1.00;eDP-1=2.00\"\n", want: map[string]float64{"HDMI-1": 1, "eDP-1": 2}},
+	}
+	for i, tt := range tests {
+		filename := filepath.Join(dir, strconv.Itoa(i)+".ini")
+		require.NoError(t, ioutil.WriteFile(filename, []byte(tt.content), 0644))
+		got, err := readGreeterScaleFactors(filename)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+}
+
+func Test_getEffectiveScaleByToolkit(t *testing.T) {
+	tests := []struct {
+		name  string
+		state toolkitScaleState
+		want  map[string]float64
+	}{
+		{
+			name: "consistent",
+			state: toolkitScaleState{
+				windowScale:    2,
+				qtFactors:      singleToMapSF(2),
+				primary:        "eDP-1",
+				cursorSize:     48,
+				cursorBaseSize: 24,
+				plymouthFactor: 2,
+			},
+			want: map[string]float64{"gtk": 2, "qt": 2, "cursor": 2, "plymouth": 2},
+		},
+		{
+			name: "fractional",
+			state: toolkitScaleState{
+				windowScale:    1,
+				qtFactors:      map[string]float64{"eDP-1": 1.25, "HDMI-1": 1},
+				primary:        "eDP-1",
+				cursorSize:     30,
+				cursorBaseSize: 24,
+				plymouthFactor: 1,
+			},
+			want: map[string]float64{"gtk": 1, "qt": 1.25, "cursor": 1.25, "plymouth": 1},
+		},
+		{
+			name: "cursor max factor, plymouth unknown",
+			state: toolkitScaleState{
+				windowScale:    1,
+				qtFactors:      map[string]float64{"eDP-1": 2, "HDMI-1": 1},
+				primary:        "HDMI-1",
+				cursorSize:     48,
+				cursorBaseSize: 24,
+			},
+			want: map[string]float64{"gtk": 1, "qt": 1, "cursor": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, getEffectiveScaleByToolkit(tt.state))
+		})
+	}
+}
+
+func Test_themeReassert(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	d := newDebouncer(50*time.Millisecond, func() {
+		mu.Lock()
+		count++
+		mu.Unlock()
+	})
+	getCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+
+	// 模拟切换主题时连续修改多个 key
+	for _, key := range []string{"theme-name", "icon-theme-name", "gtk-cursor-theme-name", "xft-dpi"} {
+		if isThemeGSKey(key) {
+			d.trigger()
+		}
+	}
+	assert.Equal(t, 0, getCount())
+	assert.Eventually(t, func() bool {
+		return getCount() == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, getCount())
+
+	assert.False(t, isThemeGSKey(gsKeyScaleFactor))
+}
+
+func Test_setScaleFactorForPlymouthReal_unavailable(t *testing.T) {
+	// 没有系统服务
+	m := &XSManager{}
+	m.setScaleFactorForPlymouthReal(2, false)
+	assert.True(t, m.plymouthUnavailable.Load())
+	m.setScaleFactorForPlymouthReal(1, false)
+
+	// 已经确认不可用之后不再调用
+	mockDaemon := &daemon.MockDaemon{}
+	m = &XSManager{sysDaemon: mockDaemon}
+	m.plymouthUnavailable.Store(true)
+	m.setScaleFactorForPlymouthReal(2, false)
+	mockDaemon.MockInterfaceDaemon.AssertNotCalled(t, "ScalePlymouth", mock.Anything, mock.Anything)
+}
+
+func Test_isPlymouthUnavailableErr(t *testing.T) {
+	assert.False(t, isPlymouthUnavailableErr(nil))
+	assert.False(t, isPlymouthUnavailableErr(errors.New("timeout")))
+	assert.False(t, isPlymouthUnavailableErr(dbus.Error{Name: "org.freedesktop.DBus.Error.NoReply"}))
+	assert.True(t, isPlymouthUnavailableErr(dbus.Error{Name: "org.freedesktop.DBus.Error.UnknownMethod"}))
+	assert.True(t, isPlymouthUnavailableErr(dbus.Error{Name: "org.freedesktop.DBus.Error.ServiceUnknown"}))
+}
+
+func Test_getCursorPreviewSize(t *testing.T) {
+	tests := []struct {
+		baseSize int32
+		scale    float64
+		want     int32
+	}{
+		{baseSize: 24, scale: 1, want: 24},
+		{baseSize: 24, scale: 1.5, want: 36},
+		{baseSize: 24, scale: 2, want: 48},
+		{baseSize: 32, scale: 1.25, want: 40},
+		{baseSize: 24, scale: 0.5, want: 12},
+		{baseSize: 24, scale: 0.25, want: 12},
+		{baseSize: 24, scale: 5, want: 72},
+	}
+	for _, tt := range tests {
+		got, err := getCursorPreviewSize(tt.baseSize, tt.scale, defaultMinScaleFactor, defaultMaxScaleFactor)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.scale)
+	}
+
+	_, err := getCursorPreviewSize(24, 0, defaultMinScaleFactor, defaultMaxScaleFactor)
+	assert.Error(t, err)
+}
+
+type fakeSignalEmitter struct {
+	mu      sync.Mutex
+	signals []string
+	// 每个信号携带的参数
+	values [][]interface{}
+	props  []interface{}
+}
+
+func (e *fakeSignalEmitter) Emit(v dbusutil.Implementer, signalName string, values ...interface{}) error {
+	e.mu.Lock()
+	e.signals = append(e.signals, signalName)
+	e.values = append(e.values, values)
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *fakeSignalEmitter) EmitPropertyChanged(v dbusutil.Implementer, propertyName string, value interface{}) error {
+	e.mu.Lock()
+	e.props = append(e.props, propertyName, value)
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *fakeSignalEmitter) getSignals() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.signals...)
+}
+
+func Test_emitCurrentScaleState(t *testing.T) {
+	emitter := &fakeSignalEmitter{}
+	m := &XSManager{service: emitter}
+	m.emitCurrentScaleState()
+	assert.Equal(t, []string{signalSetScaleFactorDone}, emitter.getSignals())
+}
+
+func Test_computeScaleDerivatives(t *testing.T) {
+	t.Setenv(envPlymouthMaxScale, "")
+	tests := []struct {
+		factor float64
+		want   ScaleDerivatives
+	}{
+		{1, ScaleDerivatives{1, 24, "1.00", 1}},
+		{1.25, ScaleDerivatives{1, 30, "1.25", 1}},
+		{1.5, ScaleDerivatives{1, 36, "1.50", 1}},
+		{1.75, ScaleDerivatives{2, 42, "1.75", 2}},
+		{2, ScaleDerivatives{2, 48, "2.00", 2}},
+		{2.25, ScaleDerivatives{2, 54, "2.25", 2}},
+		{2.5, ScaleDerivatives{2, 60, "2.50", 2}},
+		// Plymouth 不超过上限
+		{2.75, ScaleDerivatives{3, 66, "2.75", 2}},
+		{3, ScaleDerivatives{3, 72, "3.00", 2}},
+		{0.5, ScaleDerivatives{1, 12, "0.50", 1}},
+	}
+	for _, tt := range tests {
+		got, err := computeScaleDerivatives(tt.factor)
+		require.NoError(t, err, tt.factor)
+		assert.Equal(t, tt.want, got, tt.factor)
+	}
+
+	t.Setenv(envPlymouthMaxScale, "3")
+	got, err := computeScaleDerivatives(3)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), got.PlymouthFactor)
+
+	_, err = computeScaleDerivatives(0)
+	assert.Error(t, err)
+	_, err = computeScaleDerivatives(-1)
+	assert.Error(t, err)
+}
+
+func Test_getScaleDefaults(t *testing.T) {
+	defaults := getScaleDefaults()
+	assert.Equal(t, ScaleDefaults{
+		ScaleFactor:          1,
+		MinScaleFactor:       0.5,
+		MaxScaleFactor:       3,
+		ScaleFactorStep:      0.25,
+		ScaleClampMin:        0.5,
+		ScaleClampMax:        3,
+		CursorBaseSize:       24,
+		PlymouthSettleDelay:  1000,
+		ScaleApplyDelay:      300,
+		PlymouthScaleTimeout: 30,
+		SpanningScalePolicy:  "max",
+		ScalingMode:          "individual",
+		ScaleSnapStep:        0.25,
+	}, defaults)
+
+	// 与 gschema 中的默认值一致
+	data, err := ioutil.ReadFile("../misc/schemas/com.deepin.dde.startdde.gschema.xml")
+	require.NoError(t, err)
+	var schemaList struct {
+		Keys []struct {
+			Name    string `xml:"name,attr"`
+			Default string `xml:"default"`
+		} `xml:"schema>key"`
+	}
+	require.NoError(t, xml.Unmarshal(data, &schemaList))
+	schemaDefaults := make(map[string]string)
+	for _, key := range schemaList.Keys {
+		schemaDefaults[key.Name] = key.Default
+	}
+	for key, want := range map[string]string{
+		gsKeyPlymouthSettle:  strconv.Itoa(int(defaults.PlymouthSettleDelay)),
+		gsKeyApplyDelay:      strconv.Itoa(int(defaults.ScaleApplyDelay)),
+		gsKeyPlymouthTimeout: strconv.Itoa(int(defaults.PlymouthScaleTimeout)),
+		gsKeyMaxEffectiveDpi: strconv.Itoa(int(defaults.MaxEffectiveDpi)),
+		gsKeyScaleClampMin:   strconv.FormatFloat(defaults.ScaleClampMin, 'f', 1, 64),
+		gsKeyScaleClampMax:   strconv.FormatFloat(defaults.ScaleClampMax, 'f', 1, 64),
+		gsKeySnapStep:        strconv.FormatFloat(defaults.ScaleSnapStep, 'f', 2, 64),
+		gsKeySpanningPolicy:  defaults.SpanningScalePolicy,
+		gsKeyCursorMaxScale:  strconv.FormatBool(defaults.CursorSizeMaxFactor),
+		gsKeyQtThemeSandbox:  strconv.FormatBool(defaults.QtThemeSandboxCopy),
+		gsKeyXresourcesDpi:   strconv.FormatBool(defaults.XresourcesFileDpi),
+		gsKeyScaleLocked:     strconv.FormatBool(defaults.ScaleFactorLocked),
+		gsKeyStructuredLog:   strconv.FormatBool(defaults.StructuredLog),
+		gsKeyWineScaling:     strconv.FormatBool(defaults.WineScaling),
+		gsKeyScalingMode:     defaults.ScalingMode,
+	} {
+		assert.Equal(t, want, strings.Trim(schemaDefaults[key], "'"), key)
+	}
+}
+
+func Test_collapseScreenFactors(t *testing.T) {
+	assert.NoError(t, checkScalingMode(scalingModeIndividual))
+	assert.NoError(t, checkScalingMode(scalingModeUnified))
+	assert.Error(t, checkScalingMode("Unified"))
+	assert.Error(t, checkScalingMode(""))
+
+	tests := []struct {
+		name    string
+		factors map[string]float64
+		primary string
+		want    map[string]float64
+	}{
+		{"primary", map[string]float64{"eDP-1": 2, "HDMI-1": 1}, "eDP-1", singleToMapSF(2)},
+		{"all", map[string]float64{"ALL": 1.5, "HDMI-1": 1}, "eDP-1", singleToMapSF(1.5)},
+		{"single", map[string]float64{"HDMI-1": 1.25}, "eDP-1", singleToMapSF(1.25)},
+		{"no primary", map[string]float64{"eDP-1": 2, "HDMI-1": 1.25}, "", singleToMapSF(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, collapseScreenFactors(tt.factors, tt.primary))
+		})
+	}
+}
+
+func Test_clampScreenFactors(t *testing.T) {
+	cfg := scaleConfig{minFactor: 0.5, maxFactor: 3}
+	assert.Equal(t, map[string]float64{"eDP-1": 3, "HDMI-1": 0.5, "DP-1": 1.25},
+		clampScreenFactors(map[string]float64{"eDP-1": 8, "HDMI-1": 0.1, "DP-1": 1.25}, cfg))
+	// 没有配置范围时不限制
+	assert.Equal(t, singleToMapSF(8), clampScreenFactors(singleToMapSF(8), scaleConfig{}))
+
+	min, max := getScaleClampRange(0.75, 2)
+	assert.Equal(t, 0.75, min)
+	assert.Equal(t, 2.0, max)
+	for _, r := range [][2]float64{{0, 3}, {-1, 3}, {2, 1}} {
+		min, max = getScaleClampRange(r[0], r[1])
+		assert.Equal(t, defaultMinScaleFactor, min)
+		assert.Equal(t, defaultMaxScaleFactor, max)
+	}
+}
+
+func Test_saveQtThemeVerified(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "qt-theme.ini")
+	qt := &qtThemeChange{
+		filename: filename,
+		factors:  map[string]float64{"eDP-1": 2, "HDMI-1": 1},
+	}
+	goodContent := "[Theme]\nScreenScaleFactors=\"eDP-1=2.00;HDMI-1=1.00\"\n"
+	badContent := "[Theme]\nScreenScaleFactors=\"eDP-1=1.00;HDMI-1=1.00\"\n"
+	writeFile := func(contents ...string) (func() error, *int) {
+		count := 0
+		return func() error {
+			content := contents[len(contents)-1]
+			if count < len(contents) {
+				content = contents[count]
+			}
+			count++
+			return ioutil.WriteFile(filename, []byte(content), 0644)
+		}, &count
+	}
+
+	save, count := writeFile(goodContent)
+	assert.NoError(t, saveQtThemeVerified(save, qt.verifyFile))
+	assert.Equal(t, 1, *count)
+
+	// 第一次写入的内容错误，重试后成功
+	save, count = writeFile(badContent, goodContent)
+	assert.NoError(t, saveQtThemeVerified(save, qt.verifyFile))
+	assert.Equal(t, 2, *count)
+
+	// 一直错误
+	save, count = writeFile(badContent)
+	assert.Error(t, saveQtThemeVerified(save, qt.verifyFile))
+	assert.Equal(t, 2, *count)
+
+	// 写入失败时不重试
+	count2 := 0
+	err := saveQtThemeVerified(func() error {
+		count2++
+		return errors.New("write failed")
+	}, qt.verifyFile)
+	assert.Error(t, err)
+	assert.Equal(t, 1, count2)
+}
+
+func Test_qtScaleFactorsMatch(t *testing.T) {
+	assert.True(t, qtScaleFactorsMatch(singleToMapSF(1.25), map[string]float64{"eDP-1": 1.25}))
+	assert.False(t, qtScaleFactorsMatch(singleToMapSF(1.5), map[string]float64{"eDP-1": 1.25}))
+	assert.True(t, qtScaleFactorsMatch(map[string]float64{"eDP-1": 2, "HDMI-1": 1},
+		map[string]float64{"eDP-1": 2, "HDMI-1": 1}))
+	assert.False(t, qtScaleFactorsMatch(map[string]float64{"eDP-1": 2, "DP-1": 1},
+		map[string]float64{"eDP-1": 2, "HDMI-1": 1}))
+	assert.False(t, qtScaleFactorsMatch(singleToMapSF(2), map[string]float64{"eDP-1": 2, "HDMI-1": 1}))
+}
+
+func Test_joinScreenScaleFactors(t *testing.T) {
+	factors := map[string]float64{"eDP-1": 2, "HDMI-1": 1.25, "DP-10": 1, "DP-1": 1.5, "ALL": 1}
+	joined := joinScreenScaleFactors(factors)
+	assert.Equal(t, "ALL=1.00;DP-1=1.50;DP-10=1.00;HDMI-1=1.25;eDP-1=2.00", joined)
+	assert.Equal(t, factors, parseScreenFactors(joined))
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, joined, joinScreenScaleFactors(factors))
+	}
+	assert.Equal(t, "", joinScreenScaleFactors(nil))
+}
+
+func Test_joinScreenScaleFactorsEscape(t *testing.T) {
+	factors := map[string]float64{
+		"ALL":       1,
+		"a=b":       1.25,
+		"a;b":       1.5,
+		"%3D":       1.75,
+		"=;%":       2,
+		"x==y;;z%%": 2.25,
+		"中文 屏幕":     2.5,
+	}
+	joined := joinScreenScaleFactors(factors)
+	assert.True(t, strings.HasPrefix(joined, "%253D=1.75;"))
+	assert.Contains(t, joined, "ALL=1.00;")
+	assert.Contains(t, joined, "a%3Db=1.25;")
+	assert.Contains(t, joined, "a%3Bb=1.50;")
+	assert.Equal(t, factors, parseScreenFactors(joined))
+	assert.Empty(t, findLossyScreenFactors(joined))
+
+	// 编码之前写入的值中的 % 原样保留
+	assert.Equal(t, map[string]float64{"DP%1": 2}, parseScreenFactors("DP%1=2"))
+
+	edids := map[string]string{"a=b;c": "0123", "HDMI-1": "4567"}
+	assert.Equal(t, edids, parseScreenEdids(joinScreenEdids(edids)))
+}
+
+func Test_PlymouthQueueDepth(t *testing.T) {
+	emitter := &fakeSignalEmitter{}
+	m := &XSManager{service: emitter}
+	// 正在设置 Plymouth，新的设置进入队列
+	m.plymouthScaling = true
+	m.setScaleFactorForPlymouth(1, false)
+	m.setScaleFactorForPlymouth(2, false)
+	assert.Equal(t, int32(1), m.PlymouthQueueDepth)
+	assert.Equal(t, []interface{}{
+		"PlymouthQueueDepth", int32(1),
+	}, emitter.props)
+}
+
+func Test_setScaleFactorForPlymouth_collapse(t *testing.T) {
+	m := &XSManager{service: &fakeSignalEmitter{}}
+	m.plymouthScaling = true
+	for i := 0; i < 50; i++ {
+		m.setScaleFactorForPlymouth(i%2+1, false)
+		assert.LessOrEqual(t, len(m.plymouthScalingTasks), 1)
+	}
+	assert.Equal(t, []int{2}, m.plymouthScalingTasks)
+	assert.Equal(t, int32(1), m.PlymouthQueueDepth)
+}
+
+func Test_formatScaleFactor_roundTrip(t *testing.T) {
+	assert.Equal(t, "1.25", formatScaleFactor(1.25))
+	assert.Equal(t, "2.00", formatScaleFactor(2))
+	assert.Equal(t, "1.333333", formatScaleFactor(snapScaleFactor(1.3, 1.0/3)))
+	assert.Equal(t, "1.20", formatScaleFactor(snapScaleFactor(1.23, 0.1)))
+
+	rnd := rand.New(rand.NewSource(1))
+	steps := []float64{0.25, 0.125, 0.1, 0.05, 0.01, 1.0 / 3, 1.0 / 6}
+	for i := 0; i < 10000; i++ {
+		v := defaultMinScaleFactor + rnd.Float64()*(defaultMaxScaleFactor-defaultMinScaleFactor)
+		step := steps[i%len(steps)]
+		snapped := snapScaleFactor(v, step)
+		factors := map[string]float64{"eDP-1": snapped, "ALL": v}
+		got := parseScreenFactors(joinScreenScaleFactors(factors))
+		require.Equal(t, factors, got, "v=%v step=%v", v, step)
+
+		qtValue, err := getQtScreenScaleFactorsValue(singleToMapSF(snapped))
+		require.NoError(t, err)
+		qtFactors, err := parseQtScreenScaleFactors(qtValue)
+		require.NoError(t, err)
+		require.Equal(t, snapped, qtFactors["ALL"])
+	}
+}
+
+func Test_qtThemeChange_saveTo_atomic(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	qtThemeFile := getQtThemeFile()
+	require.NoError(t, os.MkdirAll(filepath.Dir(qtThemeFile), 0755))
+	oldContent := []byte("[Theme]\nScreenScaleFactors=1.00\n")
+	require.NoError(t, ioutil.WriteFile(qtThemeFile, oldContent, 0600))
+
+	qt, err := prepareQtTheme(singleToMapSF(2), scaleConfig{})
+	require.NoError(t, err)
+
+	// 写入临时文件后校验失败，原文件不变，也不留下临时文件
+	badQt := *qt
+	badQt.value = "3.00"
+	assert.Error(t, badQt.saveTo(qtThemeFile))
+	content, err := ioutil.ReadFile(qtThemeFile)
+	require.NoError(t, err)
+	assert.Equal(t, oldContent, content)
+	fileInfos, err := ioutil.ReadDir(filepath.Dir(qtThemeFile))
+	require.NoError(t, err)
+	assert.Len(t, fileInfos, 1)
+
+	require.NoError(t, qt.saveTo(qtThemeFile))
+	fileInfo, err := os.Stat(qtThemeFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), fileInfo.Mode().Perm())
+	factors, err := loadQtScreenScaleFactors(qtThemeFile)
+	require.NoError(t, err)
+	assert.Equal(t, singleToMapSF(2), factors)
+}
+
+func Test_prepareQtTheme_keepUnknownKeys(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	qtThemeFile := getQtThemeFile()
+	require.NoError(t, os.MkdirAll(filepath.Dir(qtThemeFile), 0755))
+	oldContent := []byte("[Theme]\nIconThemeName=bloom\nScaleFactor=1.25\nScreenScaleFactors=1.25\nMyKey=hand-edited\n")
+	require.NoError(t, ioutil.WriteFile(qtThemeFile, oldContent, 0644))
+
+	qt, err := prepareQtTheme(singleToMapSF(2), scaleConfig{})
+	require.NoError(t, err)
+	require.NoError(t, qt.save())
+
+	kf := keyfile.NewKeyFile()
+	require.NoError(t, kf.LoadFromFile(qtThemeFile))
+	value, err := kf.GetValue(qtThemeSection, "MyKey")
+	require.NoError(t, err)
+	assert.Equal(t, "hand-edited", value)
+	value, err = kf.GetValue(qtThemeSection, "IconThemeName")
+	require.NoError(t, err)
+	assert.Equal(t, "bloom", value)
+	value, err = kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+	require.NoError(t, err)
+	assert.Equal(t, "2.00", value)
+	_, err = kf.GetValue(qtThemeSection, qtThemeKeyScaleFactor)
+	assert.Error(t, err)
+
+	// 保留了修改前的内容
+	bak, err := ioutil.ReadFile(qtThemeFile + ".bak")
+	require.NoError(t, err)
+	assert.Equal(t, oldContent, bak)
+}
+
+// fakeDsfHelper 代替 display 模块，history 按顺序记录每次设置成功的值
+type fakeDsfHelper struct {
+	factors map[string]float64
+	history []map[string]float64
+	err     error
+}
+
+func (h *fakeDsfHelper) SetScaleFactors(factors map[string]float64) error {
+	if h.err != nil {
+		return h.err
+	}
+	h.factors = factors
+	h.history = append(h.history, factors)
+	return nil
+}
+
+func (h *fakeDsfHelper) GetScaleFactors() (map[string]float64, error) {
+	return h.factors, nil
+}
+
+func (h *fakeDsfHelper) SetChangedCb(fn func(factors map[string]float64) error) {}
+
+func Test_applyDsfHelper(t *testing.T) {
+	emitter := &fakeSignalEmitter{}
+	helper := &fakeDsfHelper{err: errors.New("display is not ready")}
+	m := &XSManager{service: emitter, dsfHelper: helper, DsfHelperApplied: true}
+
+	assert.False(t, m.applyDsfHelper(singleToMapSF(2)))
+	assert.False(t, m.DsfHelperApplied)
+	assert.Nil(t, helper.factors)
+
+	helper.err = nil
+	assert.True(t, m.applyDsfHelper(singleToMapSF(2)))
+	assert.True(t, m.DsfHelperApplied)
+	assert.Equal(t, singleToMapSF(2), helper.factors)
+	assert.Equal(t, []interface{}{
+		"DsfHelperApplied", false,
+		"DsfHelperApplied", true,
+	}, emitter.props)
+}
+
+func Test_resetCursorSizeToScale_derived(t *testing.T) {
+	factors := singleToMapSF(2)
+	// 在缩放为 2 时单独设置光标大小为 64，记录的基准大小为 32
+	override := scaleConfig{cursorBaseSize: deriveCursorBaseSize(64, 2)}
+	assert.Equal(t, int32(64), deriveScaleValues(2, factors, override).cursorSize)
+
+	// 清除后使用默认的基准大小
+	cleared := scaleConfig{cursorBaseSize: baseCursorSize}
+	assert.Equal(t, scaleDerivedValues{windowScale: 2, cursorSize: 48}, deriveScaleValues(2, factors, cleared))
+}
+
+func Test_findLossyScreenFactors(t *testing.T) {
+	assert.Empty(t, findLossyScreenFactors(""))
+	assert.Empty(t, findLossyScreenFactors("HDMI-1=1;eDP-1=1.25"))
+	assert.Empty(t, findLossyScreenFactors("eDP-1=1.5;HDMI-1=1.333"))
+
+	assert.Equal(t, []string{
+		`"DP-1": missing '='`,
+		`"=2": empty screen name`,
+		`"VGA-1=abc": invalid value`,
+		`"eDP-1=2": duplicate screen name`,
+		"HDMI-1=NaN: value changed after round trip",
+	}, findLossyScreenFactors("eDP-1=1.5;DP-1;=2;VGA-1=abc;eDP-1=2;HDMI-1=NaN"))
+}
+
+func Test_checkFactorsHavePrimary(t *testing.T) {
+	assert.NoError(t, checkFactorsHavePrimary(map[string]float64{"eDP-1": 2, "HDMI-1": 1}, "eDP-1"))
+	assert.NoError(t, checkFactorsHavePrimary(map[string]float64{"ALL": 2, "HDMI-1": 1}, "eDP-1"))
+	assert.NoError(t, checkFactorsHavePrimary(singleToMapSF(2), "eDP-1"))
+	assert.NoError(t, checkFactorsHavePrimary(map[string]float64{"HDMI-1": 1}, "eDP-1"))
+	assert.NoError(t, checkFactorsHavePrimary(map[string]float64{"DP-1": 2, "HDMI-1": 1}, ""))
+
+	err := checkFactorsHavePrimary(map[string]float64{"DP-1": 2, "HDMI-1": 1}, "eDP-1")
+	assert.EqualError(t, err, "scale factors map[DP-1:2 HDMI-1:1] missing primary screen eDP-1")
+}
+
+func Test_getPlymouthMaxScale(t *testing.T) {
+	t.Setenv(envPlymouthMaxScale, "")
+	assert.Equal(t, 2, getPlymouthMaxScale())
+	assert.Equal(t, 2, clampPlymouthFactor(3, getPlymouthMaxScale()))
+
+	t.Setenv(envPlymouthMaxScale, "3")
+	assert.Equal(t, 3, getPlymouthMaxScale())
+	assert.Equal(t, 3, clampPlymouthFactor(3, getPlymouthMaxScale()))
+	assert.Equal(t, 1, clampPlymouthFactor(1, getPlymouthMaxScale()))
+
+	t.Setenv(envPlymouthMaxScale, "abc")
+	assert.Equal(t, 2, getPlymouthMaxScale())
+	t.Setenv(envPlymouthMaxScale, "0")
+	assert.Equal(t, 2, getPlymouthMaxScale())
+}
+
+func Test_setScaleFactorForPlymouthReal_timeout(t *testing.T) {
+	unblock := make(chan time.Time)
+	defer close(unblock)
+	mockDaemon := &daemon.MockDaemon{}
+	mockDaemon.MockInterfaceDaemon.On("ScalePlymouth", dbus.Flags(0), mock.Anything).
+		Return(nil).WaitUntil(unblock)
+	emitter := &fakeSignalEmitter{}
+	m := &XSManager{
+		sysDaemon:            mockDaemon,
+		service:              emitter,
+		plymouthScaleTimeout: 50 * time.Millisecond,
+	}
+
+	// 系统服务卡住时超时返回，仍然发送 SetScaleFactorDone 并继续处理队列中的任务
+	m.plymouthScaling = true
+	m.plymouthScalingTasks = []int{1}
+	start := time.Now()
+	m.setScaleFactorForPlymouthReal(2, true)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, []string{
+		signalSetScaleFactorStarted,
+		signalSetScaleFactorProgress,
+		signalSetScaleFactorProgress,
+		signalSetScaleFactorDone,
+		signalPlymouthScaleFailed,
+	}, emitter.signals)
+	assert.Contains(t, m.getLastPlymouthScaleError(), context.DeadlineExceeded.Error())
+
+	m.endScaleFactorForPlymouth()
+	assert.Eventually(t, func() bool {
+		m.plymouthScalingMu.Lock()
+		defer m.plymouthScalingMu.Unlock()
+		return !m.plymouthScaling
+	}, time.Second, 10*time.Millisecond)
+	mockDaemon.MockInterfaceDaemon.AssertCalled(t, "ScalePlymouth", dbus.Flags(0), uint32(1))
+}
+
+func Test_callWithTimeout(t *testing.T) {
+	errTest := errors.New("test")
+	assert.Equal(t, errTest, callWithTimeout(0, func() error { return errTest }))
+	assert.Equal(t, errTest, callWithTimeout(time.Second, func() error { return errTest }))
+	err := callWithTimeout(10*time.Millisecond, func() error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func Test_loadPlymouthThemeScaleFactors(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "plymouth-theme-scale.conf")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`# extra themes
+vendor-logo = 1
+vendor-4k-logo=3
+invalid
+bad-logo=0
+`), 0644))
+	factors, err := loadPlymouthThemeScaleFactors(filename)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"vendor-logo": 1, "vendor-4k-logo": 3}, factors)
+
+	assert.Equal(t, 3, lookupPlymouthThemeScaleFactor(factors, "vendor-4k-logo"))
+	assert.Equal(t, 2, lookupPlymouthThemeScaleFactor(factors, "vendor-hidpi-logo"))
+	assert.Equal(t, 1, lookupPlymouthThemeScaleFactor(factors, "spinner"))
+}
+
+func Test_getWrapGDISettings(t *testing.T) {
+	created := 0
+	newGioSettingsOld := newGioSettings
+	defer func() {
+		newGioSettings = newGioSettingsOld
+	}()
+	newGioSettings = func(schemaId string) *gio.Settings {
+		assert.Equal(t, wrapGnomeInterfaceSchema, schemaId)
+		created++
+		return nil
+	}
+
+	m := &XSManager{}
+	for i := 0; i < 100; i++ {
+		m.getWrapGDISettings()
+	}
+	assert.Equal(t, 1, created)
+}
+
+func Test_applyDdeScaleEnv(t *testing.T) {
+	ue := map[string]string{
+		"QT_SCALE_FACTOR":  "2",
+		EnvDeepinWineScale: "2.00",
+		"LANG":             "zh_CN.UTF-8",
+	}
+	assert.True(t, applyDdeScaleEnv(ue, ddeScaleEnvKeys, "1.50"))
+	assert.Equal(t, map[string]string{EnvDeepinWineScale: "1.50", "LANG": "zh_CN.UTF-8"}, ue)
+	assert.False(t, applyDdeScaleEnv(ue, ddeScaleEnvKeys, "1.50"))
+
+	// 关闭后与其他变量一样被清理
+	assert.True(t, applyDdeScaleEnv(ue, ddeScaleEnvKeys, ""))
+	assert.Equal(t, map[string]string{"LANG": "zh_CN.UTF-8"}, ue)
+	assert.False(t, applyDdeScaleEnv(ue, ddeScaleEnvKeys, ""))
+}
+
+func Test_updateDdeScaleEnvFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "environment")
+	require.NoError(t, userenv.SaveToFile(filename, map[string]string{
+		"QT_SCALE_FACTOR": "2",
+		"QT_FONT_DPI":     "192",
+		"LANG":            "zh_CN.UTF-8",
+	}))
+
+	keys := getDdeScaleEnvKeys([]string{"QT_FONT_DPI"})
+	assert.NotContains(t, keys, "QT_FONT_DPI")
+	assert.Len(t, keys, len(ddeScaleEnvKeys)-1)
+	require.NoError(t, updateDdeScaleEnvFile(filename, keys, ""))
+
+	ue, err := userenv.LoadFromFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"QT_FONT_DPI": "192", "LANG": "zh_CN.UTF-8"}, ue)
+
+	// 文件不存在时不需要创建
+	noneFile := filepath.Join(t.TempDir(), "none")
+	require.NoError(t, updateDdeScaleEnvFile(noneFile, ddeScaleEnvKeys, ""))
+	_, err = os.Stat(noneFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_getWineScaleValue(t *testing.T) {
+	factors := map[string]float64{"eDP-1": 2, "HDMI-1": 1}
+	assert.Equal(t, "2.00", getWineScaleValue(factors, "eDP-1"))
+	assert.Equal(t, "1.00", getWineScaleValue(factors, "HDMI-1"))
+	assert.Equal(t, "1.25", getWineScaleValue(singleToMapSF(1.25), "eDP-1"))
+	assert.Equal(t, "1.25", getWineScaleValue(singleToMapSF(1.25), ""))
+}
+
+func Test_sendGreeterQtTheme_retry(t *testing.T) {
+	greeterRetryDelayOld := greeterRetryDelay
+	greeterRetryDelay = time.Millisecond
+	defer func() {
+		greeterRetryDelay = greeterRetryDelayOld
+	}()
+
+	errNotReady := errors.New("greeter not ready")
+	var received [][]byte
+	readFd := func(args mock.Arguments) {
+		fd := args.Get(1).(dbus.UnixFD)
+		buf := make([]byte, 4096)
+		n, err := syscall.Pread(int(fd), buf, 0)
+		require.NoError(t, err)
+		received = append(received, buf[:n])
+	}
+	mockGreeter := &greeter.MockGreeter{}
+	mockGreeter.MockInterfaceGreeter.On("UpdateGreeterQtTheme", dbus.Flags(0), mock.Anything).
+		Return(errNotReady).Run(readFd).Twice()
+	mockGreeter.MockInterfaceGreeter.On("UpdateGreeterQtTheme", dbus.Flags(0), mock.Anything).
+		Return(nil).Run(readFd).Once()
+	m := &XSManager{greeter: mockGreeter}
+
+	data := []byte("[Theme]\nScreenScaleFactors=2.00\n")
+	require.NoError(t, m.sendGreeterQtTheme(data))
+	mockGreeter.MockInterfaceGreeter.AssertNumberOfCalls(t, "UpdateGreeterQtTheme", 3)
+	// 每次重试都能读到完整的内容
+	assert.Equal(t, [][]byte{data, data, data}, received)
+
+	// 全部失败时返回最后一次的错误
+	mockGreeter = &greeter.MockGreeter{}
+	mockGreeter.MockInterfaceGreeter.On("UpdateGreeterQtTheme", dbus.Flags(0), mock.Anything).
+		Return(errNotReady)
+	m.greeter = mockGreeter
+	assert.Equal(t, errNotReady, m.sendGreeterQtTheme(data))
+	mockGreeter.MockInterfaceGreeter.AssertNumberOfCalls(t, "UpdateGreeterQtTheme", greeterUpdateAttempts)
+}
+
+func Test_sendGreeterQtTheme_noGreeter(t *testing.T) {
+	mockGreeter := &greeter.MockGreeter{}
+	mockGreeter.MockInterfaceGreeter.On("UpdateGreeterQtTheme", dbus.Flags(0), mock.Anything).Return(nil)
+	present := false
+	m := &XSManager{
+		greeter: mockGreeter,
+		greeterAvailable: func() (bool, error) {
+			return present, nil
+		},
+	}
+
+	data := []byte("[Theme]\nScreenScaleFactors=2.00\n")
+	require.NoError(t, m.sendGreeterQtTheme(data))
+	mockGreeter.MockInterfaceGreeter.AssertNotCalled(t, "UpdateGreeterQtTheme", mock.Anything, mock.Anything)
+
+	present = true
+	require.NoError(t, m.sendGreeterQtTheme(data))
+	mockGreeter.MockInterfaceGreeter.AssertNumberOfCalls(t, "UpdateGreeterQtTheme", 1)
+
+	// 检查失败时仍然尝试同步
+	m.greeterAvailable = func() (bool, error) {
+		return false, errors.New("no system bus")
+	}
+	require.NoError(t, m.sendGreeterQtTheme(data))
+	mockGreeter.MockInterfaceGreeter.AssertNumberOfCalls(t, "UpdateGreeterQtTheme", 2)
+}
+
+func Test_resolvePrimaryScreenName(t *testing.T) {
+	errRandr := errors.New("randr failed")
+	errBus := errors.New("bus failed")
+	fromRandr := func() (string, error) { return "eDP-1", nil }
+	fromBus := func() (string, error) { return "HDMI-1", nil }
+	randrFail := func() (string, error) { return "", errRandr }
+	busFail := func() (string, error) { return "", errBus }
+
+	// randr 可用
+	name, err := resolvePrimaryScreenName(true, fromRandr, fromBus)
+	assert.NoError(t, err)
+	assert.Equal(t, "eDP-1", name)
+
+	// randr 失败，使用 Display1
+	name, err = resolvePrimaryScreenName(true, randrFail, fromBus)
+	assert.NoError(t, err)
+	assert.Equal(t, "HDMI-1", name)
+	_, err = resolvePrimaryScreenName(true, randrFail, busFail)
+	assert.Equal(t, errBus, err)
+
+	// 没有 randr 扩展
+	name, err = resolvePrimaryScreenName(false, func() (string, error) {
+		t.Error("randr should not be used")
+		return "", errRandr
+	}, fromBus)
+	assert.NoError(t, err)
+	assert.Equal(t, "HDMI-1", name)
+	name, err = resolvePrimaryScreenName(false, randrFail, busFail)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultPrimaryScreenName, name)
+}
+
+func Test_parseScreenFactors(t *testing.T) {
+	tests := []struct {
+		name string
+		str  string
+		want map[string]float64
+	}{
+		{
+			name: "empty",
+			str:  "",
+			want: map[string]float64{},
+		},
+		{
+			name: "spaces around delimiters",
+			str:  "HDMI-1 = 1.5 ; eDP-1=1.25",
+			want: map[string]float64{"HDMI-1": 1.5, "eDP-1": 1.25},
+		},
+		{
+			name: "trailing semicolons",
+			str:  "eDP-1=2;;HDMI-1=1;",
+			want: map[string]float64{"eDP-1": 2, "HDMI-1": 1},
+		},
+		{
+			name: "windows line endings",
+			str:  "eDP-1=2\r\n;\tHDMI-1=1.25\r\n",
+			want: map[string]float64{"eDP-1": 2, "HDMI-1": 1.25},
+		},
+		{
+			name: "malformed entries",
+			str:  "eDP-1=abc;HDMI-1;=2;DP-1=1.5",
+			want: map[string]float64{"DP-1": 1.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseScreenFactors(tt.str))
+		})
+	}
+}
+
+type fakePlymouthScaler struct {
+	mu     sync.Mutex
+	scales []uint32
+	err    error
+}
+
+func (f *fakePlymouthScaler) ScalePlymouth(flags dbus.Flags, scale uint32) error {
+	f.mu.Lock()
+	f.scales = append(f.scales, scale)
+	f.mu.Unlock()
+	return f.err
+}
+
+func (f *fakePlymouthScaler) getScales() []uint32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]uint32(nil), f.scales...)
+}
+
+type fakeGreeterThemeUpdater struct {
+	data chan []byte
+}
+
+func (f *fakeGreeterThemeUpdater) UpdateGreeterQtTheme(flags dbus.Flags, fd dbus.UnixFD) error {
+	buf := make([]byte, 4096)
+	n, err := syscall.Pread(int(fd), buf, 0)
+	if err != nil {
+		return err
+	}
+	f.data <- buf[:n]
+	return nil
+}
+
+func (f *fakeGreeterThemeUpdater) ServiceName_() string {
+	return greeterService
+}
+
+// 没有安装 schema 时创建 gio.Settings 会直接退出进程。
+// 安装的是旧版本的 schema 时，读写新增的键同样会退出进程，所以也要求仓库中 schema 的键都已安装。
+func requireGSettingsSchemas(t *testing.T, schemas ...string) {
+	installed := gio.SettingsListSchemas()
+	for _, schema := range schemas {
+		if !strv.Strv(installed).Contains(schema) {
+			t.Skipf("gsettings schema %s is not installed", schema)
+		}
+		keys := getRepoSchemaKeys(t, schema)
+		if len(keys) == 0 {
+			continue
+		}
+		s := gio.NewSettings(schema)
+		installedKeys := strv.Strv(s.ListKeys())
+		s.Unref()
+		for _, key := range keys {
+			if !installedKeys.Contains(key) {
+				t.Skipf("gsettings key %s of %s is not installed", key, schema)
+			}
+		}
+	}
+}
+
+// 仓库中 schema 定义的键，schema 不在仓库中时返回空
+func getRepoSchemaKeys(t *testing.T, schema string) []string {
+	data, err := ioutil.ReadFile(filepath.Join("../misc/schemas", schema+".gschema.xml"))
+	if err != nil {
+		return nil
+	}
+	var schemaList struct {
+		Schemas []struct {
+			ID   string `xml:"id,attr"`
+			Keys []struct {
+				Name string `xml:"name,attr"`
+			} `xml:"key"`
+		} `xml:"schema"`
+	}
+	require.NoError(t, xml.Unmarshal(data, &schemaList))
+	var keys []string
+	for _, s := range schemaList.Schemas {
+		if s.ID != schema {
+			continue
+		}
+		for _, key := range s.Keys {
+			keys = append(keys, key.Name)
+		}
+	}
+	return keys
+}
+
+func Test_setScreenScaleFactors_endToEnd(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema, startddeSchema, wrapGnomeInterfaceSchema)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(envPlymouthMaxScale, "")
+	envFile := filepath.Join(t.TempDir(), "dde-env")
+	getDdeEnvFileOld := getDdeEnvFile
+	gsOld := _gs
+	t.Cleanup(func() {
+		getDdeEnvFile = getDdeEnvFileOld
+		_gs = gsOld
+	})
+	getDdeEnvFile = func() string { return envFile }
+	require.NoError(t, userenv.SaveToFile(envFile, map[string]string{"QT_SCALE_FACTOR": "2"}))
+
+	emitter := &fakeSignalEmitter{}
+	plymouth := &fakePlymouthScaler{}
+	greeterUpdater := &fakeGreeterThemeUpdater{data: make(chan []byte, 1)}
+	helper := &fakeDsfHelper{}
+	m := &XSManager{
+		service:              emitter,
+		gs:                   gio.NewSettings(xsSchema),
+		startddeGs:           gio.NewSettings(startddeSchema),
+		greeter:              greeterUpdater,
+		sysDaemon:            plymouth,
+		dsfHelper:            helper,
+		plymouthScaleTimeout: time.Second,
+		greeterAvailable: func() (bool, error) {
+			return true, nil
+		},
+		connectedOutputs: func() ([]outputInfo, error) {
+			return []outputInfo{
+				{name: "eDP-1", mmWidth: 310, mmHeight: 174, width: 1920, height: 1080},
+				{name: "HDMI-1", mmWidth: 530, mmHeight: 300, width: 1920, height: 1080},
+			}, nil
+		},
+	}
+	_gs = m.gs
+	m.primaryScreenCache = newPrimaryScreenCache(func() (string, error) {
+		return "eDP-1", nil
+	})
+	m.qtThemeWriter = newQtThemeWriter(time.Hour, saveQtTheme, func(qt *qtThemeChange) error {
+		return m.updateGreeterQtThemeAsync(qt.kf)
+	})
+	m.plymouthSettler = newPlymouthSettler(m.setScaleFactorForPlymouth)
+	m.startddeGs.SetInt(gsKeyPlymouthSettle, 0)
+	m.startddeGs.SetBoolean(gsKeyXresourcesDpi, true)
+	defer m.startddeGs.Reset(gsKeyXresourcesDpi)
+	xresources := []byte("URxvt.font: xft:Monospace:size=10\n")
+	require.NoError(t, ioutil.WriteFile(getXResourcesFile(), xresources, 0600))
+	// memory 后端在同一个进程中共享，先恢复为 1 倍
+	m.setScaleFactor(1, 1, 24)
+	m.getWrapGDISettings().SetInt("cursor-size", 24)
+	m.gs.SetString(gsKeyIndividualScaling, "ALL=1.00")
+
+	factors := map[string]float64{"ALL": 2, "eDP-1": 2, "HDMI-1": 1.5}
+	err := m.setScreenScaleFactors(factors, true)
+	require.NoError(t, err)
+
+	// display 模块、gsettings、qt-theme.ini 和环境变量
+	assert.Equal(t, []map[string]float64{factors}, helper.history)
+	assert.Equal(t, 2.0, m.gs.GetDouble(gsKeyScaleFactor))
+	assert.Equal(t, int32(2), m.gs.GetInt(gsKeyWindowScale))
+	assert.Equal(t, int32(48), m.gs.GetInt(gsKeyGtkCursorThemeSize))
+	assert.Equal(t, int32(48), m.getWrapGDISettings().GetInt("cursor-size"))
+	assert.Equal(t, "ALL=2.00;HDMI-1=1.50;eDP-1=2.00", m.gs.GetString(gsKeyIndividualScaling))
+	qtFactors, err := loadQtScreenScaleFactors(getQtThemeFile())
+	require.NoError(t, err)
+	assert.Equal(t, factors, qtFactors)
+	ue, err := userenv.LoadFromFile(envFile)
+	require.NoError(t, err)
+	assert.NotContains(t, ue, "QT_SCALE_FACTOR")
+	_, err = os.Stat(getScaleMarkerFile())
+	assert.True(t, os.IsNotExist(err))
+	// ~/.Xresources 合并而不是覆盖，保留原来的权限
+	content, err := ioutil.ReadFile(getXResourcesFile())
+	require.NoError(t, err)
+	assert.Equal(t, "URxvt.font: xft:Monospace:size=10\nXft.dpi:\t192\n", string(content))
+	fi, err := os.Stat(getXResourcesFile())
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// greeter
+	require.NoError(t, m.qtThemeWriter.flush())
+	select {
+	case data := <-greeterUpdater.data:
+		kf := keyfile.NewKeyFile()
+		require.NoError(t, kf.LoadFromData(data))
+		value, err := kf.GetValue(qtThemeSection, qtThemeKeyScreenScaleFactors)
+		require.NoError(t, err)
+		greeterFactors, err := parseQtScreenScaleFactors(value)
+		require.NoError(t, err)
+		assert.Equal(t, factors, greeterFactors)
+	case <-time.After(5 * time.Second):
+		t.Fatal("greeter was not updated")
+	}
+
+	// Plymouth
+	assert.Eventually(t, func() bool {
+		m.plymouthScalingMu.Lock()
+		defer m.plymouthScalingMu.Unlock()
+		return !m.plymouthScaling
+	}, 5*time.Second, 10*time.Millisecond)
+	// Plymouth 主题已经是 2 倍时不会再调用系统服务
+	var wantScales []uint32
+	theme, err := getPlymouthTheme(plymouthConfigFile)
+	if err != nil || getPlymouthThemeScaleFactor(theme) != 2 {
+		wantScales = []uint32{2}
+	}
+	assert.Equal(t, wantScales, plymouth.getScales())
+
+	// 信号
+	signals := emitter.getSignals()
+	require.NotEmpty(t, signals)
+	assert.Equal(t, signalSetScaleFactorStarted, signals[0])
+	assert.Contains(t, signals, signalScaleFactorChanged)
+	assert.Contains(t, signals, signalCursorSizeChanged)
+	// Plymouth 在后台完成，SetScaleFactorDone 可能早于 ScaleFactorChanged，只检查它是最后一个进度相关的信号
+	var last string
+	for _, name := range signals {
+		switch name {
+		case signalSetScaleFactorStarted, signalSetScaleFactorProgress, signalSetScaleFactorDone:
+			last = name
+		}
+	}
+	assert.Equal(t, signalSetScaleFactorDone, last)
+	progress := getEmittedProgress(emitter)
+	assert.Equal(t, int32(scaleProgressDone), progress[len(progress)-1])
+}
+
+func Test_PlymouthScaleFailed(t *testing.T) {
+	emitter := &fakeSignalEmitter{}
+	plymouth := &fakePlymouthScaler{err: errors.New("failed to update initramfs")}
+	m := &XSManager{service: emitter, sysDaemon: plymouth}
+	// 与当前主题的缩放不同才会调用系统服务
+	factor := 2
+	theme, err := getPlymouthTheme(plymouthConfigFile)
+	if err == nil && getPlymouthThemeScaleFactor(theme) == factor {
+		factor = 1
+	}
+
+	m.setScaleFactorForPlymouthReal(factor, true)
+	assert.Equal(t, []uint32{uint32(factor)}, plymouth.getScales())
+	assert.Equal(t, "failed to update initramfs", m.getLastPlymouthScaleError())
+	assert.Contains(t, emitter.getSignals(), signalPlymouthScaleFailed)
+	for i, name := range emitter.getSignals() {
+		if name == signalPlymouthScaleFailed {
+			assert.Equal(t, []interface{}{"failed to update initramfs"}, emitter.values[i])
+		}
+	}
+
+	// 不发送信号时仍然记录
+	emitter = &fakeSignalEmitter{}
+	m.service = emitter
+	plymouth.err = errors.New("timeout")
+	m.setScaleFactorForPlymouthReal(factor, false)
+	assert.Equal(t, "timeout", m.getLastPlymouthScaleError())
+	assert.Empty(t, emitter.getSignals())
+
+	m.setLastPlymouthScaleError(nil)
+	assert.Equal(t, "", m.getLastPlymouthScaleError())
+}
+
+func Test_CursorSizeChanged(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema, wrapGnomeInterfaceSchema)
+
+	emitter := &fakeSignalEmitter{}
+	m := &XSManager{service: emitter, gs: gio.NewSettings(xsSchema)}
+	m.setScaleFactor(1, 1, 24)
+	emitter = &fakeSignalEmitter{}
+	m.service = emitter
+
+	// 只写入设置，不发送信号
+	m.setScaleFactor(2, 2, 48)
+	assert.Equal(t, int32(48), m.getGtkCursorThemeSize())
+	assert.Empty(t, emitter.getSignals())
+
+	m.emitCursorSizeChanged(24, 48, true)
+	assert.Equal(t, []string{signalCursorSizeChanged}, emitter.getSignals())
+	assert.Equal(t, []interface{}{int32(48)}, emitter.values[0])
+
+	// 大小不变或者不需要发送信号时不发送
+	m.emitCursorSizeChanged(48, 48, true)
+	m.emitCursorSizeChanged(24, 48, false)
+	assert.Len(t, emitter.getSignals(), 1)
+}
+
+func Test_adjustScaleFactor_qtTheme(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema, startddeSchema)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	envFile := filepath.Join(t.TempDir(), "dde-env")
+	getDdeEnvFileOld := getDdeEnvFile
+	gsOld := _gs
+	t.Cleanup(func() {
+		getDdeEnvFile = getDdeEnvFileOld
+		_gs = gsOld
+	})
+	getDdeEnvFile = func() string { return envFile }
+
+	m := &XSManager{
+		gs:         gio.NewSettings(xsSchema),
+		startddeGs: gio.NewSettings(startddeSchema),
+		greeterAvailable: func() (bool, error) {
+			return false, nil
+		},
+	}
+	_gs = m.gs
+	m.qtThemeWriter = newQtThemeWriter(time.Hour, saveQtTheme, func(qt *qtThemeChange) error {
+		return nil
+	})
+	m.gs.SetDouble(gsKeyScaleFactor, 2)
+
+	// 迁移旧的配置
+	t.Setenv("STARTDDE_MIGRATE_SCALE_FACTOR", "1")
+	m.adjustScaleFactor(defaultScaleFactor)
+	factors, err := loadQtScreenScaleFactors(getQtThemeFile())
+	require.NoError(t, err)
+	assert.Equal(t, singleToMapSF(2), factors)
+
+	// greeter 还没有 qt-theme.ini
+	if _, err := os.Stat(greeterQtThemeFile); err == nil {
+		t.Skip("greeter qt-theme.ini exists")
+	}
+	t.Setenv("STARTDDE_MIGRATE_SCALE_FACTOR", "")
+	require.NoError(t, os.Remove(getQtThemeFile()))
+	m.gs.SetDouble(gsKeyScaleFactor, 1.5)
+	m.adjustScaleFactor(defaultScaleFactor)
+	factors, err = loadQtScreenScaleFactors(getQtThemeFile())
+	require.NoError(t, err)
+	assert.Equal(t, singleToMapSF(1.5), factors)
+}
+
+func Test_setScalingMode(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	requireGSettingsSchemas(t, xsSchema, startddeSchema, wrapGnomeInterfaceSchema)
+	envFile := filepath.Join(t.TempDir(), "dde-env")
+	getDdeEnvFileOld := getDdeEnvFile
+	gsOld := _gs
+	t.Cleanup(func() {
+		getDdeEnvFile = getDdeEnvFileOld
+		_gs = gsOld
+	})
+	getDdeEnvFile = func() string { return envFile }
+
+	emitter := &fakeSignalEmitter{}
+	m := &XSManager{
+		service:    emitter,
+		gs:         gio.NewSettings(xsSchema),
+		startddeGs: gio.NewSettings(startddeSchema),
+		dsfHelper:  &fakeDsfHelper{},
+		greeterAvailable: func() (bool, error) {
+			return false, nil
+		},
+		connectedOutputs: func() ([]outputInfo, error) {
+			return nil, nil
+		},
+	}
+	_gs = m.gs
+	m.primaryScreenCache = newPrimaryScreenCache(func() (string, error) {
+		return "eDP-1", nil
+	})
+	m.qtThemeWriter = newQtThemeWriter(time.Hour, saveQtTheme, func(qt *qtThemeChange) error {
+		return nil
+	})
+	m.plymouthSettler = newPlymouthSettler(func(factor int, emitSignal bool) {})
+	m.startddeGs.SetString(gsKeyScalingMode, scalingModeIndividual)
+	m.gs.SetString(gsKeyIndividualScaling, "HDMI-1=1.00;eDP-1=2.00")
+
+	// 锁定时不改变模式
+	m.startddeGs.SetBoolean(gsKeyScaleLocked, true)
+	assert.True(t, errors.Is(m.setScalingMode(scalingModeUnified), ErrScaleLocked))
+	assert.Equal(t, scalingModeIndividual, m.getScalingMode())
+	m.startddeGs.SetBoolean(gsKeyScaleLocked, false)
+
+	// 合并后的设置提交失败时不改变模式
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	require.NoError(t, ioutil.WriteFile(blocker, nil, 0644))
+	t.Setenv("XDG_CONFIG_HOME", blocker)
+	assert.Error(t, m.setScalingMode(scalingModeUnified))
+	assert.Equal(t, scalingModeIndividual, m.getScalingMode())
+	assert.Equal(t, "HDMI-1=1.00;eDP-1=2.00", m.gs.GetString(gsKeyIndividualScaling))
+	assert.NotContains(t, emitter.getSignals(), signalScalingModeChanged)
+	// 提交失败时结束这一次设置，之后重新发送 SetScaleFactorStarted
+	signals := emitter.getSignals()
+	assert.Equal(t, []string{signalSetScaleFactorFailed, signalSetScaleFactorProgress, signalSetScaleFactorDone},
+		signals[len(signals)-3:])
+	assert.False(t, m.scaleProgress.started)
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	require.NoError(t, m.setScalingMode(scalingModeUnified))
+	assert.Equal(t, scalingModeUnified, m.getScalingMode())
+	assert.Equal(t, "ALL=2.00", m.gs.GetString(gsKeyIndividualScaling))
+	assert.Equal(t, signalScalingModeChanged, emitter.signals[len(emitter.signals)-1])
+}
+
+func Test_setScaleFactorForOutput(t *testing.T) {
+	t.Setenv("GSETTINGS_BACKEND", "memory")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	requireGSettingsSchemas(t, xsSchema, startddeSchema, wrapGnomeInterfaceSchema)
+	envFile := filepath.Join(t.TempDir(), "dde-env")
+	getDdeEnvFileOld := getDdeEnvFile
+	gsOld := _gs
+	t.Cleanup(func() {
+		getDdeEnvFile = getDdeEnvFileOld
+		_gs = gsOld
+	})
+	getDdeEnvFile = func() string { return envFile }
+
+	m := &XSManager{
+		service:    &fakeSignalEmitter{},
+		gs:         gio.NewSettings(xsSchema),
+		startddeGs: gio.NewSettings(startddeSchema),
+		dsfHelper:  &fakeDsfHelper{},
+		greeterAvailable: func() (bool, error) {
+			return false, nil
+		},
+		connectedOutputs: func() ([]outputInfo, error) {
+			return []outputInfo{{name: "eDP-1"}, {name: "HDMI-1"}}, nil
+		},
+	}
+	_gs = m.gs
+	m.primaryScreenCache = newPrimaryScreenCache(func() (string, error) {
+		return "eDP-1", nil
+	})
+	m.qtThemeWriter = newQtThemeWriter(time.Hour, saveQtTheme, func(qt *qtThemeChange) error {
+		return nil
+	})
+	m.plymouthSettler = newPlymouthSettler(func(factor int, emitSignal bool) {})
+	m.scaleApplier = newScaleApplier(func(factors map[string]float64) error {
+		return m.setScreenScaleFactorsNoFlush(factors, true)
+	})
+	m.startddeGs.SetBoolean(gsKeyScaleLocked, false)
+	m.startddeGs.SetString(gsKeyScalingMode, scalingModeIndividual)
+	m.gs.SetString(gsKeyIndividualScaling, "HDMI-1=1.00;eDP-1=2.00")
+
+	// 在等待中的值的基础上只修改指定的屏幕
+	require.NoError(t, m.scaleApplier.schedule(time.Hour, map[string]float64{"eDP-1": 1.5, "HDMI-1": 1}))
+	require.NoError(t, m.setScaleFactorForOutput("HDMI-1", 1.25))
+	assert.Equal(t, map[string]float64{"eDP-1": 1.5, "HDMI-1": 1.25}, m.getScreenScaleFactors())
+
+	assert.Error(t, m.setScaleFactorForOutput("DP-1", 2))
+	assert.Equal(t, map[string]float64{"eDP-1": 1.5, "HDMI-1": 1.25}, m.getScreenScaleFactors())
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/linuxdeepin/go-lib/xdg/basedir"
+)
+
+// 记录最后一次成功修改缩放的时间，供界面显示
+func getScaleChangeTimeFile() string {
+	return filepath.Join(basedir.GetUserConfigDir(), "deepin/startdde/scale-last-change")
+}
+
+func loadScaleChangeTime(filename string) (time.Time, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+}
+
+// updateScaleChangeTime 缩放比例与 old 相同时不更新，返回是否更新了时间
+func updateScaleChangeTime(filename string, old, factors map[string]float64, now time.Time) (bool, error) {
+	if reflect.DeepEqual(old, factors) {
+		return false, nil
+	}
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return false, err
+	}
+	err = ioutil.WriteFile(filename, []byte(now.Format(time.RFC3339)+"\n"), 0644)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func getLastScaleChangeTime() (time.Time, error) {
+	return loadScaleChangeTime(getScaleChangeTimeFile())
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_updateScaleChangeTime(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "startdde/scale-last-change")
+	_, err := loadScaleChangeTime(filename)
+	assert.True(t, os.IsNotExist(err))
+
+	t1 := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	updated, err := updateScaleChangeTime(filename, singleToMapSF(1), singleToMapSF(1.25), t1)
+	require.NoError(t, err)
+	assert.True(t, updated)
+	got, err := loadScaleChangeTime(filename)
+	require.NoError(t, err)
+	assert.True(t, t1.Equal(got))
+
+	// 没有变化
+	t2 := t1.Add(time.Hour)
+	updated, err = updateScaleChangeTime(filename, singleToMapSF(1.25), singleToMapSF(1.25), t2)
+	require.NoError(t, err)
+	assert.False(t, updated)
+	got, err = loadScaleChangeTime(filename)
+	require.NoError(t, err)
+	assert.True(t, t1.Equal(got))
+
+	updated, err = updateScaleChangeTime(filename, singleToMapSF(1.25),
+		map[string]float64{"eDP-1": 2, "HDMI-1": 1}, t2)
+	require.NoError(t, err)
+	assert.True(t, updated)
+	got, err = loadScaleChangeTime(filename)
+	require.NoError(t, err)
+	assert.True(t, t2.Equal(got))
+}
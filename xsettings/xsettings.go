@@ -9,6 +9,8 @@ import (
 	"os"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	dbus "github.com/godbus/dbus/v5"
 	ddeSysDaemon "github.com/linuxdeepin/go-dbus-factory/system/org.deepin.dde.daemon1"
@@ -20,6 +22,7 @@ import (
 	x "github.com/linuxdeepin/go-x11-client"
 )
 
+//go:generate dbusutil-gen -output xsettings_dbusutil.go -type XSManager xsettings.go
 //go:generate dbusutil-gen em -type XSManager
 
 const (
@@ -31,30 +34,91 @@ const (
 	xsDBusIFC     = xsDBusService
 )
 
-type displayScaleFactorsHelper interface {
+// scaleFactorStore 由 display 模块实现，保存各屏幕的缩放
+type scaleFactorStore interface {
 	SetScaleFactors(factors map[string]float64) error
 	GetScaleFactors() (map[string]float64, error)
+}
+
+type displayScaleFactorsHelper interface {
+	scaleFactorStore
 	SetChangedCb(fn func(factors map[string]float64) error)
 }
 
+// plymouthScaler 由系统服务 org.deepin.dde.Daemon1 实现
+type plymouthScaler interface {
+	ScalePlymouth(flags dbus.Flags, scale uint32) error
+}
+
+// greeterThemeUpdater 由 org.deepin.dde.Greeter1 实现
+type greeterThemeUpdater interface {
+	UpdateGreeterQtTheme(flags dbus.Flags, fd dbus.UnixFD) error
+	ServiceName_() string
+}
+
 var logger = log.NewLogger("xsettings")
 
+// signalEmitter 由 *dbusutil.Service 实现
+type signalEmitter interface {
+	Emit(v dbusutil.Implementer, signalName string, values ...interface{}) error
+	EmitPropertyChanged(v dbusutil.Implementer, propertyName string, value interface{}) error
+}
+
 // XSManager xsettings manager
 type XSManager struct {
-	service *dbusutil.Service
+	service signalEmitter
 	conn    *x.Conn
 	owner   x.Window
 
-	gs        *gio.Settings
-	greeter   greeter.Greeter
-	sysDaemon ddeSysDaemon.Daemon
-
+	gs         *gio.Settings
+	startddeGs *gio.Settings
+	greeter    greeterThemeUpdater
+	sysDaemon  plymouthScaler
+	// greeter 的服务是否存在，为 nil 时认为存在
+	greeterAvailable func() (bool, error)
+	// 获取已连接的屏幕，为 nil 时通过 randr 获取
+	connectedOutputs func() ([]outputInfo, error)
+	// com.deepin.wrap.gnome.desktop.interface，通过 getWrapGDISettings 获取
+	wrapGDI     *gio.Settings
+	wrapGDIOnce sync.Once
+
+	// 等待系统服务设置 Plymouth 的最长时间，0 表示一直等待
+	plymouthScaleTimeout time.Duration
 	plymouthScalingMu    sync.Mutex
 	plymouthScalingTasks []int
 	plymouthScaling      bool
+	plymouthUnavailable  atomic.Bool // 本次会话中无法设置 Plymouth
+	// 最近一次设置 Plymouth 失败的原因，成功后清空
+	plymouthErrMu        sync.Mutex
+	lastPlymouthScaleErr string
+
+	PropsMu sync.RWMutex
+	// 等待设置 Plymouth 的任务数
+	PlymouthQueueDepth int32
+	// 最近一次设置缩放时是否成功同步给了 display 模块，为 false 时两者不一致
+	DsfHelperApplied bool
 
 	restartOSD bool // whether to restart dde-osd
 
+	scaleLockOverride bool // 忽略缩放锁定
+
+	// 串行化对缩放相关 gsettings 的写入，避免并发的设置交错写入，各处的值互相不一致
+	scaleMu sync.Mutex
+
+	scaleProgress scaleProgressState
+
+	qtThemeWriter   *qtThemeWriter
+	scaleApplier    *scaleApplier
+	plymouthSettler *plymouthSettler
+	themeReasserter *debouncer
+	scaleScheduler  *scaleScheduler
+	scheduleResume  *scheduleResumeMonitor
+	tempCursorSize  *temporaryCursorSize
+
+	screenFactorsCache *screenFactorsCache
+	primaryScreenCache *primaryScreenCache
+	knownOutputs       *outputTracker
+
 	// locker for xsettings prop read and write
 	settingsLocker sync.RWMutex
 	dsfHelper      displayScaleFactorsHelper
@@ -62,6 +126,24 @@ type XSManager struct {
 	//nolint
 	signals *struct {
 		SetScaleFactorStarted, SetScaleFactorDone struct{}
+		ScaleFactorChanged                        struct {
+			oldFactors, newFactors string
+		}
+		SetScaleFactorProgress struct {
+			progress int32
+		}
+		ScalingModeChanged struct {
+			mode string
+		}
+		PlymouthScaleFailed struct {
+			errMsg string
+		}
+		SetScaleFactorFailed struct {
+			errMsg string
+		}
+		CursorSizeChanged struct {
+			size int32
+		}
 	}
 }
 
@@ -73,11 +155,35 @@ type xsSetting struct {
 
 func NewXSManager(conn *x.Conn, recommendedScaleFactor float64, service *dbusutil.Service, helper displayScaleFactorsHelper) (*XSManager, error) {
 	var m = &XSManager{
-		conn:      conn,
-		service:   service,
-		gs:        _gs,
-		dsfHelper: helper,
+		conn:       conn,
+		service:    service,
+		gs:         _gs,
+		startddeGs: gio.NewSettings(startddeSchema),
+		dsfHelper:  helper,
+
+		scaleLockOverride: os.Getenv(envScaleLockOverride) == "1",
+		DsfHelperApplied:  true,
 	}
+	m.getWrapGDISettings()
+	m.qtThemeWriter = newQtThemeWriter(qtThemeWriteDelay, saveQtTheme, func(qt *qtThemeChange) error {
+		return m.updateGreeterQtThemeAsync(qt.kf)
+	})
+	m.plymouthSettler = newPlymouthSettler(m.setScaleFactorForPlymouth)
+	m.scaleApplier = newScaleApplier(func(factors map[string]float64) error {
+		return m.setScreenScaleFactorsNoFlush(factors, true)
+	})
+	m.themeReasserter = newDebouncer(themeReassertDelay, m.reassertScale)
+	m.tempCursorSize = newTemporaryCursorSize(func(size int32) {
+		m.scaleMu.Lock()
+		defer m.scaleMu.Unlock()
+		oldSize := m.getGtkCursorThemeSize()
+		m.setCursorSize(size)
+		m.emitCursorSizeChanged(oldSize, size, true)
+	}, m.deriveCurrentCursorSize)
+	m.screenFactorsCache = newScreenFactorsCache(m.loadScreenFactors)
+	m.primaryScreenCache = newPrimaryScreenCache(func() (string, error) {
+		return getPrimaryScreenName(m.conn)
+	})
 
 	var err error
 	m.owner, err = createSettingWindow(m.conn)
@@ -96,10 +202,33 @@ func NewXSManager(conn *x.Conn, recommendedScaleFactor float64, service *dbusuti
 		return nil, err
 	}
 	m.greeter = greeter.NewGreeter(systemBus)
+	m.greeterAvailable = func() (bool, error) {
+		return systemBusNameAvailable(systemBus, greeterService)
+	}
 	m.sysDaemon = ddeSysDaemon.NewDaemon(systemBus)
+	m.plymouthScaleTimeout = m.getPlymouthScaleTimeout()
 
 	m.handleLocalCenterSF()
 	m.adjustScaleFactor(recommendedScaleFactor)
+	m.recoverScaleChange()
+	m.scheduleResume = newScheduleResumeMonitor(systemBus, func() {
+		m.scaleScheduler.reevaluate()
+	})
+	m.initScaleSchedule()
+	m.reconcileScaleByEdid()
+	m.reassertScale()
+	m.clearPlymouthRebootPending()
+	_, err = m.screenFactorsCache.get()
+	if err != nil {
+		logger.Warning("failed to resolve screen scale factors:", err)
+	}
+	// 启动时已经连接的屏幕不作为新的屏幕处理
+	connected, err := m.getConnectedOutputNames()
+	if err != nil {
+		logger.Warning("failed to get connected outputs:", err)
+	}
+	m.knownOutputs = newOutputTracker(connected)
+	m.listenOutputChanges()
 	err = m.setSettings(m.getSettingsInSchema())
 	if err != nil {
 		logger.Warning("Change xsettings property failed:", err)
@@ -192,7 +321,7 @@ func (m *XSManager) adjustScaleFactor(recommendedScaleFactor float64) {
 	// migrate old configuration
 	if os.Getenv("STARTDDE_MIGRATE_SCALE_FACTOR") != "" {
 		scaleFactor := getScaleFactor()
-		err = m.setScreenScaleFactorsForQt(map[string]float64{"": scaleFactor})
+		err = m.saveQtScaleFactor(scaleFactor)
 		if err != nil {
 			logger.Warning("failed to set scale factor for qt:", err)
 		}
@@ -204,13 +333,13 @@ func (m *XSManager) adjustScaleFactor(recommendedScaleFactor float64) {
 		return
 	}
 
-	_, err = os.Stat("/etc/lightdm/deepin/qt-theme.ini")
+	_, err = os.Stat(greeterQtThemeFile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// lightdm-deepin-greeter does not have the qt-theme.ini file yet.
 			scaleFactor := getScaleFactor()
 			if scaleFactor != defaultScaleFactor {
-				err = m.setScreenScaleFactorsForQt(map[string]float64{"": scaleFactor})
+				err = m.saveQtScaleFactor(scaleFactor)
 				if err != nil {
 					logger.Warning("failed to set scale factor for qt:", err)
 				}
@@ -221,6 +350,15 @@ func (m *XSManager) adjustScaleFactor(recommendedScaleFactor float64) {
 	}
 }
 
+// 启动时立即把单值写入 qt-theme.ini
+func (m *XSManager) saveQtScaleFactor(scaleFactor float64) error {
+	err := m.setScreenScaleFactorsForQt(singleToMapSF(scaleFactor))
+	if err != nil {
+		return err
+	}
+	return m.qtThemeWriter.flush()
+}
+
 func (m *XSManager) setSettings(settings []xsSetting) error {
 	m.settingsLocker.Lock()
 	defer m.settingsLocker.Unlock()
@@ -285,11 +423,19 @@ func (m *XSManager) getSettingsInSchema() []xsSetting {
 
 func (m *XSManager) handleGSettingsChanged() {
 	gsettings.ConnectChanged(xsSchema, "*", func(key string) {
+		if key == gsKeyIndividualScaling || key == gsKeyScaleFactor {
+			m.screenFactorsCache.invalidate()
+		}
+		if isThemeGSKey(key) {
+			// 主题切换完成后再重新设置缩放相关的值
+			m.themeReasserter.trigger()
+		}
 		switch key {
 		case "xft-dpi":
 			return
 		case gsKeyScaleFactor:
 			// 删除m.updateDPI()，保证设置屏幕缩放比例不会立刻生效
+			m.checkWindowScaleMismatch()
 			return
 		case "gtk-cursor-theme-name":
 			updateXResources(xresourceInfos{
@@ -303,6 +449,7 @@ func (m *XSManager) handleGSettingsChanged() {
 			return
 		case gsKeyWindowScale:
 			// 删除m.updateDPI()，保证设置屏幕缩放比例不会立刻生效
+			m.checkWindowScaleMismatch()
 			return
 		}
 		info := gsInfos.getByGSKey(key)
@@ -343,11 +490,13 @@ func Start(conn *x.Conn, recommendedScaleFactor float64, service *dbusutil.Servi
 	err = service.Export(xsDBusPath, m)
 	if err != nil {
 		logger.Warning("export XSManager failed:", err)
+		m.destroy()
 		return nil, err
 	}
 
 	err = service.RequestName(xsDBusService)
 	if err != nil {
+		m.destroy()
 		return nil, err
 	}
 
@@ -355,6 +504,16 @@ func Start(conn *x.Conn, recommendedScaleFactor float64, service *dbusutil.Servi
 	return m, nil
 }
 
+func (m *XSManager) destroy() {
+	if m.scheduleResume != nil {
+		m.scheduleResume.stop()
+	}
+	if m.wrapGDI != nil {
+		m.wrapGDI.Unref()
+		m.wrapGDI = nil
+	}
+}
+
 func (m *XSManager) NeedRestartOSD() bool {
 	if m == nil {
 		return false
@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// Code generated by "dbusutil-gen -output xsettings_dbusutil.go -type XSManager xsettings.go"; DO NOT EDIT.
+
+package xsettings
+
+func (v *XSManager) setPropPlymouthQueueDepth(value int32) (changed bool) {
+	if v.PlymouthQueueDepth != value {
+		v.PlymouthQueueDepth = value
+		v.emitPropChangedPlymouthQueueDepth(value)
+		return true
+	}
+	return false
+}
+
+func (v *XSManager) emitPropChangedPlymouthQueueDepth(value int32) error {
+	return v.service.EmitPropertyChanged(v, "PlymouthQueueDepth", value)
+}
+
+func (v *XSManager) setPropDsfHelperApplied(value bool) (changed bool) {
+	if v.DsfHelperApplied != value {
+		v.DsfHelperApplied = value
+		v.emitPropChangedDsfHelperApplied(value)
+		return true
+	}
+	return false
+}
+
+func (v *XSManager) emitPropChangedDsfHelperApplied(value bool) error {
+	return v.service.EmitPropertyChanged(v, "DsfHelperApplied", value)
+}
@@ -75,6 +75,9 @@ func (m *XSManager) updateDPI() {
 func (m *XSManager) updateXResources() {
 	scaleFactor := m.gs.GetDouble(gsKeyScaleFactor)
 	xftDpi := int(DPI_FALLBACK * scaleFactor)
+	if m.getScaleConfig().xresourcesFileDpi {
+		updateXResourcesFileDpi(scaleFactor)
+	}
 	updateXResources(xresourceInfos{
 		&xresourceInfo{
 			key:   "Xcursor.theme",
@@ -91,6 +94,64 @@ func (m *XSManager) updateXResources() {
 	})
 }
 
+func getXResourcesFile() string {
+	return path.Join(os.Getenv("HOME"), ".Xresources")
+}
+
+// 只修改 key 对应的行，保留文件中其他的资源、注释和预处理指令
+func mergeXResourcesData(data, key, value string) string {
+	lines := strings.Split(data, "\n")
+	target := key + ":\t" + value
+	found := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '!' || trimmed[0] == '#' {
+			continue
+		}
+		idx := strings.Index(trimmed, ":")
+		if idx < 0 || strings.TrimSpace(trimmed[:idx]) != key {
+			continue
+		}
+		lines[i] = target
+		found = true
+	}
+	if found {
+		return strings.Join(lines, "\n")
+	}
+
+	if data != "" && !strings.HasSuffix(data, "\n") {
+		data += "\n"
+	}
+	return data + target + "\n"
+}
+
+// 从登录 shell 启动的程序可能通过 xrdb 重新加载 ~/.Xresources
+func updateXResourcesFileDpi(scaleFactor float64) {
+	xftDpi := int(DPI_FALLBACK * scaleFactor)
+	err := mergeXResourcesFile(getXResourcesFile(), "Xft.dpi", strconv.Itoa(xftDpi))
+	if err != nil {
+		logger.Warning("failed to update ~/.Xresources:", err)
+	}
+}
+
+func mergeXResourcesFile(filename, key, value string) error {
+	var mode os.FileMode = 0644
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+	} else if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	data := mergeXResourcesData(string(contents), key, value)
+	if data == string(contents) {
+		return nil
+	}
+	return saveFileAtomicMode(filename, []byte(data), mode, nil)
+}
+
 var ffDir = path.Join(os.Getenv("HOME"), ".mozilla/firefox")
 
 func (m *XSManager) updateFirefoxDPI() {
@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package xsettings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mergeXResourcesData(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "empty",
+			data: "",
+			want: "Xft.dpi:\t192\n",
+		},
+		{
+			name: "append",
+			data: "! comment\nXTerm*faceName: Monospace",
+			want: "! comment\nXTerm*faceName: Monospace\nXft.dpi:\t192\n",
+		},
+		{
+			name: "replace",
+			data: "#include \".Xresources.d/colors\"\nXft.dpi : 96\nXft.antialias: 1\n",
+			want: "#include \".Xresources.d/colors\"\nXft.dpi:\t192\nXft.antialias: 1\n",
+		},
+		{
+			name: "commented",
+			data: "! Xft.dpi: 96\n",
+			want: "! Xft.dpi: 96\nXft.dpi:\t192\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, mergeXResourcesData(tt.data, "Xft.dpi", "192"))
+		})
+	}
+}
+
+func Test_mergeXResourcesFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ".Xresources")
+	err := ioutil.WriteFile(filename, []byte("URxvt.font: xft:Monospace:size=10\nXft.dpi: 96\n"), 0600)
+	require.NoError(t, err)
+
+	err = mergeXResourcesFile(filename, "Xft.dpi", "144")
+	require.NoError(t, err)
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "URxvt.font: xft:Monospace:size=10\nXft.dpi:\t144\n", string(content))
+
+	fi, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// 文件不存在时创建
+	newFile := filepath.Join(t.TempDir(), ".Xresources")
+	err = mergeXResourcesFile(newFile, "Xft.dpi", "96")
+	require.NoError(t, err)
+	content, err = ioutil.ReadFile(newFile)
+	require.NoError(t, err)
+	assert.Equal(t, "Xft.dpi:\t96\n", string(content))
+}
@@ -7,6 +7,8 @@ package xsettings
 import (
 	"errors"
 	"fmt"
+	"os"
+	"time"
 
 	dbus "github.com/godbus/dbus/v5"
 	"github.com/linuxdeepin/go-lib/dbusutil"
@@ -173,16 +175,289 @@ func (m *XSManager) GetScaleFactor() (float64, *dbus.Error) {
 }
 
 func (m *XSManager) SetScaleFactor(scale float64) *dbus.Error {
-	err := m.setScreenScaleFactors(singleToMapSF(scale), true)
+	err := m.scheduleScreenScaleFactors(singleToMapSF(scale))
 	return dbusutil.ToError(err)
 }
 
 func (m *XSManager) SetScreenScaleFactors(factors map[string]float64) *dbus.Error {
-	err := m.setScreenScaleFactors(factors, true)
+	err := m.scheduleScreenScaleFactors(factors)
 	return dbusutil.ToError(err)
 }
 
 func (m *XSManager) GetScreenScaleFactors() (map[string]float64, *dbus.Error) {
-	v := m.getScreenScaleFactors()
+	v := m.getScreenScaleFactorsOrSingle()
 	return v, nil
 }
+
+func (m *XSManager) GetGtkCursorThemeSize() (int32, *dbus.Error) {
+	return m.getGtkCursorThemeSize(), nil
+}
+
+func (m *XSManager) SetGtkCursorThemeSize(size int32) *dbus.Error {
+	err := m.setGtkCursorThemeSize(size)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetScreenScaleFactorEntries() ([]ScreenScaleFactorEntry, *dbus.Error) {
+	entries, err := m.getScreenScaleFactorEntries()
+	return entries, dbusutil.ToError(err)
+}
+
+func (m *XSManager) ApplyUserScaleFromGreeter(username string) *dbus.Error {
+	err := m.applyUserScaleFromGreeter(username)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) RepairWindowScale() *dbus.Error {
+	err := m.repairWindowScale()
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) ScaleSelfCheck() (ok bool, details []string, busErr *dbus.Error) {
+	ok, details = m.scaleSelfCheck()
+	return ok, details, nil
+}
+
+func (m *XSManager) SetScaleSchedule(entries []ScheduleEntry) *dbus.Error {
+	err := m.setScaleSchedule(entries)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetScaleSchedule() ([]ScheduleEntry, *dbus.Error) {
+	return m.scaleScheduler.getEntries(), nil
+}
+
+func (m *XSManager) ExportScaleConfig(path string) *dbus.Error {
+	err := m.exportScaleConfig(path)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) ImportScaleConfig(path string) *dbus.Error {
+	err := m.importScaleConfig(path)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetManagedScaleFiles() ([]string, *dbus.Error) {
+	return getManagedScaleFiles(m.getScaleConfig()), nil
+}
+
+func (m *XSManager) ScaleFactorForTargetDpi(screen string, targetDpi float64) (float64, *dbus.Error) {
+	scale, err := m.scaleFactorForTargetDpi(screen, targetDpi)
+	return scale, dbusutil.ToError(err)
+}
+
+func (m *XSManager) ResetQtScaleFromGsettings() *dbus.Error {
+	err := m.resetQtScaleFromGsettings()
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) ApplyScalePercent(percent int32) *dbus.Error {
+	err := m.applyScalePercent(percent)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) VerifyCleanScaleEnv() ([]string, *dbus.Error) {
+	return findLeftoverScaleEnv(os.LookupEnv), nil
+}
+
+func (m *XSManager) GetScaleRatioString() (string, *dbus.Error) {
+	return getScaleRatioString(getScaleFactor()), nil
+}
+
+func (m *XSManager) GetSpanningScaleFactor(screenA, screenB string) (float64, *dbus.Error) {
+	scale, err := m.getSpanningScaleFactor(screenA, screenB)
+	return scale, dbusutil.ToError(err)
+}
+
+func (m *XSManager) SetScaleFaultInjection(faults map[string]bool) *dbus.Error {
+	err := _scaleFaults.set(faults)
+	if err == nil {
+		// 缓存的主屏名称是在注入故障之前获取的
+		m.primaryScreenCache.invalidate()
+	}
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetScreenFactorSources() (map[string]string, *dbus.Error) {
+	sources, err := m.getScreenFactorSources()
+	return sources, dbusutil.ToError(err)
+}
+
+func (m *XSManager) SetScaleFactorLocked(locked bool) *dbus.Error {
+	err := m.setScaleFactorLocked(locked)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) SetGreeterScaleFactor(factor float64) *dbus.Error {
+	err := m.setGreeterScaleFactor(factor)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) ListScaleSignals() ([]string, *dbus.Error) {
+	return listScaleSignals(), nil
+}
+
+func (m *XSManager) IsPlymouthRebootPending() (bool, *dbus.Error) {
+	return m.isPlymouthRebootPending(), nil
+}
+
+func (m *XSManager) GetScaleFactorLabel(factor float64) (string, *dbus.Error) {
+	return getScaleFactorLabel(factor, m.getPrimaryRecommendedScaleFactor()), nil
+}
+
+func (m *XSManager) VerifyGreeterScale() (bool, *dbus.Error) {
+	ok, err := m.verifyGreeterScale()
+	return ok, dbusutil.ToError(err)
+}
+
+func (m *XSManager) ScaleFactorForTextHeightMm(screen string, mm float64) (float64, *dbus.Error) {
+	scale, err := m.scaleFactorForTextHeightMm(screen, mm)
+	return scale, dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetEffectiveScaleByToolkit() (map[string]float64, *dbus.Error) {
+	return m.getEffectiveScaleByToolkit(), nil
+}
+
+func (m *XSManager) GetDistinctScaleFactors() ([]float64, *dbus.Error) {
+	factors, err := m.getDistinctScaleFactors()
+	return factors, dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetCursorPreviewInfo(scale float64) (themeName string, size int32, busErr *dbus.Error) {
+	themeName, size, err := m.getCursorPreviewInfo(scale)
+	return themeName, size, dbusutil.ToError(err)
+}
+
+func (m *XSManager) EmitCurrentScaleState() *dbus.Error {
+	m.emitCurrentScaleState()
+	return nil
+}
+
+func (m *XSManager) ComputeScaleDerivatives(factor float64) (derivatives ScaleDerivatives, busErr *dbus.Error) {
+	derivatives, err := computeScaleDerivatives(factor)
+	return derivatives, dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetAppsNeedingRestart() (apps []string, busErr *dbus.Error) {
+	apps, err := getAppsNeedingRestart()
+	return apps, dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetScaleDefaults() (defaults ScaleDefaults, busErr *dbus.Error) {
+	return getScaleDefaults(), nil
+}
+
+func (m *XSManager) CompareScaleToReference(ref map[string]float64) (diffs []string, busErr *dbus.Error) {
+	diffs, err := m.compareScaleToReference(ref)
+	return diffs, dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetLastScaleChangeTime() (timestamp int64, busErr *dbus.Error) {
+	t, err := getLastScaleChangeTime()
+	if err != nil {
+		return 0, dbusutil.ToError(err)
+	}
+	return t.Unix(), nil
+}
+
+func (m *XSManager) GetScaleFactorRange() (min, max float64, busErr *dbus.Error) {
+	cfg := m.getScaleConfig()
+	return cfg.minFactor, cfg.maxFactor, nil
+}
+
+func (m *XSManager) SetScaleFactorForOutput(output string, factor float64) *dbus.Error {
+	err := m.setScaleFactorForOutput(output, factor)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetCachedScreenFactors() (factors map[string]float64, busErr *dbus.Error) {
+	factors, err := m.screenFactorsCache.get()
+	return factors, dbusutil.ToError(err)
+}
+
+func (m *XSManager) SetScreenDpis(dpis map[string]float64) *dbus.Error {
+	err := m.setScreenDpis(dpis)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) WouldPrimaryBlur() (blur bool, reason string, busErr *dbus.Error) {
+	blur, reason, err := m.wouldPrimaryBlur()
+	return blur, reason, dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetScreenScaleFactorsWithPrimary() (factors map[string]float64, primary string, busErr *dbus.Error) {
+	factors = m.getScreenScaleFactorsOrSingle()
+	primary, err := m.getPrimaryScreenName()
+	if err != nil {
+		logger.Warning("failed to get primary screen:", err)
+	}
+	return factors, primary, nil
+}
+
+func (m *XSManager) ResetCursorSizeToScale() *dbus.Error {
+	err := m.resetCursorSizeToScale()
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetAppScaleForScreen(screen string) (factor float64, busErr *dbus.Error) {
+	return m.getAppScaleForScreen(screen), nil
+}
+
+func (m *XSManager) ResetScaleFactor() *dbus.Error {
+	err := m.resetScaleFactor()
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) ApplyOemScaleRecommendation() (applied bool, busErr *dbus.Error) {
+	applied, err := m.applyOemScaleRecommendation()
+	return applied, dbusutil.ToError(err)
+}
+
+func (m *XSManager) SetTemporaryCursorSize(size int32, durationSeconds int32) *dbus.Error {
+	err := m.checkScaleChangeAllowed()
+	if err != nil {
+		return dbusutil.ToError(err)
+	}
+	err = m.tempCursorSize.start(size, time.Duration(durationSeconds)*time.Second)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) CancelTemporaryCursorSize() (cancelled bool, busErr *dbus.Error) {
+	return m.tempCursorSize.cancel(), nil
+}
+
+func (m *XSManager) VerifyIndividualScalingIntegrity() (ok bool, problems []string, busErr *dbus.Error) {
+	ok, problems = m.verifyIndividualScalingIntegrity()
+	return ok, problems, nil
+}
+
+func (m *XSManager) GetPrimaryScreenScaleFactor() (factor float64, busErr *dbus.Error) {
+	return m.getPrimaryScreenScaleFactor(), nil
+}
+
+func (m *XSManager) PreviewScaleFactors(factors map[string]float64) (preview string, busErr *dbus.Error) {
+	preview, err := m.previewScaleFactors(factors)
+	return preview, dbusutil.ToError(err)
+}
+
+func (m *XSManager) SetScalingMode(mode string) *dbus.Error {
+	err := m.setScalingMode(mode)
+	return dbusutil.ToError(err)
+}
+
+func (m *XSManager) GetScalingMode() (mode string, busErr *dbus.Error) {
+	return m.getScalingMode(), nil
+}
+
+func (m *XSManager) GetLastPlymouthScaleError() (errMsg string, busErr *dbus.Error) {
+	return m.getLastPlymouthScaleError(), nil
+}
+
+func (m *XSManager) GetCursorSize() (size int32, busErr *dbus.Error) {
+	return m.getGtkCursorThemeSize(), nil
+}
+
+func (m *XSManager) GetWindowScale() (windowScale int32, busErr *dbus.Error) {
+	return m.getWindowScale(), nil
+}